@@ -1,18 +1,23 @@
 package config
 
 import (
+	"context"
 	"io"
 	"time"
 
+	"github.com/mevansam/gocloud/backend"
 	"github.com/mevansam/gocloud/provider"
 	"github.com/appbricks/cloud-builder/cookbook"
 	"github.com/appbricks/cloud-builder/target"
+	"github.com/appbricks/cloud-builder/terraform"
 )
 
 // provides an interface for managing application configuration
 type Config interface {
 	Load() error
 	Save() error
+	SaveIfDirty() (bool, error)
+	SaveAllSections() error
 
 	EULAAccepted() bool
 	SetEULAAccepted()
@@ -22,27 +27,109 @@ type Config interface {
 
 	HasPassphrase() bool
 	SetPassphrase(passphrase string)
+	RotatePassphrase(oldGetPassphrase, newGetPassphrase GetPassphrase) error
+
+	ExportPlain(w io.Writer) error
+	ImportPlain(r io.Reader) error
+
+	AddRecipient(pubKey string) (string, error)
+	RemoveRecipient(id string) error
+	SetIdentity(privKey string) error
 
 	SetKeyTimeout(timeout time.Duration)
+	KeyTimeout() time.Duration
+	KeyExpiresAt() (time.Time, bool)
+
+	SetCompression(compress bool)
+	SetFieldEncryption(enabled bool)
+	SetKDFParams(params KDFParams)
+	SetIntegrityCheck(enabled bool)
+	SetLockTimeout(timeout time.Duration)
+	SetDeviceBinding(id string)
+
 	Context() Context
 }
 
 // provides an interface for managing the configuration context
 type Context interface {
 	Load(input io.Reader) error
+	LoadContext(ctx context.Context, input io.Reader) error
+	LoadWithProgress(input io.Reader, cb func(section string, count int)) error
+	LoadSection(input io.Reader, section string) error
+	LoadAt(r io.ReaderAt, size int64) error
+	LoadSectionAt(r io.ReaderAt, size int64, section string) error
 	Save(output io.Writer) error
+	SaveIfDirty(output io.Writer) (bool, error)
+	LoadNDJSON(r io.Reader) error
+	SaveNDJSON(w io.Writer) error
+	MarkAllDirty()
+	CheckSections() error
+	Compact() (int, error)
+	Fingerprint() (string, error)
+	Reset() error
+
+	LoadYAML(input io.Reader) error
+	SaveYAML(output io.Writer) error
+
+	Validate() []error
+	CookbookTimestamp() string
+	IsTargetCurrent(name string) (bool, error)
+	StaleTargets() []*target.Target
+	TargetsNeedingReapply() []*target.Target
 
 	Cookbook() *cookbook.Cookbook
 	GetCookbookRecipe(recipe, iaas string) (cookbook.Recipe, error)
 	SaveCookbookRecipe(recipe cookbook.Recipe)
+	SetRecipeCacheSize(n int)
+	ValidateRecipeInput(recipeName, iaas string, values map[string]string) []error
+	SearchRecipes(query string) []cookbook.Recipe
+	ListRecipes() []RecipeInfo
+	RecipeIaaSList(recipeName string) ([]string, error)
 
 	CloudProviderTemplates() []provider.CloudProvider
+	ConfiguredProviders() []provider.CloudProvider
+	ProviderCompleteness(iaas string) ([]string, error)
 	GetCloudProvider(iaas string) (provider.CloudProvider, error)
 	SaveCloudProvider(provider provider.CloudProvider)
+	DeleteProvider(iaas string, cascade bool) ([]string, error)
+	PatchProvider(iaas string, patches map[string]string) error
+	CloneProvider(iaas string, overrides map[string]string) (provider.CloudProvider, error)
+	TestProvider(iaas string) error
+	SetProviderExpiry(iaas string, expiresAt time.Time) error
+	ProviderExpiresAt(iaas string) (time.Time, bool)
+	ExpiredProviders() []string
+	SetCredentialEnvMapping(iaas string, mapping map[string]string)
+	SetCredentialEnvOverride(override bool)
+	SetProviderDefaultTags(iaas string, tags map[string]string)
+	RegisterValueResolver(fn func(field, value string) (string, error))
+	ExportProviderEnv(iaas string, w io.Writer, maskSensitive bool) error
+
+	CloudBackendTemplates() []backend.CloudBackend
+	GetCloudBackend(name string) (backend.CloudBackend, error)
+	SaveCloudBackend(backend backend.CloudBackend)
+	BackendsInUse() map[string][]*target.Target
+	TargetsByAccount() map[string][]*target.Target
+	BackendPathConflicts() map[string][]*target.Target
+	CompatibleProviders(backendType string) ([]provider.CloudProvider, string, error)
 
 	NewTarget(recipeName, recipeIaas string) (*target.Target, error)
+	NewTargetWithBackend(recipeName, recipeIaas, backendType string) (*target.Target, error)
+	NewTargetWithDefaults(recipeName, recipeIaas string, defaults map[string]string) (*target.Target, error)
 	TargetSet() *target.TargetSet
 	HasTarget(name string) bool
 	GetTarget(name string) (*target.Target, error)
 	SaveTarget(key string, target *target.Target)
+	UpdateTarget(name string, mutate func(*target.Target) error) error
+	ValidateTarget(t *target.Target) error
+	MigrateTargetBackend(name, newBackendType string) error
+	RefreshTargetRecipe(name string) (*target.Target, []string, error)
+	CloneTarget(srcName, newDeploymentName string) (*target.Target, error)
+	ClearTargetOutput(name string) error
+	ClearAllOutputs() int
+
+	SyncFrom(remote Context, strategy SyncStrategy) (SyncReport, error)
+
+	ExportTarget(name string, w io.Writer, stripCredentials bool) error
+	ImportTarget(r io.Reader) (*target.Target, error)
+	ImportTargetFromOutputs(recipeName, iaas, deploymentName string, outputs map[string]terraform.Output) (*target.Target, error)
 }
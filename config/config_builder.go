@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mevansam/goforms/forms"
+
+	"github.com/appbricks/cloud-builder/target"
+)
+
+// assembles a new target from the recipes, providers
+// and backends of a config context. the builder's
+// methods can be chained and the problems found are
+// returned together once the target is built.
+type TargetBuilder struct {
+	ctx Context
+
+	recipeName string
+	recipeIaas string
+
+	backendType string
+
+	providerOverrides map[string]string
+	tags              map[string]string
+
+	// problems found while the target is configured
+	errs error
+}
+
+// in: ctx - the context providing the target's recipe,
+//           provider and backend
+// out: a builder of targets for the given context
+func NewTargetBuilder(ctx Context) *TargetBuilder {
+
+	return &TargetBuilder{
+		ctx: ctx,
+
+		providerOverrides: make(map[string]string),
+		tags:              make(map[string]string),
+	}
+}
+
+// sets the recipe of the target
+//
+// in: name - the name of the recipe
+// in: iaas - the iaas of the recipe
+func (tb *TargetBuilder) WithRecipe(name, iaas string) *TargetBuilder {
+
+	tb.recipeName = name
+	tb.recipeIaas = iaas
+	return tb
+}
+
+// sets values of the target's provider fields which override
+// the values of the provider configured in the context
+//
+// in: overrides - map of provider field names to values
+func (tb *TargetBuilder) WithProviderOverrides(overrides map[string]string) *TargetBuilder {
+
+	for name, value := range overrides {
+		tb.providerOverrides[name] = value
+	}
+	return tb
+}
+
+// sets the type of the target's backend. if not set the
+// backend type required by the target's recipe is used.
+//
+// in: backendType - the type of the backend
+func (tb *TargetBuilder) WithBackend(backendType string) *TargetBuilder {
+
+	if len(backendType) == 0 {
+		tb.errs = multierror.Append(tb.errs,
+			fmt.Errorf("the backend type of a target cannot be empty"))
+	}
+	tb.backendType = backendType
+	return tb
+}
+
+// adds the given tags to the target
+//
+// in: tags - map of tag keys to values
+func (tb *TargetBuilder) WithTags(tags map[string]string) *TargetBuilder {
+
+	for key, value := range tags {
+		if len(key) == 0 {
+			tb.errs = multierror.Append(tb.errs,
+				fmt.Errorf("the key of a target tag cannot be empty"))
+			continue
+		}
+		tb.tags[key] = value
+	}
+	return tb
+}
+
+// creates the target. the target is not saved to the context.
+//
+// out: the new target
+// out: an error listing all the problems found with the
+//      configuration of the target
+func (tb *TargetBuilder) Build() (*target.Target, error) {
+
+	var (
+		err error
+
+		tgt   *target.Target
+		form  forms.InputForm
+		field *forms.InputField
+	)
+
+	errs := tb.errs
+	if len(tb.recipeName) == 0 || len(tb.recipeIaas) == 0 {
+		errs = multierror.Append(errs,
+			fmt.Errorf("the recipe of the target has not been set"))
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	if tgt, err = tb.ctx.NewTargetWithBackend(tb.recipeName, tb.recipeIaas, tb.backendType); err != nil {
+		return nil, multierror.Append(nil, err)
+	}
+
+	if len(tb.providerOverrides) > 0 {
+		if form, err = tgt.Provider.InputForm(); err != nil {
+			return nil, multierror.Append(nil, err)
+		}
+		names := make([]string, 0, len(tb.providerOverrides))
+		for name := range tb.providerOverrides {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if field, err = form.GetInputField(name); err != nil {
+				errs = multierror.Append(errs,
+					fmt.Errorf("provider '%s' does not have a field named '%s'", tgt.Provider.Name(), name))
+				continue
+			}
+			value := tb.providerOverrides[name]
+			if err = field.SetValue(&value); err != nil {
+				errs = multierror.Append(errs,
+					fmt.Errorf("invalid value for field '%s' of provider '%s': %w", name, tgt.Provider.Name(), err))
+			}
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	for key, value := range tb.tags {
+		tgt.Tags[key] = value
+	}
+	return tgt, nil
+}
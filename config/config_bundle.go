@@ -0,0 +1,258 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mevansam/goforms/config"
+	"github.com/mevansam/goforms/forms"
+	"github.com/mevansam/goutils/logger"
+
+	"github.com/appbricks/cloud-builder/internal/jsonenc"
+	"github.com/appbricks/cloud-builder/target"
+	"github.com/appbricks/cloud-builder/terraform"
+)
+
+// a self-contained serialization of a single target
+// which can be imported in to another configuration
+type targetBundle struct {
+	Version int `json:"version"`
+
+	// true if the sensitive fields of the
+	// target's provider have been removed
+	CredentialsStripped bool `json:"credentialsStripped,omitempty"`
+
+	// the target serialized as a target set
+	// containing only the exported target
+	Targets json.RawMessage `json:"targets"`
+}
+
+// writes the target with the given key to a bundle that can
+// be imported in to another configuration. the bundle contains
+// the target's recipe, provider and backend configurations.
+//
+// in: name - the key of the target to export
+// in: w - the stream to write the bundle to
+// in: stripCredentials - if true the sensitive fields of the
+//                        target's provider are not exported
+func (cc *configContext) ExportTarget(name string, w io.Writer, stripCredentials bool) error {
+
+	var (
+		err error
+
+		tgt  *target.Target
+		data []byte
+	)
+
+	if tgt, err = cc.GetTarget(name); err != nil {
+		return err
+	}
+	if stripCredentials {
+		if err = stripSensitiveFields(tgt.Provider); err != nil {
+			return err
+		}
+	}
+
+	ts := target.NewTargetSet(cc)
+	ts.SaveTarget(tgt.Key(), tgt)
+	if data, err = jsonenc.Marshal(ts); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(
+		&targetBundle{
+			Version:             ConfigVersion,
+			CredentialsStripped: stripCredentials,
+			Targets:             data,
+		},
+	)
+}
+
+// reads a target exported with ExportTarget and saves
+// it to this configuration. if the provider credentials
+// were not exported they are taken from this config's
+// provider for the target's iaas.
+//
+// in: r - the stream to read the bundle from
+// out: the imported target
+func (cc *configContext) ImportTarget(r io.Reader) (*target.Target, error) {
+
+	var (
+		err error
+
+		bundle targetBundle
+		tgt    *target.Target
+	)
+
+	if err = json.NewDecoder(r).Decode(&bundle); err != nil {
+		return nil, err
+	}
+	if bundle.Version > ConfigVersion {
+		return nil, fmt.Errorf(
+			"target bundle version %d is newer than the supported version %d",
+			bundle.Version, ConfigVersion)
+	}
+
+	ts := target.NewTargetSet(cc)
+	if err = json.Unmarshal(bundle.Targets, ts); err != nil {
+		return nil, err
+	}
+	targets := ts.GetTargets()
+	if len(targets) != 1 {
+		return nil, fmt.Errorf(
+			"target bundle should contain a single target but it contains %d",
+			len(targets))
+	}
+	tgt = targets[0]
+
+	if bundle.CredentialsStripped {
+		if err = cc.restoreSensitiveFields(tgt); err != nil {
+			return nil, err
+		}
+	}
+
+	key := cc.targets.KeyOf(tgt)
+	cc.SaveTarget(key, tgt)
+	logger.TraceMessage("Imported target: %s", key)
+	return tgt, nil
+}
+
+// registers a deployment of a recipe that was not launched by
+// this configuration as a target. the target is created from
+// the context's provider and backend for the recipe and its
+// output is set from the given terraform outputs so that the
+// deployment can be managed once the target is saved. outputs
+// that the recipe does not declare are imported with a warning.
+//
+// in: recipeName - the name of the deployment's recipe
+// in: iaas - the iaas of the deployment's recipe
+// in: deploymentName - the name of the deployment
+// in: outputs - the terraform outputs of the deployment
+// out: the imported target
+func (cc *configContext) ImportTargetFromOutputs(
+	recipeName, iaas, deploymentName string,
+	outputs map[string]terraform.Output,
+) (*target.Target, error) {
+
+	var (
+		err error
+
+		tgt  *target.Target
+		form forms.InputForm
+	)
+
+	if tgt, err = cc.NewTarget(recipeName, iaas); err != nil {
+		return nil, err
+	}
+	if form, err = tgt.Recipe.InputForm(); err != nil {
+		return nil, err
+	}
+	if err = form.SetFieldValue("name", deploymentName); err != nil {
+		return nil, err
+	}
+	key := cc.targets.KeyOf(tgt)
+	if cc.targets.GetTarget(key) != nil {
+		return nil, fmt.Errorf("a target with key '%s' already exists", key)
+	}
+
+	missing := []string{}
+	expected := make(map[string]bool)
+	for _, name := range tgt.Recipe.Outputs() {
+		expected[name] = true
+		if _, exists := outputs[name]; !exists {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf(
+			"the outputs of deployment '%s' do not include outputs '%s' of recipe '%s/%s'",
+			deploymentName, strings.Join(missing, "', '"), recipeName, iaas)
+	}
+
+	names := make([]string, 0, len(outputs))
+	output := make(map[string]terraform.Output, len(outputs))
+	for name, value := range outputs {
+		names = append(names, name)
+		output[name] = value
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !expected[name] {
+			logger.WarnMessage(
+				"Output '%s' of deployment '%s' is not an output of recipe '%s/%s'.",
+				name, deploymentName, recipeName, iaas)
+		}
+	}
+	tgt.Output = &output
+	if err = tgt.SetDeploymentStatus(target.StatusDeployed); err != nil {
+		return nil, err
+	}
+
+	cc.SaveTarget(key, tgt)
+	logger.TraceMessage("Imported target: %s", key)
+	return tgt, nil
+}
+
+// clears the values of all sensitive fields
+// of the given configurable
+func stripSensitiveFields(c config.Configurable) error {
+
+	var (
+		err  error
+		form forms.InputForm
+	)
+
+	if form, err = c.InputForm(); err != nil {
+		return err
+	}
+	for _, field := range form.InputFields() {
+		if field.Sensitive() {
+			empty := ""
+			if err = field.SetValue(&empty); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sets the empty sensitive provider fields of the given
+// target from this config's provider for the target's iaas
+func (cc *configContext) restoreSensitiveFields(tgt *target.Target) error {
+
+	var (
+		err error
+
+		values map[string]*string
+		form   forms.InputForm
+	)
+
+	// no credentials to restore from
+	cp, exists := cc.cloudProviders()[tgt.RecipeIaas]
+	if !exists {
+		return nil
+	}
+	// read the config provider's values before retrieving
+	// the target provider's form as configurables of the
+	// same type may share the same input form
+	if values, err = fieldValues(cp); err != nil {
+		return err
+	}
+	if form, err = tgt.Provider.InputForm(); err != nil {
+		return err
+	}
+	for _, field := range form.InputFields() {
+		value := field.Value()
+		if field.Sensitive() && (value == nil || len(*value) == 0) {
+			if v := values[field.Name()]; v != nil {
+				if err = field.SetValue(v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"sort"
+
+	"github.com/mevansam/gocloud/backend"
+	"github.com/mevansam/gocloud/provider"
+	"github.com/mevansam/goforms/config"
+	"github.com/mevansam/goutils/logger"
+
+	"github.com/appbricks/cloud-builder/target"
+)
+
+// removes the providers and backends that have not been
+// configured and are not used by any target so they are
+// not saved with the config. all sections are flagged as
+// modified so the config is rewritten when it is next
+// saved. removed providers and backends are recreated
+// from their templates when the config is next loaded.
+//
+// out: the number of providers and backends removed
+func (cc *configContext) Compact() (int, error) {
+
+	var (
+		err    error
+		unused bool
+	)
+
+	targets := cc.targets.GetTargets()
+	removed := 0
+
+	providers := cc.cloudProviders()
+	names := make([]string, 0, len(providers))
+	for iaas := range providers {
+		names = append(names, iaas)
+	}
+	sort.Strings(names)
+
+	for _, iaas := range names {
+		if unused, err = isUnusedTemplate(
+			providers[iaas],
+			func() (config.Configurable, error) {
+				return provider.NewCloudProvider(iaas)
+			},
+			targets,
+			func(t *target.Target) bool {
+				return t.RecipeIaas == iaas
+			},
+		); err != nil {
+			return removed, err
+		}
+		if unused {
+			delete(providers, iaas)
+			delete(cc.providerExpiry, iaas)
+			logger.TraceMessage("Compacted unused provider: %s", iaas)
+			removed++
+		}
+	}
+
+	backends := cc.cloudBackends()
+	names = make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if unused, err = isUnusedTemplate(
+			backends[name],
+			func() (config.Configurable, error) {
+				return backend.NewCloudBackend(name)
+			},
+			targets,
+			func(t *target.Target) bool {
+				return t.Backend != nil && t.Backend.Name() == name
+			},
+		); err != nil {
+			return removed, err
+		}
+		if unused {
+			delete(backends, name)
+			logger.TraceMessage("Compacted unused backend: %s", name)
+			removed++
+		}
+	}
+
+	cc.MarkAllDirty()
+	return removed, nil
+}
+
+// returns whether the given provider or backend has the
+// same field values as a new instance created from its
+// template and is not used by any of the given targets
+func isUnusedTemplate(
+	c config.Configurable,
+	newTemplate func() (config.Configurable, error),
+	targets []*target.Target,
+	usedBy func(t *target.Target) bool,
+) (bool, error) {
+
+	var (
+		err error
+
+		template config.Configurable
+		changed  []string
+	)
+
+	for _, t := range targets {
+		if usedBy(t) {
+			return false, nil
+		}
+	}
+	if template, err = newTemplate(); err != nil {
+		return false, err
+	}
+	if changed, err = diffFields(c, template, ""); err != nil {
+		return false, err
+	}
+	return len(changed) == 0, nil
+}
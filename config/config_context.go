@@ -1,24 +1,138 @@
 package config
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/mevansam/gocloud/backend"
 	"github.com/mevansam/gocloud/provider"
 	"github.com/mevansam/goforms/config"
+	"github.com/mevansam/goforms/forms"
+	"github.com/mevansam/goutils/logger"
+	"github.com/mevansam/goutils/utils"
 	"github.com/appbricks/cloud-builder/cookbook"
+	"github.com/appbricks/cloud-builder/internal/jsonenc"
 	"github.com/appbricks/cloud-builder/target"
 )
 
+// errors returned when a config element does not exist. these
+// are wrapped so callers can use errors.Is() to check for them.
+var (
+	ErrProviderNotFound = errors.New("provider does not exist")
+	ErrBackendNotFound  = errors.New("backend does not exist")
+	ErrRecipeNotFound   = errors.New("recipe does not exist")
+	ErrTargetNotFound   = errors.New("target does not exist")
+)
+
+// error returned when a serialized config context could not
+// be loaded. it identifies the part of the config that was
+// being decoded when the error occurred.
+type LoadError struct {
+	// the section that was being decoded i.e. 'providers',
+	// 'backends', 'recipes' or 'targets'. this is empty if
+	// the error occurred outside of a section.
+	Section string
+	// the key of the element being decoded. this is the
+	// index of the target for targets and empty if the
+	// error did not occur while decoding an element.
+	Key string
+	// the offset in the config input at which the error
+	// occurred. configs that are migrated when loaded
+	// report the offset in the migrated config.
+	Offset int64
+
+	Err error
+}
+
+func (e *LoadError) Error() string {
+
+	var (
+		msg strings.Builder
+	)
+
+	msg.WriteString("error loading config")
+	if len(e.Section) > 0 {
+		msg.WriteString(" section '")
+		msg.WriteString(e.Section)
+		msg.WriteByte('\'')
+	}
+	if len(e.Key) > 0 {
+		msg.WriteString(" key '")
+		msg.WriteString(e.Key)
+		msg.WriteByte('\'')
+	}
+	msg.WriteString(" at offset ")
+	msg.WriteString(strconv.FormatInt(e.Offset, 10))
+	msg.WriteString(": ")
+	msg.WriteString(e.Err.Error())
+	return msg.String()
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
 // global configuration context
 type configContext struct {
 	cookbook *cookbook.Cookbook
 	targets  *target.TargetSet
 
+	// the provider and backend templates are only
+	// created when they are first accessed. the
+	// mutex guards their creation as the templates
+	// are recreated once the context is reset.
+	templatesMx          sync.Mutex
+	templatesInitialized bool
+	templatesErr         error
+
 	providers map[string]provider.CloudProvider
 	backends  map[string]backend.CloudBackend
+
+	// copies of the cookbook recipes
+	// returned by GetCookbookRecipe
+	recipeCache *recipeCache
+
+	// times at which temporary provider
+	// credentials expire keyed by provider
+	providerExpiry map[string]time.Time
+
+	// sections modified since the config was
+	// last loaded or saved. the target set's
+	// observers mark the targets as modified
+	// from the goroutines that change the set
+	// so the sections are guarded by a mutex.
+	dirtyMx  sync.Mutex
+	dirty    map[string]bool
+	dirtyGen uint64
+
+	// environment variables to read provider
+	// credentials from keyed by provider
+	credentialEnv         map[string]map[string]string
+	credentialEnvOverride bool
+
+	// provider fields set from the environment
+	// keyed by provider and field name
+	injectedCredentials map[string]map[string]injectedCredential
+
+	// tags added to new targets keyed by provider
+	providerDefaultTags map[string]map[string]string
+
+	// callback that resolves referenced provider and
+	// backend values and the values it resolved keyed
+	// by section and provider or backend name
+	valueResolver  func(field, value string) (string, error)
+	resolvedValues map[string]map[string]resolvedValue
 }
 
 // in: cookbook - the cookbook in context
@@ -28,22 +142,146 @@ func NewConfigContext(cookbook *cookbook.Cookbook) (Context, error) {
 		err error
 	)
 
+	// the cookbook's recipes as well as the provider
+	// and backend templates are only initialized when
+	// they are first accessed which avoids creating
+	// them for commands that only need some of them
 	ctx := &configContext{
 		cookbook: cookbook,
-	}
+		dirty:    make(map[string]bool),
 
-	if ctx.providers, err = provider.NewCloudProviderTemplates(); err != nil {
-		return nil, err
+		credentialEnv:       make(map[string]map[string]string),
+		providerDefaultTags: make(map[string]map[string]string),
+
+		recipeCache: newRecipeCache(defaultRecipeCacheSize),
 	}
-	if ctx.backends, err = backend.NewCloudBackendTemplates(); err != nil {
+
+	if err = ctx.Reset(); err != nil {
 		return nil, err
 	}
-	ctx.targets = target.NewTargetSet(ctx)
 	return ctx, nil
 }
 
+// clears all loaded state. the providers and backends
+// are reset to their templates and all targets are
+// removed. the cookbook of the context is retained.
+func (cc *configContext) Reset() error {
+
+	// templates are recreated when
+	// they are next accessed
+	cc.templatesMx.Lock()
+	cc.templatesInitialized = false
+	cc.templatesErr = nil
+	cc.providers = nil
+	cc.backends = nil
+	cc.templatesMx.Unlock()
+
+	cc.providerExpiry = make(map[string]time.Time)
+	cc.resolvedValues = make(map[string]map[string]resolvedValue)
+	cc.injectedCredentials = make(map[string]map[string]injectedCredential)
+	cc.targets = target.NewTargetSet(cc)
+	cc.clearDirty()
+
+	// changes made directly to the target set such
+	// as merges mark the targets section as modified
+	cc.targets.OnChange(func(event target.ChangeEvent) {
+		if event.Op != target.ChangeLoaded {
+			cc.markDirty("targets")
+		}
+	})
+	return nil
+}
+
+// creates the provider and backend templates the first
+// time it is called after the context has been reset. if
+// the templates could not be created the context has no
+// providers or backends and the error is returned by the
+// getters of providers and backends that return errors.
+//
+// out: the error creating the templates
+func (cc *configContext) initTemplates() error {
+
+	var (
+		err error
+
+		providers map[string]provider.CloudProvider
+		backends  map[string]backend.CloudBackend
+	)
+
+	cc.templatesMx.Lock()
+	defer cc.templatesMx.Unlock()
+
+	if cc.templatesInitialized {
+		return cc.templatesErr
+	}
+	if providers, err = provider.NewCloudProviderTemplates(); err == nil {
+		backends, err = backend.NewCloudBackendTemplates()
+	}
+	if err != nil {
+		logger.ErrorMessage("Unable to create the cloud provider and backend templates: %s", err.Error())
+		providers = make(map[string]provider.CloudProvider)
+		backends = make(map[string]backend.CloudBackend)
+	}
+	cc.providers = providers
+	cc.backends = backends
+	cc.templatesErr = err
+	cc.templatesInitialized = true
+	return err
+}
+
+// out: the providers of the context keyed by iaas
+func (cc *configContext) cloudProviders() map[string]provider.CloudProvider {
+	cc.initTemplates()
+	return cc.providers
+}
+
+// out: the backends of the context keyed by type
+func (cc *configContext) cloudBackends() map[string]backend.CloudBackend {
+	cc.initTemplates()
+	return cc.backends
+}
+
+// number of targets decoded between target
+// section progress callbacks when loading
+const targetLoadProgressInterval = 10
+
 // loads the cloud configuration from the given stream
 func (cc *configContext) Load(input io.Reader) error {
+	return cc.LoadContext(context.Background(), input)
+}
+
+// loads the cloud configuration from the given stream. the
+// load is cancelled if the given context is done before all
+// sections have been decoded. the elements decoded up to
+// the point of cancellation remain loaded.
+//
+// in: ctx - the context used to cancel the load
+// in: input - the stream to read the configuration from
+// out: ctx.Err() if the load was cancelled
+func (cc *configContext) LoadContext(ctx context.Context, input io.Reader) error {
+	return cc.load(ctx, input, func(section string, count int) {})
+}
+
+// loads the cloud configuration from the given stream
+// reporting progress as each section is decoded
+//
+// in: input - the stream to read the configuration from
+// in: cb - callback invoked with the section name and the
+//          number of elements decoded. it is invoked after
+//          every few targets are decoded and once with the
+//          final count when each section has been decoded.
+func (cc *configContext) LoadWithProgress(
+	input io.Reader,
+	cb func(section string, count int),
+) error {
+	return cc.load(context.Background(), input, cb)
+}
+
+func (cc *configContext) load(
+	ctx context.Context,
+	input io.Reader,
+	cb func(section string, count int),
+) error {
 
 	type elemType int
 
@@ -57,112 +295,397 @@ func (cc *configContext) Load(input io.Reader) error {
 	elemStack := []elemType{root}
 
 	var (
-		err    error
-		exists bool
-		top    int
-		token  json.Token
+		err   error
+		top   int
+		token json.Token
 
-		cloudProvider provider.CloudProvider
-		cloudBackend  backend.CloudBackend
+		numProviders,
+		numBackends,
+		numTargets int
 	)
 
-	decoder := json.NewDecoder(input)
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if input, err = migrateConfig(input); err != nil {
+		return err
+	}
+
+	counter := &countingReader{r: input}
+	decoder := json.NewDecoder(counter)
+
+	// wraps errors with the location
+	// in the config being decoded
+	loadError := func(section, key string, err error) error {
+		if err == ctx.Err() {
+			return err
+		}
+		offset := counter.n
+		if buffered, ok := decoder.Buffered().(interface{ Len() int }); ok {
+			offset -= int64(buffered.Len())
+		}
+		return &LoadError{
+			Section: section,
+			Key:     key,
+			Offset:  offset,
+			Err:     err,
+		}
+	}
+	sectionNames := map[elemType]string{
+		providers: "providers",
+		backends:  "backends",
+	}
+
 	for {
 		token, err = decoder.Token()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return err
+			return loadError(sectionNames[elemStack[len(elemStack)-1]], "", err)
 		}
+		top = len(elemStack) - 1
 
-		if decoder.More() {
-			top = len(elemStack) - 1
-
-			switch key := token.(type) {
-			case json.Delim:
-				if key == endObject {
-					elemStack = elemStack[0:top]
+		switch key := token.(type) {
+		case json.Delim:
+			if key == endObject && top > 0 {
+				switch elemStack[top] {
+				case providers:
+					cb("providers", numProviders)
+				case backends:
+					cb("backends", numBackends)
 				}
+				elemStack = elemStack[0:top]
+			}
 
-			case string:
+		case string:
+			if !decoder.More() {
+				break
+			}
+			if err = ctx.Err(); err != nil {
+				return err
+			}
 
-				switch elemStack[top] {
-				case root:
-					switch key {
-					case "cloud":
-						elemStack = append(elemStack, cloud)
-					default:
-						return fmt.Errorf(
-							"invalid root config key '%s'",
-							key)
+			switch elemStack[top] {
+			case root:
+				switch key {
+				case "version":
+					// version has been checked
+					// when migrating the config
+					skip := json.RawMessage{}
+					if err = decoder.Decode(&skip); err != nil {
+						return loadError("", key, err)
 					}
+				case "cloud":
+					elemStack = append(elemStack, cloud)
+				case sectionIndexKey:
+					// the index is only used
+					// when loading sections
+					skip := json.RawMessage{}
+					if err = decoder.Decode(&skip); err != nil {
+						return loadError("", key, err)
+					}
+				default:
+					return loadError("", key, fmt.Errorf(
+						"invalid root config key '%s'",
+						key))
+				}
 
-				case cloud:
-					switch key {
-					case "providers":
-						elemStack = append(elemStack, providers)
-
-					case "backends":
-						elemStack = append(elemStack, backends)
-
-					case "recipes":
-						if err = decoder.Decode(cc.cookbook); err != nil {
-							return err
-						}
+			case cloud:
+				switch key {
+				case "providers":
+					elemStack = append(elemStack, providers)
 
-					case "targets":
-						if err = decoder.Decode(cc.targets); err != nil {
-							return err
-						}
+				case "backends":
+					elemStack = append(elemStack, backends)
 
-					default:
-						return fmt.Errorf(
-							"invalid 'cloud' config key '%s': elemStack = %# v",
-							key, elemStack)
+				case "providerExpiry":
+					if err = cc.decodeSection(decoder, key); err != nil {
+						return loadError(key, "", err)
 					}
 
-				case providers:
-					if cloudProvider, exists = cc.providers[key]; !exists {
-						return fmt.Errorf(
-							"invalid cloud provider '%s'",
-							key)
+				case "recipes":
+					cc.recipeCache.clear()
+					if err = decoder.Decode(cc.cookbook); err != nil {
+						return loadError(key, "", err)
 					}
-					if err = decoder.Decode(cloudProvider); err != nil {
-						return err
+					cb("recipes", len(cc.cookbook.RecipeList()))
+
+				case "targets":
+					if err = cc.targets.Decode(decoder,
+						func(count int) error {
+							numTargets = count
+							if count%targetLoadProgressInterval == 0 {
+								cb("targets", count)
+							}
+							return ctx.Err()
+						},
+					); err != nil {
+						return loadError(key, strconv.Itoa(numTargets), err)
 					}
+					cb("targets", numTargets)
 
-				case backends:
-					if cloudBackend, exists = cc.backends[key]; !exists {
-						return fmt.Errorf(
-							"invalid cloud backend '%s'",
-							key)
-					}
-					if err = decoder.Decode(cloudBackend); err != nil {
-						return err
-					}
+				default:
+					return loadError("", key, fmt.Errorf(
+						"invalid 'cloud' config key '%s': elemStack = %# v",
+						key, elemStack))
+				}
+
+			case providers:
+				if err = cc.decodeCloudProvider(key, decoder); err != nil {
+					return loadError("providers", key, err)
+				}
+				numProviders++
+
+			case backends:
+				if err = cc.decodeCloudBackend(key, decoder); err != nil {
+					return loadError("backends", key, err)
 				}
+				numBackends++
+			}
+		}
+	}
+
+	cc.clearDirty()
+	return nil
+}
+
+// loads only the given section of the cloud configuration
+// from the given stream. all other sections are skipped.
+//
+// in: input - the stream to read the configuration from
+// in: section - one of 'providers', 'backends', 'recipes'
+//               or 'targets'
+func (cc *configContext) LoadSection(input io.Reader, section string) error {
+
+	var (
+		err   error
+		token json.Token
+		key   string
+		ok    bool
+	)
+
+	switch section {
+	case "providers", "backends", "recipes", "targets":
+	default:
+		return fmt.Errorf(
+			"invalid config section '%s'",
+			section)
+	}
+
+	if input, err = migrateConfig(input); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(input)
+
+	// read root object open brace and skip
+	// to the 'cloud' key
+	if _, err = utils.ReadJSONDelimiter(decoder, utils.JsonObjectStartDelim); err != nil {
+		return err
+	}
+	for {
+		if !decoder.More() {
+			// no 'cloud' config
+			return nil
+		}
+		if token, err = decoder.Token(); err != nil {
+			return err
+		}
+		if key, ok = token.(string); ok && key == "cloud" {
+			break
+		}
+		if !ok || key != "version" {
+			return fmt.Errorf(
+				"invalid root config key '%v'",
+				token)
+		}
+		skip := json.RawMessage{}
+		if err = decoder.Decode(&skip); err != nil {
+			return err
+		}
+	}
+	if _, err = utils.ReadJSONDelimiter(decoder, utils.JsonObjectStartDelim); err != nil {
+		return err
+	}
+
+	for decoder.More() {
+		if token, err = decoder.Token(); err != nil {
+			return err
+		}
+		if key, ok = token.(string); !ok {
+			return fmt.Errorf(
+				"unexpected token '%v' in 'cloud' config",
+				token)
+		}
+		if section == "providers" && key == "providerExpiry" {
+			// provider expiry is loaded
+			// with the providers section
+			if err = cc.decodeSection(decoder, key); err != nil {
+				return err
+			}
+			continue
+		}
+		if key != section {
+			// skip sections that were not requested
+			skip := json.RawMessage{}
+			if err = decoder.Decode(&skip); err != nil {
+				return err
 			}
+			continue
+		}
+
+		if err = cc.decodeSection(decoder, section); err == nil {
+			cc.clearDirty(section)
 		}
+		// the requested section has been
+		// loaded so ignore the rest
+		return err
 	}
 
 	return nil
 }
 
+// decodes the value of the given section
+// of the cloud configuration
+func (cc *configContext) decodeSection(decoder *json.Decoder, section string) error {
+
+	var (
+		err   error
+		token json.Token
+		key   string
+		ok    bool
+	)
+
+	switch section {
+	case "providers", "backends":
+		if _, err = utils.ReadJSONDelimiter(decoder, utils.JsonObjectStartDelim); err != nil {
+			return err
+		}
+		for decoder.More() {
+			if token, err = decoder.Token(); err != nil {
+				return err
+			}
+			if key, ok = token.(string); !ok {
+				return fmt.Errorf(
+					"unexpected token '%v' in '%s' config",
+					token, section)
+			}
+			if section == "providers" {
+				err = cc.decodeCloudProvider(key, decoder)
+			} else {
+				err = cc.decodeCloudBackend(key, decoder)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		_, err = utils.ReadJSONDelimiter(decoder, utils.JsonObjectEndDelim)
+
+	case "providerExpiry":
+		err = decoder.Decode(&cc.providerExpiry)
+
+	case "recipes":
+		cc.recipeCache.clear()
+		err = decoder.Decode(cc.cookbook)
+
+	case "targets":
+		err = cc.targets.Decode(decoder, nil)
+	}
+	return err
+}
+
+// decodes the cloud provider with the given key
+func (cc *configContext) decodeCloudProvider(key string, decoder *json.Decoder) error {
+
+	var (
+		err    error
+		exists bool
+
+		cloudProvider provider.CloudProvider
+	)
+
+	if err = cc.initTemplates(); err != nil {
+		return err
+	}
+	if cloudProvider, exists = cc.cloudProviders()[key]; !exists {
+		return fmt.Errorf(
+			"invalid cloud provider '%s'",
+			key)
+	}
+	if err = decoder.Decode(cloudProvider); err != nil {
+		return err
+	}
+	if err = cc.resolveValues("providers", key, cloudProvider); err != nil {
+		return err
+	}
+	return cc.injectCredentials(cloudProvider)
+}
+
+// decodes the cloud backend with the given key
+func (cc *configContext) decodeCloudBackend(key string, decoder *json.Decoder) error {
+
+	var (
+		err    error
+		exists bool
+
+		cloudBackend backend.CloudBackend
+	)
+
+	if err = cc.initTemplates(); err != nil {
+		return err
+	}
+	if cloudBackend, exists = cc.cloudBackends()[key]; !exists {
+		return fmt.Errorf(
+			"invalid cloud backend '%s'",
+			key)
+	}
+	if err = decoder.Decode(cloudBackend); err != nil {
+		return err
+	}
+	return cc.resolveValues("backends", key, cloudBackend)
+}
+
 // saves the cloud configuration to the given stream
 func (cc *configContext) Save(output io.Writer) error {
 
+	if err := cc.write(output); err != nil {
+		return err
+	}
+	cc.clearDirty()
+	return nil
+}
+
+// writes the cloud configuration to the given stream
+// without changing the modified state of the context
+func (cc *configContext) write(output io.Writer) error {
+
 	var (
-		err error
-		i   int
+		err  error
+		data []byte
+
+		saved config.Configurable
 	)
-	encoder := json.NewEncoder(output)
+
+	// the offsets of each section are
+	// recorded in the section index
+	counter := &countingWriter{w: output}
+	output = counter
+	index := sectionIndex{
+		Version:  ConfigVersion,
+		Sections: make(map[string][2]int64),
+	}
+	encoder := jsonenc.NewEncoder(output)
 
 	// begin root
 	if _, err = output.Write([]byte{'{'}); err != nil {
 		return err
 	}
 
+	// config version
+	if _, err = fmt.Fprintf(output, "\"version\":%d,", ConfigVersion); err != nil {
+		return err
+	}
+
 	// begin cloud config object
 	if _, err = fmt.Fprint(output, "\"cloud\":{"); err != nil {
 		return err
@@ -172,131 +695,676 @@ func (cc *configContext) Save(output io.Writer) error {
 	if _, err = fmt.Fprint(output, "\"providers\":{"); err != nil {
 		return err
 	}
-	i = 0
-	for _, p := range cc.providers {
+	start := counter.n - 1
+	// providers and backends are written in
+	// key order so the output is deterministic
+	providers := cc.cloudProviders()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		p := providers[name]
 		if i > 0 {
 			if _, err = output.Write([]byte{','}); err != nil {
 				return err
 			}
 		}
-		if _, err = fmt.Fprintf(output, "\"%s\":", p.Name()); err != nil {
+		if err = encoder.WriteKey(p.Name()); err != nil {
+			return err
+		}
+		// referenced values are saved
+		// in place of resolved values
+		if saved, err = cc.unresolvedValues("providers", name, p); err != nil {
 			return err
 		}
-		if err := encoder.Encode(p); err != nil {
+		if err := encoder.Encode(saved); err != nil {
 			return err
 		}
-		i++
 	}
 	// end providers
 	if _, err = output.Write([]byte{'}'}); err != nil {
 		return err
 	}
+	index.Sections["providers"] = [2]int64{start, counter.n}
 
 	// begin backends
 	if _, err = fmt.Fprint(output, ",\"backends\":{"); err != nil {
 		return err
 	}
-	i = 0
-	for _, b := range cc.backends {
+	start = counter.n - 1
+	backends := cc.cloudBackends()
+	names = make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		b := backends[name]
 		if i > 0 {
 			if _, err = output.Write([]byte{','}); err != nil {
 				return err
 			}
 		}
-		if _, err = fmt.Fprintf(output, "\"%s\":", b.Name()); err != nil {
+		if err = encoder.WriteKey(b.Name()); err != nil {
+			return err
+		}
+		if saved, err = cc.unresolvedValues("backends", name, b); err != nil {
 			return err
 		}
-		if err := encoder.Encode(b); err != nil {
+		if err := encoder.Encode(saved); err != nil {
 			return err
 		}
-		i++
 	}
 	// end backends
 	if _, err = output.Write([]byte{'}'}); err != nil {
 		return err
 	}
+	index.Sections["backends"] = [2]int64{start, counter.n}
+
+	// provider expiry is only written if
+	// any provider credentials expire
+	if len(cc.providerExpiry) > 0 {
+		if _, err = fmt.Fprint(output, ",\"providerExpiry\":"); err != nil {
+			return err
+		}
+		start = counter.n
+		if err = encoder.Encode(cc.providerExpiry); err != nil {
+			return err
+		}
+		index.Sections["providerExpiry"] = [2]int64{start, counter.n}
+	}
 
 	// encode coookbook
 	if _, err = fmt.Fprint(output, ",\"recipes\":"); err != nil {
 		return err
 	}
+	start = counter.n
 	if err = encoder.Encode(cc.cookbook); err != nil {
 		return err
 	}
+	index.Sections["recipes"] = [2]int64{start, counter.n}
 
 	// begin targets
 	if _, err = fmt.Fprint(output, ",\"targets\":"); err != nil {
 		return err
 	}
-	if err = encoder.Encode(cc.targets); err != nil {
+	start = counter.n
+	if _, err = cc.targets.WriteTo(output); err != nil {
+		return err
+	}
+	index.Sections["targets"] = [2]int64{start, counter.n}
+
+	// end cloud
+	if _, err = output.Write([]byte{'}'}); err != nil {
 		return err
 	}
 
-	if _, err = output.Write([]byte{
-		// end cloud
-		'}',
-		// end root
-		'}',
-	}); err != nil {
+	// the section index is written last so
+	// it can be read from the end of the config
+	if data, err = jsonenc.Marshal(&index); err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(output, ",\"%s\":%s", sectionIndexKey, data); err != nil {
 		return err
 	}
 
+	// end root
+	if _, err = output.Write([]byte{'}'}); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (cc *configContext) Cookbook() *cookbook.Cookbook {
-	return cc.cookbook
+// returns a SHA-256 digest of the serialized configuration.
+// the configuration is serialized deterministically so
+// contexts with the same content have the same fingerprint.
+//
+// out: the hex encoded digest
+func (cc *configContext) Fingerprint() (string, error) {
+
+	hash := sha256.New()
+	if err := cc.write(hash); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-func (cc *configContext) GetCookbookRecipe(recipe, iaas string) (cookbook.Recipe, error) {
+// serializes each element of each section of the context
+// without writing it and returns the errors of all elements
+// that could not be serialized
+//
+// out: an error listing the elements of each section
+//      that failed to serialize or nil if all succeed
+func (cc *configContext) CheckSections() error {
 
 	var (
-		err error
-
-		r    cookbook.Recipe
-		copy config.Configurable
+		err, errs error
 	)
 
-	if r = cc.Cookbook().GetRecipe(recipe, iaas); r == nil {
-		return nil, fmt.Errorf(
-			"recipe '%s' for iaas '%s' does not exist",
-			recipe, iaas)
+	for _, p := range cc.CloudProviderTemplates() {
+		if _, err = jsonenc.Marshal(p); err != nil {
+			errs = multierror.Append(errs,
+				fmt.Errorf("section 'providers': provider '%s': %w", p.Name(), err))
+		}
 	}
-	if copy, err = r.Copy(); err != nil {
-		return nil, err
+	if _, err = jsonenc.Marshal(cc.providerExpiry); err != nil {
+		errs = multierror.Append(errs,
+			fmt.Errorf("section 'providerExpiry': %w", err))
+	}
+	for _, b := range cc.CloudBackendTemplates() {
+		if _, err = jsonenc.Marshal(b); err != nil {
+			errs = multierror.Append(errs,
+				fmt.Errorf("section 'backends': backend '%s': %w", b.Name(), err))
+		}
 	}
-	return copy.(cookbook.Recipe), nil
+	if _, err = jsonenc.Marshal(cc.cookbook); err != nil {
+		errs = multierror.Append(errs,
+			fmt.Errorf("section 'recipes': %w", err))
+	}
+	for _, t := range cc.targets.GetTargetsSorted(target.LessByName) {
+		if _, err = jsonenc.Marshal(t); err != nil {
+			errs = multierror.Append(errs,
+				fmt.Errorf("section 'targets': target '%s': %w", cc.targets.KeyOf(t), err))
+		}
+	}
+	return errs
 }
 
-func (cc *configContext) SaveCookbookRecipe(recipe cookbook.Recipe) {
-	cc.cookbook.SetRecipe(recipe)
+// saves the cloud configuration to the given stream only
+// if a section has been modified since the config was last
+// loaded or saved. changes made directly to the elements of
+// the target set are not tracked.
+//
+// in: output - the stream to write the configuration to
+// out: true if the configuration was written
+func (cc *configContext) SaveIfDirty(output io.Writer) (bool, error) {
+
+	if !cc.isDirty() {
+		return false, nil
+	}
+	if err := cc.Save(output); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func (cc *configContext) CloudProviderTemplates() []provider.CloudProvider {
+// marks all sections as modified so the next
+// call to SaveIfDirty saves the configuration
+func (cc *configContext) MarkAllDirty() {
+	cc.markDirty("providers", "backends", "recipes", "targets")
+}
 
-	providerList := []provider.CloudProvider{}
-	for _, cp := range cc.providers {
-		providerList = append(providerList, cp)
+// marks the given sections as modified
+func (cc *configContext) markDirty(sections ...string) {
+	cc.dirtyMx.Lock()
+	defer cc.dirtyMx.Unlock()
+
+	for _, section := range sections {
+		cc.dirty[section] = true
 	}
+	cc.dirtyGen++
+}
 
-	provider.SortCloudProviders(providerList)
-	return providerList
+// clears the modified state of the given sections
+// or of all sections if no sections are given
+func (cc *configContext) clearDirty(sections ...string) {
+	cc.dirtyMx.Lock()
+	defer cc.dirtyMx.Unlock()
+
+	if len(sections) == 0 {
+		cc.dirty = make(map[string]bool)
+		return
+	}
+	for _, section := range sections {
+		delete(cc.dirty, section)
+	}
 }
 
-func (cc *configContext) GetCloudProvider(iaas string) (provider.CloudProvider, error) {
+// returns the number of times sections have been marked
+// as modified and whether any section is modified. the
+// count is passed to markSaved once the serialized context
+// has been written.
+func (cc *configContext) dirtyState() (uint64, bool) {
+	cc.dirtyMx.Lock()
+	defer cc.dirtyMx.Unlock()
 
-	var (
-		err error
-		ok  bool
+	return cc.dirtyGen, len(cc.dirty) > 0
+}
 
-		p    provider.CloudProvider
-		copy config.Configurable
-	)
+// clears the modified state of all sections once the
+// context has been written unless sections have been
+// modified since the context was serialized
+//
+// in: generation - the count returned by dirtyState
+//                  before the context was serialized
+func (cc *configContext) markSaved(generation uint64) {
+	cc.dirtyMx.Lock()
+	defer cc.dirtyMx.Unlock()
+
+	if cc.dirtyGen == generation {
+		cc.dirty = make(map[string]bool)
+	}
+}
 
-	if p, ok = cc.providers[iaas]; !ok {
+// returns whether any section has been modified
+func (cc *configContext) isDirty() bool {
+	cc.dirtyMx.Lock()
+	defer cc.dirtyMx.Unlock()
+
+	return len(cc.dirty) > 0
+}
+
+// validates the loaded configuration and returns all the
+// problems found. each target's recipe must exist in the
+// cookbook and the providers used by targets must be valid.
+// providers that are not used by any target are not checked
+// as they may not have been configured.
+func (cc *configContext) Validate() []error {
+
+	var (
+		err error
+	)
+
+	errs := []error{}
+	usedProviders := make(map[string]bool)
+
+	targets := cc.targets.GetTargets()
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Key() < targets[j].Key()
+	})
+	for _, t := range targets {
+		if _, err = cc.GetCookbookRecipe(t.RecipeName, t.RecipeIaas); err != nil {
+			errs = append(errs, fmt.Errorf("target '%s' references a %w", t.Key(), err))
+		}
+		if !t.Provider.IsValid() {
+			errs = append(errs,
+				fmt.Errorf(
+					"target '%s' provider configuration for iaas '%s' is not valid",
+					t.Key(), t.RecipeIaas))
+		}
+		usedProviders[t.RecipeIaas] = true
+	}
+
+	for _, o := range cc.targets.OrphanedTargets() {
+		errs = append(errs,
+			fmt.Errorf(
+				"a target references recipe '%s' for iaas '%s' which could not be loaded: %s",
+				o.RecipeName, o.RecipeIaas, o.Err.Error()))
+	}
+
+	for _, p := range cc.CloudProviderTemplates() {
+		if usedProviders[p.Name()] && !p.IsValid() {
+			errs = append(errs,
+				fmt.Errorf(
+					"provider configuration for iaas '%s' is not valid",
+					p.Name()))
+		}
+	}
+	return errs
+}
+
+// returns the targets that were last applied with an
+// older version of the cookbook than the one loaded.
+// targets that have not been applied are not stale.
+//
+// out: the stale targets sorted by key
+func (cc *configContext) StaleTargets() []*target.Target {
+
+	timestamp := cc.CookbookTimestamp()
+	stale := []*target.Target{}
+
+	for _, t := range cc.targets.GetTargets() {
+		if len(t.CookbookTimestamp) > 0 &&
+			!appliedWithCookbook(t, timestamp) {
+			stale = append(stale, t)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].Key() < stale[j].Key()
+	})
+	return stale
+}
+
+// returns the targets that need to be applied as either
+// they have not been applied or they were applied with an
+// older version of the cookbook
+//
+// out: targets sorted by deployment name
+func (cc *configContext) TargetsNeedingReapply() []*target.Target {
+
+	timestamp := cc.CookbookTimestamp()
+	reapply := []*target.Target{}
+
+	for _, t := range cc.targets.GetTargets() {
+		if t.Output == nil ||
+			(len(t.CookbookTimestamp) > 0 && !appliedWithCookbook(t, timestamp)) {
+			reapply = append(reapply, t)
+		}
+	}
+	sort.Slice(reapply, func(i, j int) bool {
+		ni, nj := reapply[i].DeploymentName(), reapply[j].DeploymentName()
+		if ni == nj {
+			return reapply[i].Key() < reapply[j].Key()
+		}
+		return ni < nj
+	})
+	return reapply
+}
+
+// out: the timestamp of the loaded cookbook
+func (cc *configContext) CookbookTimestamp() string {
+	return cc.cookbook.Timestamp()
+}
+
+// returns whether the target with the given name was last
+// applied with the loaded cookbook or a newer one. targets
+// that have not been applied are not current.
+//
+// in: name - the name of the target
+// out: true if the target is current
+func (cc *configContext) IsTargetCurrent(name string) (bool, error) {
+
+	var (
+		tgt *target.Target
+	)
+
+	if tgt = cc.targets.GetTarget(name); tgt == nil {
+		return false, fmt.Errorf("%w: '%s'", ErrTargetNotFound, name)
+	}
+	return len(tgt.CookbookTimestamp) > 0 &&
+		appliedWithCookbook(tgt, cc.CookbookTimestamp()), nil
+}
+
+// returns whether the given target was applied with
+// the cookbook having the given timestamp or a newer one
+func appliedWithCookbook(t *target.Target, timestamp string) bool {
+	return !cookbookTimestampBefore(t.CookbookTimestamp, timestamp)
+}
+
+// returns whether cookbook timestamp a is older than
+// b. timestamps are the cookbook archive's modification
+// time in seconds since the epoch. timestamps that are
+// not numeric are compared as strings.
+func cookbookTimestampBefore(a, b string) bool {
+
+	var (
+		err error
+
+		ta, tb int64
+	)
+
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if ta, err = strconv.ParseInt(a, 10, 64); err == nil {
+		if tb, err = strconv.ParseInt(b, 10, 64); err == nil {
+			return ta < tb
+		}
+	}
+	return a < b
+}
+
+func (cc *configContext) Cookbook() *cookbook.Cookbook {
+	return cc.cookbook
+}
+
+func (cc *configContext) GetCookbookRecipe(recipe, iaas string) (cookbook.Recipe, error) {
+
+	return cc.recipeCache.get(recipe+"/"+iaas, func() (cookbook.Recipe, error) {
+
+		var (
+			err error
+
+			r    cookbook.Recipe
+			copy config.Configurable
+		)
+
+		if err = cc.cookbook.RecipeErr(recipe, iaas); err != nil {
+			return nil, err
+		}
+		if r = cc.Cookbook().GetRecipe(recipe, iaas); r == nil {
+			return nil, fmt.Errorf(
+				"%w: '%s' for iaas '%s'",
+				ErrRecipeNotFound, recipe, iaas)
+		}
+		if copy, err = r.Copy(); err != nil {
+			return nil, err
+		}
+		return copy.(cookbook.Recipe), nil
+	})
+}
+
+// validates the given recipe input values by applying them
+// to a copy of the recipe. the copy is discarded so nothing
+// is saved to the config.
+//
+// in: recipeName - the name of the recipe
+// in: iaas - the iaas of the recipe
+// in: values - map of recipe field names to the values to validate
+// out: an error for each value that is not valid or is for
+//      a field that the recipe does not have
+func (cc *configContext) ValidateRecipeInput(
+	recipeName, iaas string,
+	values map[string]string,
+) []error {
+
+	var (
+		err error
+
+		r     cookbook.Recipe
+		form  forms.InputForm
+		field *forms.InputField
+	)
+
+	if r, err = cc.GetCookbookRecipe(recipeName, iaas); err != nil {
+		return []error{err}
+	}
+	if form, err = r.InputForm(); err != nil {
+		return []error{err}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := []error{}
+	for _, name := range names {
+		if field, err = form.GetInputField(name); err != nil {
+			errs = append(errs, fmt.Errorf(
+				"recipe '%s' for iaas '%s' does not have a field named '%s'",
+				recipeName, iaas, name))
+			continue
+		}
+		value := values[name]
+		if err = field.SetValue(&value); err != nil {
+			errs = append(errs, fmt.Errorf(
+				"invalid value for field '%s': %w",
+				name, err))
+		}
+	}
+	return errs
+}
+
+func (cc *configContext) SaveCookbookRecipe(recipe cookbook.Recipe) {
+	cc.cookbook.SetRecipe(recipe)
+	cc.recipeCache.remove(recipe.Name())
+	cc.markDirty("recipes")
+}
+
+// returns the sorted names of the iaas' the given
+// recipe can be launched in
+//
+// in: recipeName - the name of the recipe
+// out: the iaas names for which a variant of the
+//      recipe exists in the cookbook
+func (cc *configContext) RecipeIaaSList(recipeName string) ([]string, error) {
+
+	if err := cc.cookbook.Err(); err != nil {
+		return nil, err
+	}
+	for _, info := range cc.cookbook.RecipeList() {
+		if info.Name == recipeName {
+			iaasList := make([]string, 0, len(info.IaaSList))
+			for _, iaas := range info.IaaSList {
+				iaasList = append(iaasList, iaas.Name())
+			}
+			sort.Strings(iaasList)
+			return iaasList, nil
+		}
+	}
+	return nil, fmt.Errorf(
+		"%w: '%s'",
+		ErrRecipeNotFound, recipeName)
+}
+
+// summary of a cookbook recipe
+type RecipeInfo struct {
+	Name string
+	// names of the iaas' the recipe
+	// can be launched in sorted by name
+	IaaSList []string
+	// true if the recipe requires a
+	// backend in any of its iaas'
+	BackendRequired bool
+}
+
+// returns a summary of each recipe in the cookbook
+//
+// out: recipe summaries sorted by recipe name
+func (cc *configContext) ListRecipes() []RecipeInfo {
+
+	recipes := []RecipeInfo{}
+	for _, info := range cc.cookbook.RecipeList() {
+		recipeInfo := RecipeInfo{
+			Name:     info.Name,
+			IaaSList: make([]string, 0, len(info.IaaSList)),
+		}
+		for _, iaas := range info.IaaSList {
+			recipeInfo.IaaSList = append(recipeInfo.IaaSList, iaas.Name())
+			if r := cc.cookbook.GetRecipe(info.Name, iaas.Name()); r != nil && len(r.BackendType()) > 0 {
+				recipeInfo.BackendRequired = true
+			}
+		}
+		sort.Strings(recipeInfo.IaaSList)
+		recipes = append(recipes, recipeInfo)
+	}
+	sort.Slice(recipes, func(i, j int) bool {
+		return recipes[i].Name < recipes[j].Name
+	})
+	return recipes
+}
+
+// returns the cookbook recipes whose names contain the
+// given query ignoring case. a recipe is returned for
+// each iaas it can be launched in. the recipes returned
+// are not copies so they should not be modified.
+//
+// in: query - the substring to search recipe names for
+// out: the matching recipes ordered as in the cookbook's
+//      recipe list
+func (cc *configContext) SearchRecipes(query string) []cookbook.Recipe {
+
+	query = strings.ToLower(query)
+	recipes := []cookbook.Recipe{}
+
+	for _, info := range cc.cookbook.RecipeList() {
+		if !strings.Contains(strings.ToLower(info.Name), query) {
+			continue
+		}
+		for _, iaas := range info.IaaSList {
+			if r := cc.cookbook.GetRecipe(info.Name, iaas.Name()); r != nil {
+				recipes = append(recipes, r)
+			}
+		}
+	}
+	return recipes
+}
+
+func (cc *configContext) CloudProviderTemplates() []provider.CloudProvider {
+
+	providerList := []provider.CloudProvider{}
+	for _, cp := range cc.cloudProviders() {
+		providerList = append(providerList, cp)
+	}
+
+	provider.SortCloudProviders(providerList)
+	return providerList
+}
+
+// returns the providers whose input forms have been fully
+// configured. unlike CloudProviderTemplates this does not
+// return providers whose required fields have not been set.
+//
+// out: the configured providers sorted like the templates
+func (cc *configContext) ConfiguredProviders() []provider.CloudProvider {
+
+	providerList := []provider.CloudProvider{}
+	for _, cp := range cc.cloudProviders() {
+		if cp.IsValid() {
+			providerList = append(providerList, cp)
+		}
+	}
+
+	provider.SortCloudProviders(providerList)
+	return providerList
+}
+
+// returns the required fields of the provider for the given
+// iaas that have not been set
+//
+// in: iaas - the iaas of the provider
+// out: the names of the required fields without a value in
+//      the order they appear in the provider's input form
+func (cc *configContext) ProviderCompleteness(iaas string) (missing []string, err error) {
+
+	var (
+		ok bool
+
+		p    provider.CloudProvider
+		form forms.InputForm
+	)
+
+	if err = cc.initTemplates(); err != nil {
+		return nil, err
+	}
+	if p, ok = cc.cloudProviders()[iaas]; !ok {
 		return nil, fmt.Errorf(
-			"provider for iaas '%s' does not exist",
-			iaas)
+			"%w: iaas '%s'",
+			ErrProviderNotFound, iaas)
+	}
+	if form, err = p.InputForm(); err != nil {
+		return nil, err
+	}
+	missing = []string{}
+	for _, field := range form.InputFields() {
+		if value := field.Value(); !field.Optional() && (value == nil || len(*value) == 0) {
+			missing = append(missing, field.Name())
+		}
+	}
+	return missing, nil
+}
+
+func (cc *configContext) GetCloudProvider(iaas string) (provider.CloudProvider, error) {
+
+	var (
+		err error
+		ok  bool
+
+		p    provider.CloudProvider
+		copy config.Configurable
+	)
+
+	if err = cc.initTemplates(); err != nil {
+		return nil, err
+	}
+	if p, ok = cc.cloudProviders()[iaas]; !ok {
+		return nil, fmt.Errorf(
+			"%w: iaas '%s'",
+			ErrProviderNotFound, iaas)
 	}
 	if copy, err = p.Copy(); err != nil {
 		return nil, err
@@ -304,8 +1372,184 @@ func (cc *configContext) GetCloudProvider(iaas string) (provider.CloudProvider,
 	return copy.(provider.CloudProvider), nil
 }
 
+// returns a copy of the provider for the given iaas with
+// the given field values applied. the copy is not saved
+// to the config.
+//
+// in: iaas - the name of the provider to clone
+// in: overrides - map of field names to the values to set
+// out: the cloned provider
+func (cc *configContext) CloneProvider(
+	iaas string,
+	overrides map[string]string,
+) (provider.CloudProvider, error) {
+
+	var (
+		err error
+
+		p    provider.CloudProvider
+		form forms.InputForm
+	)
+
+	if p, err = cc.GetCloudProvider(iaas); err != nil {
+		return nil, err
+	}
+	if form, err = p.InputForm(); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// ensure all fields exist before any are set
+	for _, name := range names {
+		if _, err = form.GetInputField(name); err != nil {
+			return nil, fmt.Errorf(
+				"provider for iaas '%s' does not have a field named '%s'",
+				iaas, name)
+		}
+	}
+	for _, name := range names {
+		if err = form.SetFieldValue(name, overrides[name]); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// sets the given field values of the provider for the given
+// iaas and saves it. the values are applied to a copy of the
+// provider which is only saved if all values could be set so
+// the saved provider is unchanged if any value is invalid.
+//
+// in: iaas - the name of the provider to update
+// in: patches - map of field names to the values to set
+func (cc *configContext) PatchProvider(iaas string, patches map[string]string) error {
+
+	var (
+		err error
+
+		p provider.CloudProvider
+	)
+
+	if p, err = cc.CloneProvider(iaas, patches); err != nil {
+		return err
+	}
+	cc.SaveCloudProvider(p)
+	return nil
+}
+
+// verifies that the provider for the given iaas can
+// connect to its cloud using its configured credentials
+//
+// in: iaas - the name of the provider to test
+// out: an error if the provider could not connect
+func (cc *configContext) TestProvider(iaas string) error {
+
+	var (
+		err error
+
+		p provider.CloudProvider
+	)
+
+	if p, err = cc.GetCloudProvider(iaas); err != nil {
+		return err
+	}
+	if !p.IsValid() {
+		return fmt.Errorf(
+			"provider for iaas '%s' has not been fully configured",
+			iaas)
+	}
+	if err = p.Connect(); err != nil {
+		return fmt.Errorf(
+			"provider for iaas '%s' was unable to connect using its configured credentials: %w",
+			iaas, err)
+	}
+	return nil
+}
+
 func (cc *configContext) SaveCloudProvider(provider provider.CloudProvider) {
-	cc.providers[provider.Name()] = provider
+	cc.cloudProviders()[provider.Name()] = provider
+	// any existing expiry is retained if the
+	// provider does not report its expiry
+	if p, ok := provider.(expiringProvider); ok {
+		if expiresAt := p.ExpiresAt(); !expiresAt.IsZero() {
+			cc.providerExpiry[provider.Name()] = expiresAt.UTC()
+		}
+	}
+	cc.markDirty("providers")
+}
+
+// deletes the configuration of the provider for the given iaas
+// by resetting it to an empty template. targets of the iaas
+// cannot be used without the provider so they are deleted
+// along with the provider if cascade is true.
+//
+// in: iaas - the iaas of the provider to delete
+// in: cascade - true to delete the targets of the iaas
+// out: the sorted keys of the targets that were deleted
+func (cc *configContext) DeleteProvider(iaas string, cascade bool) ([]string, error) {
+
+	var (
+		err error
+
+		template provider.CloudProvider
+	)
+
+	if err = cc.initTemplates(); err != nil {
+		return nil, err
+	}
+	if _, exists := cc.cloudProviders()[iaas]; !exists {
+		return nil, fmt.Errorf(
+			"%w: iaas '%s'",
+			ErrProviderNotFound, iaas)
+	}
+
+	dependents := []string{}
+	for _, t := range cc.targets.Filter(func(t *target.Target) bool {
+		return t.RecipeIaas == iaas
+	}) {
+		dependents = append(dependents, cc.targets.KeyOf(t))
+	}
+	sort.Strings(dependents)
+	if len(dependents) > 0 && !cascade {
+		return nil, fmt.Errorf(
+			"provider for iaas '%s' is used by targets %s",
+			iaas, strings.Join(dependents, ", "))
+	}
+
+	if template, err = provider.NewCloudProvider(iaas); err != nil {
+		return nil, err
+	}
+	for _, key := range dependents {
+		cc.targets.DeleteTarget(key)
+	}
+	if len(dependents) > 0 {
+		cc.markDirty("targets")
+	}
+	cc.cloudProviders()[iaas] = template
+	delete(cc.providerExpiry, iaas)
+	cc.markDirty("providers")
+	return dependents, nil
+}
+
+func (cc *configContext) CloudBackendTemplates() []backend.CloudBackend {
+
+	backends := cc.cloudBackends()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	backendList := make([]backend.CloudBackend, 0, len(names))
+	for _, name := range names {
+		backendList = append(backendList, backends[name])
+	}
+	return backendList
 }
 
 func (cc *configContext) GetCloudBackend(name string) (backend.CloudBackend, error) {
@@ -318,10 +1562,13 @@ func (cc *configContext) GetCloudBackend(name string) (backend.CloudBackend, err
 		copy config.Configurable
 	)
 
-	if b, ok = cc.backends[name]; !ok {
+	if err = cc.initTemplates(); err != nil {
+		return nil, err
+	}
+	if b, ok = cc.cloudBackends()[name]; !ok {
 		return nil, fmt.Errorf(
-			"backend of type '%s' does not exist",
-			name)
+			"%w: type '%s'",
+			ErrBackendNotFound, name)
 	}
 	if copy, err = b.Copy(); err != nil {
 		return nil, err
@@ -329,13 +1576,236 @@ func (cc *configContext) GetCloudBackend(name string) (backend.CloudBackend, err
 	return copy.(backend.CloudBackend), nil
 }
 
+// the iaas' whose providers can be used with each backend
+// type. backends that implement compatibleBackend report
+// their compatible providers themselves.
+var backendCompatibility = map[string][]string{
+	"s3":      {"aws"},
+	"azurerm": {"azure"},
+	"gcs":     {"google"},
+}
+
+// implemented by backends that can only be
+// used with the providers of certain iaas'
+type compatibleBackend interface {
+	CompatibleProviders() []string
+}
+
+// returns the providers that can be used with the given
+// backend type. if it is not known which providers the
+// backend can be used with then all providers are
+// returned along with a warning.
+//
+// in: backendType - the type of backend
+// out: the compatible providers sorted like the templates
+// out: a warning if the compatible providers are not known
+func (cc *configContext) CompatibleProviders(backendType string) ([]provider.CloudProvider, string, error) {
+
+	var (
+		err      error
+		ok       bool
+		iaasList []string
+
+		b backend.CloudBackend
+	)
+
+	if err = cc.initTemplates(); err != nil {
+		return nil, "", err
+	}
+	if b, ok = cc.cloudBackends()[backendType]; !ok {
+		return nil, "", fmt.Errorf(
+			"%w: type '%s'",
+			ErrBackendNotFound, backendType)
+	}
+	if cb, ok := b.(compatibleBackend); ok {
+		iaasList = cb.CompatibleProviders()
+	} else if iaasList, ok = backendCompatibility[backendType]; !ok {
+		return cc.CloudProviderTemplates(),
+			fmt.Sprintf("the providers compatible with backend '%s' are not known", backendType),
+			nil
+	}
+
+	providerList := []provider.CloudProvider{}
+	for _, iaas := range iaasList {
+		if cp, exists := cc.cloudProviders()[iaas]; exists {
+			providerList = append(providerList, cp)
+		}
+	}
+
+	provider.SortCloudProviders(providerList)
+	return providerList, "", nil
+}
+
+// returns the targets using each backend type. the backend
+// type of a target is the backend type of its recipe. backend
+// types that are not used by any target are not included.
+//
+// out: map of backend types to the targets sorted by key
+func (cc *configContext) BackendsInUse() map[string][]*target.Target {
+
+	inUse := make(map[string][]*target.Target)
+	for _, t := range cc.targets.GetTargets() {
+		if backendType := t.Recipe.BackendType(); len(backendType) > 0 {
+			inUse[backendType] = append(inUse[backendType], t)
+		}
+	}
+	for _, targets := range inUse {
+		sort.Slice(targets, func(i, j int) bool {
+			return targets[i].Key() < targets[j].Key()
+		})
+	}
+	return inUse
+}
+
+// the provider field of each iaas that identifies the
+// account or subscription the provider deploys to.
+// providers that implement accountProvider report
+// their account themselves. credentials such as aws
+// access keys are not used as they identify a user
+// and not the account the user belongs to.
+var providerAccountFields = map[string]string{
+	"aws":    "account_id",
+	"azure":  "subscription_id",
+	"google": "project",
+}
+
+// implemented by providers that can report the
+// account or subscription they deploy to
+type accountProvider interface {
+	AccountID() string
+}
+
+// returns the targets grouped by the account or subscription
+// their provider deploys to. targets whose provider does not
+// identify an account are grouped under the empty string.
+// this includes aws providers that are configured with only
+// an access key as the account of the key is not known
+// without querying aws.
+//
+// out: map of account identifiers to the targets sorted by key
+func (cc *configContext) TargetsByAccount() map[string][]*target.Target {
+
+	byAccount := make(map[string][]*target.Target)
+	for _, t := range cc.targets.GetTargets() {
+		account := targetAccount(t)
+		byAccount[account] = append(byAccount[account], t)
+	}
+	for _, targets := range byAccount {
+		sort.Slice(targets, func(i, j int) bool {
+			return targets[i].Key() < targets[j].Key()
+		})
+	}
+	return byAccount
+}
+
+// returns the identifier of the account the given target's
+// provider deploys to or an empty string if it is not known
+func targetAccount(t *target.Target) string {
+
+	if t.Provider == nil {
+		return ""
+	}
+	if ap, ok := t.Provider.(accountProvider); ok {
+		return ap.AccountID()
+	}
+	if field, ok := providerAccountFields[t.Provider.Name()]; ok {
+		if value, err := t.Provider.GetValue(field); err == nil && value != nil {
+			return *value
+		}
+	}
+	return ""
+}
+
+// returns the targets whose terraform state would be saved to
+// the same location. the state location of a target with a
+// backend is derived from the backend fields that locate the
+// state such as the bucket and key of an s3 backend. the state
+// of a target without a backend is saved locally to a path
+// derived from the target's recipe and key fields. targets
+// whose state does not collide with another target's state
+// are not included.
+//
+// out: map of state locations to the targets sorted by key
+func (cc *configContext) BackendPathConflicts() map[string][]*target.Target {
+
+	byPath := make(map[string][]*target.Target)
+	for _, t := range cc.targets.GetTargets() {
+		statePath := targetStatePath(t)
+		byPath[statePath] = append(byPath[statePath], t)
+	}
+	for statePath, targets := range byPath {
+		if len(targets) < 2 {
+			delete(byPath, statePath)
+			continue
+		}
+		sort.Slice(targets, func(i, j int) bool {
+			return targets[i].Key() < targets[j].Key()
+		})
+	}
+	return byPath
+}
+
+// the fields of each backend type that identify the
+// location the terraform state is saved to in the
+// backend's storage. the remaining fields such as the
+// backend's credentials do not change the location.
+var backendStateFields = map[string][]string{
+	"s3":      {"bucket", "key"},
+	"azurerm": {"storage_account_name", "container_name", "key"},
+	"gcs":     {"bucket", "prefix"},
+}
+
+// returns the location the given target's terraform state
+// is saved to as the backend type followed by the values of
+// the backend's state location fields or for local state the
+// target's working path
+func targetStatePath(t *target.Target) string {
+
+	local := "local:" + strings.Join(
+		append([]string{t.RecipeName, t.RecipeIaas}, t.Recipe.GetKeyFieldValues()...), "/")
+	if t.Backend == nil {
+		return local
+	}
+	fields, ok := backendStateFields[t.Backend.Name()]
+	if !ok {
+		// the location cannot be determined so the
+		// target's state is considered to be unique
+		return t.Backend.Name() + ":" + t.Key()
+	}
+	values := make([]string, 0, len(fields))
+	for _, name := range fields {
+		value, err := t.Backend.GetValue(name)
+		if err != nil || value == nil {
+			values = append(values, name+"=")
+		} else {
+			values = append(values, name+"="+*value)
+		}
+	}
+	return t.Backend.Name() + ":" + strings.Join(values, ",")
+}
+
 func (cc *configContext) SaveCloudBackend(backend backend.CloudBackend) {
-	cc.backends[backend.Name()] = backend
+	cc.cloudBackends()[backend.Name()] = backend
+	cc.markDirty("backends")
 }
 
 func (cc *configContext) NewTarget(
 	recipeName, recipeIaas string,
 ) (*target.Target, error) {
+	return cc.NewTargetWithBackend(recipeName, recipeIaas, "")
+}
+
+// creates a new target with the given backend instead
+// of the backend of the type required by the recipe
+//
+// in: recipeName - the name of the target's recipe
+// in: recipeIaas - the iaas of the target's recipe
+// in: backendType - the type of the target's backend. if
+//                   empty the recipe's backend type is used
+// out: the new target
+func (cc *configContext) NewTargetWithBackend(
+	recipeName, recipeIaas, backendType string,
+) (*target.Target, error) {
 
 	var (
 		err error
@@ -351,18 +1821,90 @@ func (cc *configContext) NewTarget(
 	if providerCopy, err = cc.GetCloudProvider(recipeIaas); err != nil {
 		return nil, err
 	}
-	backendType := recipeCopy.(cookbook.Recipe).BackendType()
+	if len(backendType) == 0 {
+		backendType = recipeCopy.(cookbook.Recipe).BackendType()
+	}
 	if len(backendType) != 0 {
 		if backendCopy, err = cc.GetCloudBackend(backendType); err != nil {
 			return nil, err
 		}
 	}
 
-	return target.NewTarget(
+	tgt := target.NewTarget(
 		recipeCopy,
 		providerCopy,
 		backendCopy,
-	), nil
+	)
+	cc.applyProviderDefaultTags(tgt)
+	return tgt, nil
+}
+
+// creates a new target with its recipe input fields pre-populated.
+// recipe fields without a value are set from the provider field
+// having the same name after which the given defaults are applied.
+// defaults for fields that are not in the recipe's input form are
+// ignored.
+//
+// in: recipeName - the name of the target's recipe
+// in: recipeIaas - the iaas of the target's recipe
+// in: defaults - map of recipe field names to values
+// out: the new target
+func (cc *configContext) NewTargetWithDefaults(
+	recipeName, recipeIaas string,
+	defaults map[string]string,
+) (*target.Target, error) {
+
+	var (
+		err error
+
+		tgt            *target.Target
+		providerValues map[string]*string
+		form           forms.InputForm
+		field          *forms.InputField
+	)
+
+	if tgt, err = cc.NewTarget(recipeName, recipeIaas); err != nil {
+		return nil, err
+	}
+	// read the provider's values before retrieving
+	// the recipe's form as configurables may share
+	// the same input form
+	if providerValues, err = fieldValues(tgt.Provider); err != nil {
+		return nil, err
+	}
+	if form, err = tgt.Recipe.InputForm(); err != nil {
+		return nil, err
+	}
+
+	for _, field = range form.InputFields() {
+		value := field.Value()
+		if value != nil && len(*value) > 0 {
+			continue
+		}
+		if v := providerValues[field.Name()]; v != nil && len(*v) > 0 {
+			if err = field.SetValue(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if field, err = form.GetInputField(name); err != nil {
+			// ignore fields not in the recipe
+			continue
+		}
+		value := defaults[name]
+		if err = field.SetValue(&value); err != nil {
+			return nil, err
+		}
+	}
+	return tgt, nil
 }
 
 func (cc *configContext) TargetSet() *target.TargetSet {
@@ -381,11 +1923,239 @@ func (cc *configContext) GetTarget(name string) (*target.Target, error) {
 	)
 
 	if tgt = cc.targets.GetTarget(name); tgt == nil {
-		return nil, fmt.Errorf("target '%s' does not exist", name)
+		return nil, fmt.Errorf("%w: '%s'", ErrTargetNotFound, name)
 	}
 	return tgt.Copy()
 }
 
+// changes the backend of a target to a new backend of the
+// given type. the target's recipe and provider are retained
+// and the target is flagged as requiring its terraform state
+// to be migrated. copying the state from the target's previous
+// backend is the responsibility of the caller which should
+// clear the flag via the target's SetStateMigrated() once the
+// state has been copied.
+//
+// in: name - the key of the target
+// in: newBackendType - the type of the target's new backend
+func (cc *configContext) MigrateTargetBackend(name, newBackendType string) error {
+
+	var (
+		err error
+
+		cb backend.CloudBackend
+	)
+
+	if cc.targets.GetTarget(name) == nil {
+		return fmt.Errorf("%w: '%s'", ErrTargetNotFound, name)
+	}
+	if cb, err = cc.GetCloudBackend(newBackendType); err != nil {
+		return err
+	}
+	return cc.UpdateTarget(name, func(t *target.Target) error {
+		if t.Backend != nil && t.Backend.Name() == newBackendType {
+			return fmt.Errorf(
+				"target '%s' already has a backend of type '%s'",
+				name, newBackendType)
+		}
+		t.MigrateBackend(cb)
+		return nil
+	})
+}
+
+// clears the terraform output of the given target which
+// is stale once the target's deployment has been destroyed.
+// the input values of the target are not changed and the
+// cleared output is retained in the target's history.
+//
+// in: name - the key of the target
+func (cc *configContext) ClearTargetOutput(name string) error {
+	return cc.UpdateTarget(name, func(t *target.Target) error {
+		t.Output = nil
+		return nil
+	})
+}
+
+// clears the terraform output of all targets
+//
+// out: the number of targets whose output was cleared
+func (cc *configContext) ClearAllOutputs() int {
+
+	cleared := 0
+	for _, tgt := range cc.targets.GetTargets() {
+		if tgt.Output != nil {
+			if err := cc.UpdateTarget(cc.targets.KeyOf(tgt), func(t *target.Target) error {
+				t.Output = nil
+				return nil
+			}); err != nil {
+				logger.DebugMessage(
+					"ClearAllOutputs(): unable to clear the output of target '%s': %s",
+					tgt.Key(), err.Error())
+				continue
+			}
+			cleared++
+		}
+	}
+	return cleared
+}
+
+// creates a copy of the given target for a new deployment.
+// the copy is not saved so that the caller can update it
+// before saving it.
+//
+// in: srcName - the name of the target to clone
+// in: newDeploymentName - the deployment name of the clone
+// out: the cloned target
+func (cc *configContext) CloneTarget(srcName, newDeploymentName string) (*target.Target, error) {
+
+	var (
+		tgt *target.Target
+	)
+
+	if tgt = cc.targets.GetTarget(srcName); tgt == nil {
+		return nil, fmt.Errorf("%w: '%s'", ErrTargetNotFound, srcName)
+	}
+	for _, t := range cc.targets.GetTargets() {
+		if t.DeploymentName() == newDeploymentName {
+			return nil, fmt.Errorf("a target with name '%s' already exists", newDeploymentName)
+		}
+	}
+	return tgt.Clone(newDeploymentName)
+}
+
+// returns a copy of the given target with its recipe replaced
+// by a copy of the current cookbook recipe. the input values
+// of the target's recipe are carried over to the new recipe
+// by field name. the target is not saved so that the caller
+// can review the updated target before saving it. the target
+// keeps the timestamp of the cookbook it was last applied with
+// as the new recipe has not been applied.
+//
+// in: name - the name of the target to refresh
+// out: the updated copy of the target
+// out: warnings for the values that could not be carried over
+//      as the field no longer exists or the value is invalid
+func (cc *configContext) RefreshTargetRecipe(name string) (*target.Target, []string, error) {
+
+	var (
+		err error
+
+		tgt, tgtCopy *target.Target
+		recipe       cookbook.Recipe
+		form         forms.InputForm
+		field        *forms.InputField
+		values       map[string]*string
+	)
+
+	if tgt = cc.targets.GetTarget(name); tgt == nil {
+		return nil, nil, fmt.Errorf("%w: '%s'", ErrTargetNotFound, name)
+	}
+	if values, err = fieldValues(tgt.Recipe); err != nil {
+		return nil, nil, err
+	}
+	if recipe, err = cc.GetCookbookRecipe(tgt.RecipeName, tgt.RecipeIaas); err != nil {
+		return nil, nil, err
+	}
+	if form, err = recipe.InputForm(); err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0, len(values))
+	for fieldName := range values {
+		names = append(names, fieldName)
+	}
+	sort.Strings(names)
+
+	warnings := []string{}
+	for _, fieldName := range names {
+		if field, err = form.GetInputField(fieldName); err != nil {
+			warnings = append(warnings,
+				fmt.Sprintf("field '%s' has been removed from the recipe", fieldName))
+			continue
+		}
+		if value := values[fieldName]; value != nil {
+			if err = field.SetValue(value); err != nil {
+				warnings = append(warnings,
+					fmt.Sprintf("value of field '%s' is not valid for the recipe: %s", fieldName, err.Error()))
+			}
+		}
+	}
+
+	if tgtCopy, err = tgt.Copy(); err != nil {
+		return nil, nil, err
+	}
+	// the cookbook timestamp is set when the target is
+	// applied so that it is still reported as stale
+	tgtCopy.Recipe = recipe
+	return tgtCopy, warnings, nil
+}
+
 func (cc *configContext) SaveTarget(key string, target *target.Target) {
 	cc.targets.SaveTarget(key, target)
+	cc.markDirty("targets")
+}
+
+// modifies the target with the given key while the target
+// set is locked. this avoids replacing changes saved to the
+// target between retrieving a copy of it and saving it.
+//
+// in: name - the key of the target
+// in: mutate - function that modifies the target
+func (cc *configContext) UpdateTarget(name string, mutate func(*target.Target) error) error {
+
+	if cc.targets.GetTarget(name) == nil {
+		return fmt.Errorf("%w: '%s'", ErrTargetNotFound, name)
+	}
+	if err := cc.targets.UpdateTarget(name, mutate); err != nil {
+		return err
+	}
+	cc.markDirty("targets")
+	return nil
+}
+
+// validates that the given target can be saved by serializing
+// it and loading it in to a separate target set. the target
+// set of this context is not modified.
+//
+// in: t - the target to validate
+// out: an error if the target would not load as it was saved
+func (cc *configContext) ValidateTarget(t *target.Target) error {
+
+	var (
+		err  error
+		data []byte
+
+		saved *target.Target
+	)
+
+	// a copy is saved as saving a target
+	// sets the time it was updated
+	if saved, err = t.Copy(); err != nil {
+		return err
+	}
+	ts := target.NewTargetSet(cc)
+	ts.SaveTarget(saved.Key(), saved)
+	if data, err = jsonenc.Marshal(ts); err != nil {
+		return fmt.Errorf(
+			"target '%s' cannot be serialized: %w",
+			t.Key(), err)
+	}
+
+	ts = target.NewTargetSet(cc)
+	if err = json.Unmarshal(data, ts); err != nil {
+		return fmt.Errorf(
+			"target '%s' cannot be loaded once saved: %w",
+			t.Key(), err)
+	}
+	if orphaned := ts.OrphanedTargets(); len(orphaned) > 0 {
+		return fmt.Errorf(
+			"target '%s' cannot be loaded once saved: %w",
+			t.Key(), orphaned[0].Err)
+	}
+	if targets := ts.GetTargets(); len(targets) != 1 || !targets[0].Equal(saved) {
+		return fmt.Errorf(
+			"target '%s' does not load with the configuration it was saved with",
+			t.Key())
+	}
+	return nil
 }
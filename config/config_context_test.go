@@ -1,8 +1,16 @@
 package config_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gobuffalo/packr/v2"
 
@@ -13,12 +21,13 @@ import (
 	"github.com/appbricks/cloud-builder/config"
 	"github.com/appbricks/cloud-builder/cookbook"
 	"github.com/appbricks/cloud-builder/target"
+	"github.com/appbricks/cloud-builder/terraform"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
-	cloud_test_data "github.com/mevansam/gocloud/test/data"
 	test_data "github.com/appbricks/cloud-builder/test/data"
+	cloud_test_data "github.com/mevansam/gocloud/test/data"
 )
 
 var _ = Describe("Config Tests", func() {
@@ -194,115 +203,1691 @@ var _ = Describe("Config Tests", func() {
 			Expect(actual).To(Equal(expected))
 		})
 
-		It("edits config elements without modifying the main config", func() {
+		It("writes a configuration document deterministically", func() {
 
 			var (
-				cp  provider.CloudProvider
-				tgt *target.Target
+				ctx2 config.Context
 
-				recipe1, recipe2 cookbook.Recipe
+				saved1, saved2 strings.Builder
+			)
+
+			err = ctx.Save(&saved1)
+			Expect(err).NotTo(HaveOccurred())
+
+			// round trip the saved configuration
+			ctx2, err = config.NewConfigContext(ctx.Cookbook())
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.Load(strings.NewReader(saved1.String()))
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.Save(&saved2)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(saved2.String()).To(Equal(saved1.String()))
+		})
+
+		It("fingerprints a configuration", func() {
+
+			var (
+				ctx2  config.Context
+				saved strings.Builder
+
+				fingerprint1, fingerprint2 string
+			)
+
+			fingerprint1, err = ctx.Fingerprint()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fingerprint1).To(HaveLen(64))
+
+			err = ctx.Save(&saved)
+			Expect(err).NotTo(HaveOccurred())
+			ctx2, err = config.NewConfigContext(ctx.Cookbook())
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.Load(strings.NewReader(saved.String()))
+			Expect(err).NotTo(HaveOccurred())
+			fingerprint2, err = ctx2.Fingerprint()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fingerprint2).To(Equal(fingerprint1))
+
+			ctx2.TargetSet().DeleteTarget("basic/aws/aa/")
+			fingerprint2, err = ctx2.Fingerprint()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fingerprint2).ToNot(Equal(fingerprint1))
+		})
+
+		It("writes and reads a YAML configuration document", func() {
+
+			var (
+				ctx2 config.Context
+
+				yamlOutput, saved1, saved2 strings.Builder
+			)
+
+			err = ctx.SaveYAML(&yamlOutput)
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx2, err = config.NewConfigContext(ctx.Cookbook())
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.LoadYAML(strings.NewReader(yamlOutput.String()))
+			Expect(err).NotTo(HaveOccurred())
+
+			// YAML round trip should result in the same config
+			err = ctx.Save(&saved1)
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.Save(&saved2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(saved2.String()).To(Equal(saved1.String()))
+
+			cp, err := ctx2.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			cloud_test_data.ValidateAWSConfigDocument(cp)
+		})
+
+		It("saves and loads a configuration as newline delimited json", func() {
+
+			var (
+				ctx2 config.Context
+
+				ndjsonOutput,
+				saved1, saved2 strings.Builder
+			)
+
+			err = ctx.SaveNDJSON(&ndjsonOutput)
+			Expect(err).NotTo(HaveOccurred())
+
+			lines := strings.Split(strings.TrimSuffix(ndjsonOutput.String(), "\n"), "\n")
+			types := map[string]int{}
+			for _, line := range lines {
+				event := make(map[string]interface{})
+				err = json.Unmarshal([]byte(line), &event)
+				Expect(err).NotTo(HaveOccurred())
+				types[event["type"].(string)]++
+			}
+			Expect(lines[0]).To(HavePrefix(`{"type":"version",`))
+			Expect(types["version"]).To(Equal(1))
+			Expect(types["provider"]).To(Equal(3))
+			Expect(types["backend"]).To(Equal(3))
+			Expect(types["recipe"]).To(Equal(2))
+			Expect(types["target"]).To(Equal(2))
+
+			ctx2, err = config.NewConfigContext(ctx.Cookbook())
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.LoadNDJSON(strings.NewReader(ndjsonOutput.String()))
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ctx.Save(&saved1)
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.Save(&saved2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(saved2.String()).To(Equal(saved1.String()))
+
+			err = ctx2.LoadNDJSON(strings.NewReader(strings.Join(lines[1:], "\n")))
+			Expect(err).To(HaveOccurred())
+			err = ctx2.LoadNDJSON(strings.NewReader(lines[0] + "\n" + `{"type":"unknown"}`))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("line 2 of the config stream: unknown config stream event type 'unknown'"))
+		})
+
+		It("returns the differences between two configurations", func() {
+
+			var (
+				ctx2    config.Context
+				changes []config.Change
+
+				cp   provider.CloudProvider
+				form forms.InputForm
+			)
+
+			ctx2, err = config.NewConfigContext(ctx.Cookbook())
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.Load(strings.NewReader(configDocument))
+			Expect(err).NotTo(HaveOccurred())
+
+			changes, err = config.Diff(ctx, ctx2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes).To(BeEmpty())
+
+			cp, err = ctx2.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			form, err = cp.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("secret_key", "updated secret_key")
+			Expect(err).NotTo(HaveOccurred())
+			ctx2.SaveCloudProvider(cp)
+
+			ctx2.TargetSet().DeleteTarget("basic/aws/cc/appbrickscookbook")
+
+			changes, err = config.Diff(ctx, ctx2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(changes)).To(Equal(2))
+			Expect(changes[0].String()).To(Equal("~ provider aws (secret_key changed)"))
+			Expect(changes[1].String()).To(Equal("- target basic/aws/cc/appbrickscookbook"))
+
+			// a removed backend is a change of the target
+			tgt, err := ctx2.TargetSet().GetTarget("basic/aws/aa/").Copy()
+			Expect(err).NotTo(HaveOccurred())
+			tgt.Backend = nil
+			ctx2.TargetSet().SaveTarget("basic/aws/aa/", tgt)
+
+			changes, err = config.Diff(ctx, ctx2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(changes)).To(Equal(3))
+			Expect(changes[1].String()).To(Equal("~ target basic/aws/aa/ (backend changed)"))
+
+			// targets are paired by the keys of their target sets
+			keyFunc := func(t *target.Target) string {
+				return "custom/" + t.Key()
+			}
+			ctx.TargetSet().SetKeyFunc(keyFunc)
+			ctx2.TargetSet().SetKeyFunc(keyFunc)
+
+			changes, err = config.Diff(ctx, ctx2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(changes)).To(Equal(3))
+			Expect(changes[1].String()).To(Equal("~ target custom/basic/aws/aa/ (backend changed)"))
+			Expect(changes[2].String()).To(Equal("- target custom/basic/aws/cc/appbrickscookbook"))
+		})
 
+		It("syncs a configuration from a remote configuration", func() {
+
+			var (
+				remote config.Context
+				report config.SyncReport
+
+				cp    provider.CloudProvider
 				form  forms.InputForm
 				value *string
 			)
 
-			// provider elements
-			form, err = cpAWS.InputForm()
+			remote, err = config.NewConfigContext(ctx.Cookbook())
 			Expect(err).NotTo(HaveOccurred())
-			err = form.SetFieldValue("access_key", "updated access_key")
+			err = remote.Load(strings.NewReader(configDocument))
 			Expect(err).NotTo(HaveOccurred())
 
-			// cloud provider key should remain unchanged in main config structure
-			cp, err = ctx.GetCloudProvider("aws")
+			cp, err = remote.GetCloudProvider("aws")
 			Expect(err).NotTo(HaveOccurred())
-			value, err = cp.GetValue("access_key")
-			Expect(*value).To(Equal("83BFAD5B-FEAC-4019-A645-3858847CB3ED"))
+			form, err = cp.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("secret_key", "remote secret_key")
+			Expect(err).NotTo(HaveOccurred())
+			remote.SaveCloudProvider(cp)
 
-			// updated provider key value should now be persisted to main config structure
-			ctx.SaveCloudProvider(cpAWS)
+			ctx.TargetSet().DeleteTarget("basic/aws/cc/appbrickscookbook")
+
+			report, err = ctx.SyncFrom(remote, config.LocalWins)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Added).To(Equal([]string{"target/basic/aws/cc/appbrickscookbook"}))
+			Expect(report.Updated).To(BeEmpty())
+			Expect(report.Conflicted).To(Equal([]string{"provider/aws"}))
+			Expect(ctx.HasTarget("basic/aws/cc/appbrickscookbook")).To(BeTrue())
+
+			report, err = ctx.SyncFrom(remote, config.RemoteWins)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Added).To(BeEmpty())
+			Expect(report.Updated).To(Equal([]string{"provider/aws"}))
+			Expect(report.Conflicted).To(BeEmpty())
 
 			cp, err = ctx.GetCloudProvider("aws")
 			Expect(err).NotTo(HaveOccurred())
-			value, err = cp.GetValue("access_key")
-			Expect(*value).To(Equal("updated access_key"))
+			value, err = cp.GetValue("secret_key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("remote secret_key"))
+		})
 
-			// recipe elements
-			recipe1, err = ctx.GetCookbookRecipe("basic", "aws")
+		It("searches the cookbook recipes", func() {
+
+			names := func(recipes []cookbook.Recipe) []string {
+				l := []string{}
+				for _, r := range recipes {
+					l = append(l, r.Name())
+				}
+				return l
+			}
+
+			Expect(names(ctx.SearchRecipes("BAS"))).To(Equal([]string{"basic/aws", "basic/google"}))
+			Expect(names(ctx.SearchRecipes("mpl"))).To(Equal([]string{"simple/google"}))
+			Expect(len(ctx.SearchRecipes(""))).To(Equal(3))
+			Expect(ctx.SearchRecipes("unknown")).To(BeEmpty())
+		})
+
+		It("lists the iaas' a recipe can be launched in", func() {
+
+			var (
+				iaasList []string
+			)
+
+			iaasList, err = ctx.RecipeIaaSList("basic")
 			Expect(err).NotTo(HaveOccurred())
+			Expect(iaasList).To(Equal([]string{"aws", "google"}))
 
-			form, err = recipe1.InputForm()
+			iaasList, err = ctx.RecipeIaaSList("simple")
 			Expect(err).NotTo(HaveOccurred())
-			err = form.SetFieldValue("test_input_6", "abcd666")
+			Expect(iaasList).To(Equal([]string{"google"}))
+
+			_, err = ctx.RecipeIaaSList("unknown")
+			Expect(errors.Is(err, config.ErrRecipeNotFound)).To(BeTrue())
+			Expect(err.Error()).To(Equal("recipe does not exist: 'unknown'"))
+		})
+
+		It("validates recipe input values", func() {
+
+			errs := ctx.ValidateRecipeInput("basic", "aws", map[string]string{
+				"test_input_1": "a valid value",
+				"test_input_2": "not a cookbook",
+				"unknown":      "value",
+			})
+			Expect(errs).To(HaveLen(2))
+			Expect(errs[0].Error()).To(ContainSubstring("test_input_2"))
+			Expect(errs[1].Error()).To(Equal("recipe 'basic' for iaas 'aws' does not have a field named 'unknown'"))
+
+			Expect(ctx.ValidateRecipeInput("basic", "aws", map[string]string{
+				"test_input_2": "appbrickscookbook",
+			})).To(BeEmpty())
+
+			// the cookbook recipe is not modified
+			r, err := ctx.GetCookbookRecipe("basic", "aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err := r.GetValue("test_input_1")
 			Expect(err).NotTo(HaveOccurred())
+			Expect(value == nil || *value != "a valid value").To(BeTrue())
+		})
 
-			// recipe key should remain unchanged in main config structure
-			recipe2, err = ctx.GetCookbookRecipe("basic", "aws")
+		It("returns independent copies of cached recipes", func() {
+
+			var (
+				r1, r2 cookbook.Recipe
+				value  *string
+			)
+
+			for _, size := range []int{0, 1, 4} {
+				ctx.SetRecipeCacheSize(size)
+
+				r1, err = ctx.GetCookbookRecipe("basic", "aws")
+				Expect(err).NotTo(HaveOccurred())
+				form, err := r1.InputForm()
+				Expect(err).NotTo(HaveOccurred())
+				err = form.SetFieldValue("test_input_1", "updated value")
+				Expect(err).NotTo(HaveOccurred())
+
+				// other recipes may evict the cached recipe
+				_, err = ctx.GetCookbookRecipe("basic", "google")
+				Expect(err).NotTo(HaveOccurred())
+
+				r2, err = ctx.GetCookbookRecipe("basic", "aws")
+				Expect(err).NotTo(HaveOccurred())
+				value, err = r2.GetValue("test_input_1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value == nil || *value != "updated value").To(BeTrue())
+			}
+
+			// saved recipes replace the cached recipe
+			ctx.SetRecipeCacheSize(4)
+			_, err = ctx.GetCookbookRecipe("basic", "aws")
 			Expect(err).NotTo(HaveOccurred())
-			value, err = recipe2.GetValue("test_input_6")
-			Expect(*value).To(Equal("abcd66"))
+			ctx.SaveCookbookRecipe(r1)
+			r2, err = ctx.GetCookbookRecipe("basic", "aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err = r2.GetValue("test_input_1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("updated value"))
 
-			// updated recipe key value should now be persisted to main config structure
-			ctx.SaveCookbookRecipe(recipe1)
+			_, err = ctx.GetCookbookRecipe("basic", "unknown")
+			Expect(errors.Is(err, config.ErrRecipeNotFound)).To(BeTrue())
+		})
 
-			recipe2, err = ctx.GetCookbookRecipe("basic", "aws")
+		It("lists the recipes in the cookbook", func() {
+
+			recipes := ctx.ListRecipes()
+			Expect(recipes).To(HaveLen(2))
+			Expect(recipes[0].Name).To(Equal("basic"))
+			Expect(recipes[0].IaaSList).To(Equal([]string{"aws", "google"}))
+			Expect(recipes[0].BackendRequired).To(BeTrue())
+			Expect(recipes[1].Name).To(Equal("simple"))
+			Expect(recipes[1].IaaSList).To(Equal([]string{"google"}))
+		})
+
+		It("saves only when the configuration has been modified", func() {
+
+			var (
+				saved  bool
+				output strings.Builder
+			)
+
+			saved, err = ctx.SaveIfDirty(&output)
 			Expect(err).NotTo(HaveOccurred())
-			value, err = recipe2.GetValue("test_input_6")
-			Expect(*value).To(Equal("abcd666"))
+			Expect(saved).To(BeFalse())
+			Expect(output.Len()).To(Equal(0))
 
-			// target elements
-			form, err = tgt1.Recipe.InputForm()
+			cp, err := ctx.GetCloudProvider("aws")
 			Expect(err).NotTo(HaveOccurred())
-			err = form.SetFieldValue("test_input_7", "eu-central-1")
+			ctx.SaveCloudProvider(cp)
+
+			saved, err = ctx.SaveIfDirty(&output)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(saved).To(BeTrue())
+			Expect(output.Len()).To(BeNumerically(">", 0))
 
-			form, err = tgt1.Provider.InputForm()
+			output.Reset()
+			saved, err = ctx.SaveIfDirty(&output)
 			Expect(err).NotTo(HaveOccurred())
-			err = form.SetFieldValue("region", "eu-central-1")
+			Expect(saved).To(BeFalse())
+
+			ctx.MarkAllDirty()
+			saved, err = ctx.SaveIfDirty(&output)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(saved).To(BeTrue())
 
-			// updated target should now be persisted to main config structure
-			tgt, err = ctx.GetTarget("basic/aws/aa/")
+			// changes made directly to the target set are saved
+			other, err := ctx.TargetSet().Copy()
 			Expect(err).NotTo(HaveOccurred())
-			value, err = tgt.Recipe.GetValue("test_input_7")
-			Expect(*value).To(Equal("us-east-1"))
-			value, err = tgt.Provider.GetValue("region")
-			Expect(*value).To(Equal("us-east-1"))
+			_, _, err = ctx.TargetSet().Merge(other, target.Overwrite)
+			Expect(err).NotTo(HaveOccurred())
+			saved, err = ctx.SaveIfDirty(&output)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(saved).To(BeTrue())
+		})
 
-			// updated target should now be persisted to main config structure
-			ctx.SaveTarget("basic/aws/aa/", tgt1)
+		It("tracks changes made concurrently to the target set", func() {
 
-			tgt, err = ctx.GetTarget("basic/aws/aa/")
+			var (
+				wg sync.WaitGroup
+			)
+
+			// run with -race to detect unguarded
+			// updates of the modified sections
+			_, err = ctx.SaveIfDirty(&strings.Builder{})
+			Expect(err).NotTo(HaveOccurred())
+
+			keys := []string{"basic/aws/aa/", "basic/aws/cc/appbrickscookbook"}
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					key := keys[i%len(keys)]
+					err := ctx.TargetSet().UpdateTarget(key, func(t *target.Target) error {
+						t.Tags = map[string]string{"update": fmt.Sprintf("%d", i)}
+						return nil
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					tgt, err := ctx.TargetSet().GetTarget(key).Copy()
+					Expect(err).NotTo(HaveOccurred())
+					ctx.TargetSet().SaveTarget(key, tgt)
+					ctx.SaveTarget(key, tgt)
+
+					_, err = ctx.SaveIfDirty(&strings.Builder{})
+					Expect(err).NotTo(HaveOccurred())
+				}(i)
+			}
+			wg.Wait()
+
+			Expect(ctx.TargetSet().GetTargets()).To(HaveLen(2))
+			ctx.TargetSet().DeleteTarget(keys[0])
+			Expect(ctx.SaveIfDirty(&strings.Builder{})).To(BeTrue())
+		})
+
+		It("is modified when targets matching a predicate are deleted", func() {
+
+			_, err = ctx.SaveIfDirty(&strings.Builder{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ctx.TargetSet().DeleteWhere(func(t *target.Target) bool {
+				return t.Key() == "basic/aws/aa/"
+			})).To(Equal(1))
+			Expect(ctx.SaveIfDirty(&strings.Builder{})).To(BeTrue())
+		})
+
+		It("reports targets applied with an older cookbook", func() {
+
+			timestamp := ctx.Cookbook().Timestamp()
+			Expect(timestamp).ToNot(BeEmpty())
+
+			// targets that have not been applied are not stale
+			Expect(ctx.StaleTargets()).To(BeEmpty())
+
+			ctx.TargetSet().GetTarget("basic/aws/aa/").CookbookTimestamp = "1"
+			ctx.TargetSet().GetTarget("basic/aws/cc/appbrickscookbook").CookbookTimestamp = timestamp
+
+			stale := ctx.StaleTargets()
+			Expect(len(stale)).To(Equal(1))
+			Expect(stale[0].Key()).To(Equal("basic/aws/aa/"))
+		})
+
+		It("compares the cookbook timestamp of targets with the cookbook's", func() {
+
+			var (
+				current bool
+			)
+
+			Expect(ctx.CookbookTimestamp()).To(Equal(ctx.Cookbook().Timestamp()))
+
+			// targets that have not been applied are not current
+			current, err = ctx.IsTargetCurrent("basic/aws/aa/")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(BeFalse())
+
+			// timestamps are compared numerically
+			ctx.TargetSet().GetTarget("basic/aws/aa/").CookbookTimestamp = "9" + ctx.CookbookTimestamp()[1:]
+			ctx.TargetSet().GetTarget("basic/aws/cc/appbrickscookbook").CookbookTimestamp = " " + ctx.CookbookTimestamp()
+			current, err = ctx.IsTargetCurrent("basic/aws/aa/")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(BeTrue())
+			current, err = ctx.IsTargetCurrent("basic/aws/cc/appbrickscookbook")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(BeTrue())
+
+			ctx.TargetSet().GetTarget("basic/aws/aa/").CookbookTimestamp = "1"
+			current, err = ctx.IsTargetCurrent("basic/aws/aa/")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(BeFalse())
+
+			_, err = ctx.IsTargetCurrent("unknown")
+			Expect(errors.Is(err, config.ErrTargetNotFound)).To(BeTrue())
+		})
+
+		It("reports targets that need to be applied", func() {
+
+			// targets that have not been applied
+			// have no output and need applying
+			Expect(ctx.TargetsNeedingReapply()).To(HaveLen(2))
+
+			ctx.TargetSet().GetTarget("basic/aws/aa/").CookbookTimestamp = "1"
+			ctx.TargetSet().GetTarget("basic/aws/aa/").Output = &map[string]terraform.Output{}
+			Expect(ctx.TargetsNeedingReapply()).To(HaveLen(2))
+
+			tgt := ctx.TargetSet().GetTarget("basic/aws/cc/appbrickscookbook")
+			tgt.CookbookTimestamp = ctx.Cookbook().Timestamp()
+			tgt.Output = &map[string]terraform.Output{}
+
+			reapply := ctx.TargetsNeedingReapply()
+			Expect(reapply).To(HaveLen(1))
+			Expect(reapply[0].Key()).To(Equal("basic/aws/aa/"))
+		})
+
+		It("clones a provider with overridden field values", func() {
+
+			var (
+				value *string
+			)
+
+			cp, err := ctx.CloneProvider("aws", map[string]string{"region": "eu-central-1"})
+			Expect(err).NotTo(HaveOccurred())
+			value, err = cp.GetValue("region")
 			Expect(err).NotTo(HaveOccurred())
-			value, err = tgt.Recipe.GetValue("test_input_7")
-			Expect(*value).To(Equal("eu-central-1"))
-			value, err = tgt.Provider.GetValue("region")
 			Expect(*value).To(Equal("eu-central-1"))
+			value, err = cp.GetValue("access_key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("83BFAD5B-FEAC-4019-A645-3858847CB3ED"))
+
+			// the provider in the config is unchanged
+			cp, err = ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err = cp.GetValue("region")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).ToNot(Equal("eu-central-1"))
+
+			_, err = ctx.CloneProvider("aws", map[string]string{"unknown": "value"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("provider for iaas 'aws' does not have a field named 'unknown'"))
 		})
-	})
-})
 
-const configDocument = `
-{
-	"cloud": {
-		"providers": {
-			"aws": ` + cloud_test_data.AWSProviderConfig + `,
-			"azure": ` + cloud_test_data.AzureProviderConfig + `,
-			"google": ` + cloud_test_data.GoogleProviderConfig + `
-		},
-		"backends": {
-			"s3": ` + cloud_test_data.S3BackendConfig + `,
-			"azurerm": ` + cloud_test_data.AzureRMBackendConfig + `,
-			"gcs": ` + cloud_test_data.GCSBackendConfig + `
-		},
-		"recipes": ` + test_data.CookbookConfigDocument + `,
-		"targets": [
-			{
-				"recipeName": "basic",
-				"recipeIaas": "aws",
-				"recipe": {
-					"variables": ` + test_data.AWSBasicRecipeVariables1 + `
-				},
-				"provider": ` + cloud_test_data.AWSProviderConfig + `,
+		It("returns only the providers that have been configured", func() {
+
+			templates := ctx.CloudProviderTemplates()
+			configured := ctx.ConfiguredProviders()
+			Expect(len(configured)).To(BeNumerically("<=", len(templates)))
+			for _, cp := range configured {
+				Expect(cp.IsValid()).To(BeTrue())
+			}
+			for _, cp := range templates {
+				Expect(cp.IsValid()).To(Equal(containsProvider(configured, cp.Name())))
+			}
+		})
+
+		It("reports the required provider fields that have not been set", func() {
+
+			var (
+				cp      provider.CloudProvider
+				missing []string
+			)
+
+			cp, err = ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			form, err := cp.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("access_key", "")
+			Expect(err).NotTo(HaveOccurred())
+			ctx.SaveCloudProvider(cp)
+
+			missing, err = ctx.ProviderCompleteness("aws")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(missing).To(ContainElement("access_key"))
+			Expect(missing).NotTo(ContainElement("secret_key"))
+
+			_, err = ctx.ProviderCompleteness("unknown")
+			Expect(errors.Is(err, config.ErrProviderNotFound)).To(BeTrue())
+		})
+
+		It("deletes a provider and the targets that depend on it", func() {
+
+			var (
+				deleted []string
+			)
+
+			_, err = ctx.DeleteProvider("aws", false)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("provider for iaas 'aws' is used by targets basic/aws/aa/, basic/aws/cc/appbrickscookbook"))
+			Expect(ctx.TargetSet().Count()).To(Equal(2))
+
+			deleted, err = ctx.DeleteProvider("google", false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deleted).To(BeEmpty())
+
+			deleted, err = ctx.DeleteProvider("aws", true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deleted).To(Equal([]string{"basic/aws/aa/", "basic/aws/cc/appbrickscookbook"}))
+			Expect(ctx.TargetSet().Count()).To(Equal(0))
+
+			// the provider is reset to an empty template
+			missing, err := ctx.ProviderCompleteness("aws")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(missing).To(ContainElement("access_key"))
+
+			_, err = ctx.DeleteProvider("unknown", true)
+			Expect(errors.Is(err, config.ErrProviderNotFound)).To(BeTrue())
+		})
+
+		It("compacts providers that are not configured or used", func() {
+
+			var (
+				removed int
+				output  strings.Builder
+			)
+
+			// all providers and backends are
+			// configured in the loaded config
+			removed, err = ctx.Compact()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(0))
+
+			_, err = ctx.DeleteProvider("google", false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = ctx.DeleteProvider("aws", false)
+			Expect(err).To(HaveOccurred())
+			removed, err = ctx.Compact()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(1))
+
+			_, err = ctx.GetCloudProvider("google")
+			Expect(errors.Is(err, config.ErrProviderNotFound)).To(BeTrue())
+			_, err = ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+
+			saved, err := ctx.SaveIfDirty(&output)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(saved).To(BeTrue())
+
+			actualConfigData := make(map[string]interface{})
+			err = json.Unmarshal([]byte(output.String()), &actualConfigData)
+			Expect(err).NotTo(HaveOccurred())
+			providers := actualConfigData["cloud"].(map[string]interface{})["providers"].(map[string]interface{})
+			Expect(providers).To(HaveKey("aws"))
+			Expect(providers).To(HaveKey("azure"))
+			Expect(providers).NotTo(HaveKey("google"))
+		})
+
+		It("returns the providers a backend can be used with", func() {
+
+			var (
+				providers []provider.CloudProvider
+				warning   string
+			)
+
+			providers, warning, err = ctx.CompatibleProviders("s3")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warning).To(BeEmpty())
+			Expect(len(providers)).To(Equal(1))
+			Expect(providers[0].Name()).To(Equal("aws"))
+
+			_, _, err = ctx.CompatibleProviders("unknown")
+			Expect(errors.Is(err, config.ErrBackendNotFound)).To(BeTrue())
+		})
+
+		It("patches the fields of a provider", func() {
+
+			var (
+				cp    provider.CloudProvider
+				value *string
+			)
+
+			err = ctx.PatchProvider("aws", map[string]string{
+				"region":     "eu-central-1",
+				"access_key": "patched access key",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctx.SaveIfDirty(&strings.Builder{})).To(BeTrue())
+
+			cp, err = ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err = cp.GetValue("region")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("eu-central-1"))
+			value, err = cp.GetValue("access_key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("patched access key"))
+
+			// no fields are changed if a patch fails
+			err = ctx.PatchProvider("aws", map[string]string{
+				"region":  "us-west-1",
+				"unknown": "value",
+			})
+			Expect(err).To(HaveOccurred())
+			cp, err = ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err = cp.GetValue("region")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("eu-central-1"))
+		})
+
+		It("tracks the expiry of provider credentials", func() {
+
+			var (
+				cp        provider.CloudProvider
+				expiresAt time.Time
+				exists    bool
+			)
+
+			Expect(ctx.ExpiredProviders()).To(BeEmpty())
+			err = ctx.SetProviderExpiry("unknown", time.Now())
+			Expect(errors.Is(err, config.ErrProviderNotFound)).To(BeTrue())
+
+			expired := time.Now().Add(-time.Hour).Round(time.Second)
+			err = ctx.SetProviderExpiry("aws", expired)
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx.SetProviderExpiry("google", time.Now().Add(time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctx.ExpiredProviders()).To(Equal([]string{"aws"}))
+
+			// expiry is retained when a provider is saved
+			cp, err = ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			ctx.SaveCloudProvider(cp)
+
+			output := strings.Builder{}
+			err = ctx.Save(&output)
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx.Reset()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctx.ExpiredProviders()).To(BeEmpty())
+
+			err = ctx.Load(strings.NewReader(output.String()))
+			Expect(err).NotTo(HaveOccurred())
+			expiresAt, exists = ctx.ProviderExpiresAt("aws")
+			Expect(exists).To(BeTrue())
+			Expect(expiresAt.Equal(expired)).To(BeTrue())
+			Expect(ctx.ExpiredProviders()).To(Equal([]string{"aws"}))
+
+			err = ctx.SetProviderExpiry("aws", time.Time{})
+			Expect(err).NotTo(HaveOccurred())
+			_, exists = ctx.ProviderExpiresAt("aws")
+			Expect(exists).To(BeFalse())
+			Expect(ctx.ExpiredProviders()).To(BeEmpty())
+		})
+
+		It("creates a target with a backend other than the recipe's backend", func() {
+
+			var (
+				tgt *target.Target
+			)
+
+			tgt, err = ctx.NewTargetWithBackend("basic", "aws", "gcs")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.Backend.Name()).To(Equal("gcs"))
+
+			tgt, err = ctx.NewTargetWithBackend("basic", "aws", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.Backend.Name()).To(Equal("s3"))
+
+			_, err = ctx.NewTargetWithBackend("basic", "aws", "unknown")
+			Expect(errors.Is(err, config.ErrBackendNotFound)).To(BeTrue())
+		})
+
+		It("creates a target with default recipe field values", func() {
+
+			var (
+				tgt   *target.Target
+				value *string
+			)
+
+			tgt, err = ctx.NewTargetWithDefaults("basic", "aws", map[string]string{
+				"test_input_1": "default value #1",
+				"unknown":      "ignored",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			value, err = tgt.Recipe.GetValue("test_input_1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("default value #1"))
+
+			_, err = ctx.NewTargetWithDefaults("unknown", "aws", nil)
+			Expect(errors.Is(err, config.ErrRecipeNotFound)).To(BeTrue())
+		})
+
+		It("returns the backends used by targets", func() {
+
+			inUse := ctx.BackendsInUse()
+			Expect(inUse).To(HaveLen(1))
+			Expect(inUse["s3"]).To(HaveLen(2))
+			Expect(inUse["s3"][0].Key()).To(Equal("basic/aws/aa/"))
+			Expect(inUse["s3"][1].Key()).To(Equal("basic/aws/cc/appbrickscookbook"))
+		})
+
+		It("groups targets by the account of their provider", func() {
+
+			tgt, err := ctx.NewTarget("basic", "google")
+			Expect(err).NotTo(HaveOccurred())
+			form, err := tgt.Provider.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("project", "project-a")
+			Expect(err).NotTo(HaveOccurred())
+			ctx.SaveTarget(tgt.Key(), tgt)
+
+			// aws access keys do not identify an account
+			form, err = ctx.TargetSet().GetTarget("basic/aws/aa/").Provider.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("access_key", "access-key-a")
+			Expect(err).NotTo(HaveOccurred())
+
+			byAccount := ctx.TargetsByAccount()
+			Expect(byAccount).To(HaveLen(2))
+			Expect(byAccount).ToNot(HaveKey("access-key-a"))
+			Expect(byAccount["project-a"]).To(HaveLen(1))
+			Expect(byAccount["project-a"][0].Key()).To(Equal("basic/google/"))
+			Expect(byAccount[""]).To(HaveLen(2))
+			Expect(byAccount[""][0].Key()).To(Equal("basic/aws/aa/"))
+			Expect(byAccount[""][1].Key()).To(Equal("basic/aws/cc/appbrickscookbook"))
+		})
+
+		It("returns targets whose state is saved to the same backend path", func() {
+
+			// the targets of the test data have the same backend
+			conflicts := ctx.BackendPathConflicts()
+			Expect(conflicts).To(HaveLen(1))
+			for _, targets := range conflicts {
+				Expect(targets).To(HaveLen(2))
+				Expect(targets[0].Key()).To(Equal("basic/aws/aa/"))
+				Expect(targets[1].Key()).To(Equal("basic/aws/cc/appbrickscookbook"))
+			}
+
+			// backend fields that do not locate the state
+			// such as credentials do not affect conflicts
+			form, err := ctx.TargetSet().GetTarget("basic/aws/aa/").Backend.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			for _, field := range form.InputFields() {
+				if field.Name() != "bucket" && field.Name() != "key" {
+					value := "other " + field.Name()
+					Expect(field.SetValue(&value)).To(Succeed())
+				}
+			}
+			conflicts = ctx.BackendPathConflicts()
+			Expect(conflicts).To(HaveLen(1))
+			for statePath, targets := range conflicts {
+				Expect(statePath).To(HavePrefix("s3:bucket="))
+				Expect(statePath).ToNot(ContainSubstring("other "))
+				Expect(targets).To(HaveLen(2))
+			}
+
+			err = form.SetFieldValue("key", "aa/terraform.tfstate")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctx.BackendPathConflicts()).To(BeEmpty())
+		})
+
+		It("migrates the backend of a target", func() {
+
+			var (
+				tgt *target.Target
+			)
+
+			ctx.TargetSet().SetHistoryDepth(2)
+			err = ctx.MigrateTargetBackend("basic/aws/aa/", "gcs")
+			Expect(err).NotTo(HaveOccurred())
+			tgt, err = ctx.GetTarget("basic/aws/aa/")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.Backend.Name()).To(Equal("gcs"))
+			Expect(tgt.PreviousBackend().Name()).To(Equal("s3"))
+			Expect(tgt.StateMigrationPending).To(BeTrue())
+			Expect(tgt.Provider.Name()).To(Equal("aws"))
+
+			err = ctx.MigrateTargetBackend("basic/aws/aa/", "gcs")
+			Expect(err).To(HaveOccurred())
+			err = ctx.MigrateTargetBackend("basic/aws/aa/", "unknown")
+			Expect(errors.Is(err, config.ErrBackendNotFound)).To(BeTrue())
+			err = ctx.MigrateTargetBackend("unknown", "gcs")
+			Expect(errors.Is(err, config.ErrTargetNotFound)).To(BeTrue())
+
+			// the target prior to the migration is kept in its history
+			history := ctx.TargetSet().TargetHistory("basic/aws/aa/")
+			Expect(history).To(HaveLen(1))
+			Expect(history[0].Backend.Name()).To(Equal("s3"))
+			Expect(history[0].StateMigrationPending).To(BeFalse())
+		})
+
+		It("clears the output of targets", func() {
+
+			var (
+				tgt *target.Target
+			)
+
+			for _, t := range ctx.TargetSet().GetTargets() {
+				t.Output = &map[string]terraform.Output{
+					"cb_node_description": {Value: "stale"},
+				}
+			}
+			ctx.TargetSet().SetHistoryDepth(2)
+			tgt, err = ctx.GetTarget("basic/aws/aa/")
+			Expect(err).NotTo(HaveOccurred())
+			updatedAt := tgt.UpdatedAt()
+
+			err = ctx.ClearTargetOutput("basic/aws/aa/")
+			Expect(err).NotTo(HaveOccurred())
+			cleared, err := ctx.GetTarget("basic/aws/aa/")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cleared.Output).To(BeNil())
+			Expect(cleared.UpdatedAt().After(updatedAt)).To(BeTrue())
+
+			// the target's input values are unchanged
+			cleared.Output = tgt.Output
+			Expect(cleared.Equal(tgt)).To(BeTrue())
+
+			err = ctx.ClearTargetOutput("unknown")
+			Expect(errors.Is(err, config.ErrTargetNotFound)).To(BeTrue())
+
+			Expect(ctx.ClearAllOutputs()).To(Equal(1))
+			Expect(ctx.ClearAllOutputs()).To(Equal(0))
+			Expect(ctx.TargetSet().GetTarget("basic/aws/cc/appbrickscookbook").Output).To(BeNil())
+
+			// the cleared outputs can be restored from the history
+			history := ctx.TargetSet().TargetHistory("basic/aws/aa/")
+			Expect(history).To(HaveLen(1))
+			Expect((*history[0].Output)["cb_node_description"].Value).To(Equal("stale"))
+			err = ctx.TargetSet().RollbackTarget("basic/aws/cc/appbrickscookbook", 0)
+			Expect(err).NotTo(HaveOccurred())
+			restored := ctx.TargetSet().GetTarget("basic/aws/cc/appbrickscookbook")
+			Expect((*restored.Output)["cb_node_description"].Value).To(Equal("stale"))
+		})
+
+		It("reports every element of a section that fails to serialize", func() {
+
+			err = ctx.CheckSections()
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, t := range ctx.TargetSet().GetTargets() {
+				t.Output = &map[string]terraform.Output{
+					"cb_node_description": {Value: make(chan int)},
+				}
+			}
+			err = ctx.CheckSections()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("2 errors occurred"))
+			Expect(err.Error()).To(ContainSubstring("section 'targets': target 'basic/aws/aa/': "))
+			Expect(err.Error()).To(ContainSubstring("section 'targets': target 'basic/aws/cc/appbrickscookbook': "))
+		})
+
+		It("builds a target", func() {
+
+			var (
+				tgt   *target.Target
+				value *string
+			)
+
+			tgt, err = config.NewTargetBuilder(ctx).
+				WithRecipe("basic", "aws").
+				WithProviderOverrides(map[string]string{"region": "eu-central-1"}).
+				WithBackend("s3").
+				WithTags(map[string]string{"env": "test"}).
+				Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.RecipeName).To(Equal("basic"))
+			Expect(tgt.RecipeIaas).To(Equal("aws"))
+			Expect(tgt.Backend.Name()).To(Equal("s3"))
+			Expect(tgt.Tags).To(Equal(map[string]string{"env": "test"}))
+			value, err = tgt.Provider.GetValue("region")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("eu-central-1"))
+
+			// the context's provider is not modified
+			cp, err := ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err = cp.GetValue("region")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("us-east-1"))
+			Expect(ctx.TargetSet().Count()).To(Equal(2))
+
+			// all problems are returned when the target is built
+			_, err = config.NewTargetBuilder(ctx).
+				WithBackend("").
+				WithTags(map[string]string{"": "test"}).
+				Build()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("3 errors occurred"))
+
+			_, err = config.NewTargetBuilder(ctx).
+				WithRecipe("basic", "aws").
+				WithProviderOverrides(map[string]string{"unknown1": "x", "unknown2": "y"}).
+				Build()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("provider 'aws' does not have a field named 'unknown1'"))
+			Expect(err.Error()).To(ContainSubstring("provider 'aws' does not have a field named 'unknown2'"))
+
+			_, err = config.NewTargetBuilder(ctx).
+				WithRecipe("basic", "unknown").
+				Build()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("adds the default tags of the provider to new targets", func() {
+
+			var (
+				tgt *target.Target
+			)
+
+			ctx.SetProviderDefaultTags("aws", map[string]string{"env": "prod", "team": "cloud"})
+
+			tgt, err = ctx.NewTarget("basic", "aws")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.Tags).To(Equal(map[string]string{"env": "prod", "team": "cloud"}))
+
+			// tags of the target override the defaults
+			tgt, err = config.NewTargetBuilder(ctx).
+				WithRecipe("basic", "aws").
+				WithTags(map[string]string{"env": "test"}).
+				Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.Tags).To(Equal(map[string]string{"env": "test", "team": "cloud"}))
+
+			tgt, err = ctx.NewTarget("basic", "google")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.Tags).To(BeEmpty())
+
+			ctx.SetProviderDefaultTags("aws", nil)
+			tgt, err = ctx.NewTarget("basic", "aws")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.Tags).To(BeEmpty())
+		})
+
+		It("validates that a target loads once saved", func() {
+
+			var (
+				tgt *target.Target
+			)
+
+			tgt, err = ctx.GetTarget("basic/aws/aa/")
+			Expect(err).NotTo(HaveOccurred())
+			updatedAt := tgt.UpdatedAt()
+			err = ctx.ValidateTarget(tgt)
+			Expect(err).NotTo(HaveOccurred())
+
+			// the validated target is not modified
+			Expect(tgt.UpdatedAt()).To(Equal(updatedAt))
+
+			// a target whose recipe cannot
+			// be found will not load
+			tgt.RecipeName = "unknown"
+			err = ctx.ValidateTarget(tgt)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("target 'unknown/aws/aa/' cannot be loaded once saved: "))
+
+			// the context's targets are not modified
+			Expect(ctx.TargetSet().Count()).To(Equal(2))
+			Expect(ctx.HasTarget("unknown/aws/aa/")).To(BeFalse())
+		})
+
+		It("does not clone a target to an existing deployment name", func() {
+
+			_, err = ctx.CloneTarget("basic/aws/aa/", "NONAME")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("a target with name 'NONAME' already exists"))
+
+			_, err = ctx.CloneTarget("unknown", "clone")
+			Expect(errors.Is(err, config.ErrTargetNotFound)).To(BeTrue())
+		})
+
+		It("refreshes the recipe of a target from the cookbook", func() {
+
+			var (
+				tgt, refreshed *target.Target
+				warnings       []string
+			)
+
+			tgt, err = ctx.GetTarget("basic/aws/cc/appbrickscookbook")
+			Expect(err).NotTo(HaveOccurred())
+			refreshed, warnings, err = ctx.RefreshTargetRecipe("basic/aws/cc/appbrickscookbook")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+
+			// the input values are carried over to the new recipe
+			Expect(refreshed.Recipe).ToNot(BeIdenticalTo(tgt.Recipe))
+			Expect(refreshed.Key()).To(Equal(tgt.Key()))
+			value, err := refreshed.Recipe.GetValue("test_input_2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("appbrickscookbook"))
+
+			// the refreshed target has not been applied
+			Expect(refreshed.CookbookTimestamp).To(Equal(tgt.CookbookTimestamp))
+
+			_, _, err = ctx.RefreshTargetRecipe("unknown")
+			Expect(errors.Is(err, config.ErrTargetNotFound)).To(BeTrue())
+		})
+
+		It("imports a target from the outputs of a deployment", func() {
+
+			var (
+				tgt *target.Target
+				r   cookbook.Recipe
+			)
+
+			// the recipe fixtures do not have a name variable
+			// so the basic aws recipe is copied with one added
+			recipesPath, err := test_data.NamedRecipeFixture(filepath.Join(workspacePath, "named"))
+			Expect(err).NotTo(HaveOccurred())
+			r, err = cookbook.NewRecipe(
+				test_data.NamedRecipeName, "aws",
+				filepath.Join(recipesPath, test_data.NamedRecipeName, "aws"),
+				"", "", filepath.Join(workspacePath, "run", "named"),
+				ctx.Cookbook().Timestamp(),
+			)
+			Expect(err).NotTo(HaveOccurred())
+			ctx.Cookbook().SetRecipe(r)
+
+			outputs := map[string]terraform.Output{
+				"test_output_1": {Value: "output 1"},
+				"test_output_2": {Value: "output 2"},
+				"unexpected":    {Value: "output 3"},
+			}
+			tgt, err = ctx.ImportTargetFromOutputs(test_data.NamedRecipeName, "aws", "imported", outputs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.DeploymentName()).To(Equal("imported"))
+			Expect(tgt.DeploymentStatus()).To(Equal(target.StatusDeployed))
+			Expect(*tgt.Output).To(Equal(outputs))
+			Expect(ctx.HasTarget(tgt.Key())).To(BeTrue())
+			Expect(ctx.TargetSet().Count()).To(Equal(3))
+
+			// a deployment cannot be imported more than once
+			_, err = ctx.ImportTargetFromOutputs(test_data.NamedRecipeName, "aws", "imported", outputs)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("a target with key '" + tgt.Key() + "' already exists"))
+
+			// all the outputs of the recipe are required
+			_, err = ctx.ImportTargetFromOutputs(test_data.NamedRecipeName, "aws", "incomplete",
+				map[string]terraform.Output{"test_output_1": {Value: "output 1"}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("'test_output_2'"))
+
+			// the deployment name must be part of the recipe
+			_, err = ctx.ImportTargetFromOutputs("basic", "aws", "imported", outputs)
+			Expect(err).To(HaveOccurred())
+			Expect(ctx.TargetSet().Count()).To(Equal(3))
+		})
+
+		It("exports and imports a target", func() {
+
+			var (
+				bundle strings.Builder
+				tgt    *target.Target
+				value  *string
+			)
+
+			err = ctx.ExportTarget("basic/aws/aa/", &bundle, false)
+			Expect(err).NotTo(HaveOccurred())
+			ctx.TargetSet().DeleteTarget("basic/aws/aa/")
+			Expect(ctx.HasTarget("basic/aws/aa/")).To(BeFalse())
+
+			tgt, err = ctx.ImportTarget(strings.NewReader(bundle.String()))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.Key()).To(Equal("basic/aws/aa/"))
+			Expect(ctx.HasTarget("basic/aws/aa/")).To(BeTrue())
+
+			value, err = tgt.Provider.GetValue("region")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("us-east-1"))
+
+			// export without credentials
+			bundle.Reset()
+			err = ctx.ExportTarget("basic/aws/cc/appbrickscookbook", &bundle, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bundle.String()).To(ContainSubstring(`"credentialsStripped":true`))
+			tgt, err = ctx.ImportTarget(strings.NewReader(bundle.String()))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.Key()).To(Equal("basic/aws/cc/appbrickscookbook"))
+
+			err = ctx.ExportTarget("unknown", &bundle, false)
+			Expect(errors.Is(err, config.ErrTargetNotFound)).To(BeTrue())
+		})
+
+		It("does not allow a read-only context to be modified", func() {
+
+			roCtx := config.ReadOnly(ctx)
+
+			cp, err := roCtx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cp.Name()).To(Equal("aws"))
+			Expect(roCtx.HasTarget("basic/aws/aa/")).To(BeTrue())
+			Expect(roCtx.TargetSet().Count()).To(Equal(2))
+
+			Expect(func() { roCtx.SaveCloudProvider(cp) }).To(Panic())
+			Expect(func() { roCtx.SaveTarget("basic/aws/aa/", tgt1) }).To(Panic())
+			_, err = roCtx.NewTarget("basic", "aws")
+			Expect(err).To(Equal(config.ErrReadOnly))
+			err = roCtx.Load(strings.NewReader(configDocument))
+			Expect(err).To(Equal(config.ErrReadOnly))
+
+			// target set returned is a copy
+			roCtx.TargetSet().DeleteTarget("basic/aws/aa/")
+			Expect(ctx.HasTarget("basic/aws/aa/")).To(BeTrue())
+
+			// saving the read-only context does not
+			// reset the modified state of the context
+			ctx.MarkAllDirty()
+			err = roCtx.Save(&strings.Builder{})
+			Expect(err).To(Equal(config.ErrReadOnly))
+			err = roCtx.SaveYAML(&strings.Builder{})
+			Expect(err).To(Equal(config.ErrReadOnly))
+			Expect(ctx.SaveIfDirty(&strings.Builder{})).To(BeTrue())
+
+			// cookbook returned is a copy
+			form, err := roCtx.Cookbook().GetRecipe("basic", "aws").InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("test_input_5", "read-only change")
+			Expect(err).NotTo(HaveOccurred())
+			value, err := ctx.Cookbook().GetRecipe("basic", "aws").GetValue("test_input_5")
+			Expect(err).NotTo(HaveOccurred())
+			if value != nil {
+				Expect(*value).ToNot(Equal("read-only change"))
+			}
+		})
+
+		It("resets the configuration context", func() {
+
+			cookbook := ctx.Cookbook()
+			err = ctx.Reset()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ctx.Cookbook()).To(BeIdenticalTo(cookbook))
+			Expect(ctx.TargetSet().Count()).To(Equal(0))
+			Expect(ctx.HasTarget("basic/aws/aa/")).To(BeFalse())
+
+			cp, err := ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err := cp.GetValue("access_key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(BeNil())
+
+			// context can be reloaded after it has been reset
+			err = ctx.Load(strings.NewReader(configDocument))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctx.TargetSet().Count()).To(Equal(2))
+		})
+
+		It("validates a configuration document", func() {
+			Expect(ctx.Validate()).To(BeEmpty())
+		})
+
+		It("edits config elements without modifying the main config", func() {
+
+			var (
+				cp  provider.CloudProvider
+				tgt *target.Target
+
+				recipe1, recipe2 cookbook.Recipe
+
+				form  forms.InputForm
+				value *string
+			)
+
+			// provider elements
+			form, err = cpAWS.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("access_key", "updated access_key")
+			Expect(err).NotTo(HaveOccurred())
+
+			// cloud provider key should remain unchanged in main config structure
+			cp, err = ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err = cp.GetValue("access_key")
+			Expect(*value).To(Equal("83BFAD5B-FEAC-4019-A645-3858847CB3ED"))
+
+			// updated provider key value should now be persisted to main config structure
+			ctx.SaveCloudProvider(cpAWS)
+
+			cp, err = ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err = cp.GetValue("access_key")
+			Expect(*value).To(Equal("updated access_key"))
+
+			// recipe elements
+			recipe1, err = ctx.GetCookbookRecipe("basic", "aws")
+			Expect(err).NotTo(HaveOccurred())
+
+			form, err = recipe1.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("test_input_6", "abcd666")
+			Expect(err).NotTo(HaveOccurred())
+
+			// recipe key should remain unchanged in main config structure
+			recipe2, err = ctx.GetCookbookRecipe("basic", "aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err = recipe2.GetValue("test_input_6")
+			Expect(*value).To(Equal("abcd66"))
+
+			// updated recipe key value should now be persisted to main config structure
+			ctx.SaveCookbookRecipe(recipe1)
+
+			recipe2, err = ctx.GetCookbookRecipe("basic", "aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err = recipe2.GetValue("test_input_6")
+			Expect(*value).To(Equal("abcd666"))
+
+			// target elements
+			form, err = tgt1.Recipe.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("test_input_7", "eu-central-1")
+			Expect(err).NotTo(HaveOccurred())
+
+			form, err = tgt1.Provider.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("region", "eu-central-1")
+			Expect(err).NotTo(HaveOccurred())
+
+			// updated target should now be persisted to main config structure
+			tgt, err = ctx.GetTarget("basic/aws/aa/")
+			Expect(err).NotTo(HaveOccurred())
+			value, err = tgt.Recipe.GetValue("test_input_7")
+			Expect(*value).To(Equal("us-east-1"))
+			value, err = tgt.Provider.GetValue("region")
+			Expect(*value).To(Equal("us-east-1"))
+
+			// updated target should now be persisted to main config structure
+			ctx.SaveTarget("basic/aws/aa/", tgt1)
+
+			tgt, err = ctx.GetTarget("basic/aws/aa/")
+			Expect(err).NotTo(HaveOccurred())
+			value, err = tgt.Recipe.GetValue("test_input_7")
+			Expect(*value).To(Equal("eu-central-1"))
+			value, err = tgt.Provider.GetValue("region")
+			Expect(*value).To(Equal("eu-central-1"))
+		})
+	})
+
+	Context("config elements that do not exist", func() {
+
+		It("returns errors that can be checked for", func() {
+
+			_, err = ctx.GetCloudProvider("unknown")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, config.ErrProviderNotFound)).To(BeTrue())
+			Expect(err.Error()).To(Equal("provider does not exist: iaas 'unknown'"))
+
+			err = ctx.TestProvider("unknown")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, config.ErrProviderNotFound)).To(BeTrue())
+
+			_, err = ctx.GetCloudBackend("unknown")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, config.ErrBackendNotFound)).To(BeTrue())
+			Expect(err.Error()).To(Equal("backend does not exist: type 'unknown'"))
+
+			_, err = ctx.GetCookbookRecipe("unknown", "aws")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, config.ErrRecipeNotFound)).To(BeTrue())
+			Expect(errors.Is(err, config.ErrProviderNotFound)).To(BeFalse())
+			Expect(err.Error()).To(Equal("recipe does not exist: 'unknown' for iaas 'aws'"))
+
+			_, err = ctx.GetTarget("unknown")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, config.ErrTargetNotFound)).To(BeTrue())
+			Expect(err.Error()).To(Equal("target does not exist: 'unknown'"))
+		})
+	})
+
+	Context("cloud config document load progress", func() {
+
+		It("reports the progress of each section as it is loaded", func() {
+
+			progress := make(map[string]int)
+			err = ctx.LoadWithProgress(
+				strings.NewReader(configDocument),
+				func(section string, count int) {
+					progress[section] = count
+				},
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(progress["providers"]).To(Equal(3))
+			Expect(progress["backends"]).To(Equal(3))
+			Expect(progress["recipes"]).To(Equal(len(ctx.Cookbook().RecipeList())))
+			Expect(progress["targets"]).To(Equal(2))
+		})
+	})
+
+	Context("cloud config document load cancellation", func() {
+
+		It("loads a configuration document with a context", func() {
+			err = ctx.LoadContext(context.Background(), strings.NewReader(configDocument))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctx.TargetSet().Count()).To(Equal(2))
+		})
+
+		It("does not load a configuration document if the context is cancelled", func() {
+
+			loadCtx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err = ctx.LoadContext(loadCtx, strings.NewReader(configDocument))
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			Expect(ctx.TargetSet().Count()).To(Equal(0))
+		})
+	})
+
+	Context("cloud config document with credentials in the environment", func() {
+
+		BeforeEach(func() {
+			os.Setenv("CB_TEST_AWS_ACCESS_KEY_ID", "env access_key")
+			ctx.SetCredentialEnvMapping("aws", map[string]string{
+				"CB_TEST_AWS_ACCESS_KEY_ID": "access_key",
+			})
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("CB_TEST_AWS_ACCESS_KEY_ID")
+		})
+
+		It("does not override credentials in the config", func() {
+
+			err = ctx.Load(strings.NewReader(configDocument))
+			Expect(err).NotTo(HaveOccurred())
+
+			cp, err := ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err := cp.GetValue("access_key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("83BFAD5B-FEAC-4019-A645-3858847CB3ED"))
+		})
+
+		It("overrides credentials in the config", func() {
+
+			ctx.SetCredentialEnvOverride(true)
+			err = ctx.Load(strings.NewReader(configDocument))
+			Expect(err).NotTo(HaveOccurred())
+
+			cp, err := ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err := cp.GetValue("access_key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("env access_key"))
+		})
+
+		It("does not save credentials read from the environment", func() {
+
+			var (
+				output strings.Builder
+			)
+
+			ctx.SetCredentialEnvOverride(true)
+			err = ctx.Load(strings.NewReader(configDocument))
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ctx.Save(&output)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.String()).ToNot(ContainSubstring("env access_key"))
+			Expect(output.String()).To(ContainSubstring("83BFAD5B-FEAC-4019-A645-3858847CB3ED"))
+
+			// the value read from the
+			// environment is still used
+			cp, err := ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err := cp.GetValue("access_key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("env access_key"))
+
+			// fields modified after they were set
+			// from the environment are saved
+			form, err := cp.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("access_key", "new access key")
+			Expect(err).NotTo(HaveOccurred())
+			ctx.SaveCloudProvider(cp)
+
+			output.Reset()
+			err = ctx.Save(&output)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.String()).To(ContainSubstring("new access key"))
+		})
+
+		It("exports provider credentials as environment variables", func() {
+
+			err = ctx.Load(strings.NewReader(configDocument))
+			Expect(err).NotTo(HaveOccurred())
+
+			cp, err := ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			form, err := cp.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("secret_key", "it's secret")
+			Expect(err).NotTo(HaveOccurred())
+			ctx.SaveCloudProvider(cp)
+
+			ctx.SetCredentialEnvMapping("aws", map[string]string{
+				"CB_TEST_AWS_ACCESS_KEY_ID":     "access_key",
+				"CB_TEST_AWS_SECRET_ACCESS_KEY": "secret_key",
+			})
+
+			err = ctx.ExportProviderEnv("aws", &outputBuffer, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outputBuffer.String()).To(ContainSubstring("export CB_TEST_AWS_ACCESS_KEY_ID='83BFAD5B-FEAC-4019-A645-3858847CB3ED'\n"))
+			Expect(outputBuffer.String()).To(ContainSubstring(`export CB_TEST_AWS_SECRET_ACCESS_KEY='it'\''s secret'` + "\n"))
+
+			outputBuffer.Reset()
+			err = ctx.ExportProviderEnv("aws", &outputBuffer, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outputBuffer.String()).To(ContainSubstring("export CB_TEST_AWS_ACCESS_KEY_ID='83BFAD5B-FEAC-4019-A645-3858847CB3ED'\n"))
+			Expect(outputBuffer.String()).To(ContainSubstring("export CB_TEST_AWS_SECRET_ACCESS_KEY='********'\n"))
+			Expect(outputBuffer.String()).NotTo(ContainSubstring("secret'"))
+
+			err = ctx.ExportProviderEnv("unknown", &outputBuffer, false)
+			Expect(errors.Is(err, config.ErrProviderNotFound)).To(BeTrue())
+		})
+
+		It("resolves referenced provider values when loading", func() {
+
+			var (
+				ctx2 config.Context
+
+				saved1, saved2 strings.Builder
+			)
+
+			err = ctx.Load(strings.NewReader(configDocument))
+			Expect(err).NotTo(HaveOccurred())
+			cp, err := ctx.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			form, err := cp.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("secret_key", "ref:vault/aws/secret_key")
+			Expect(err).NotTo(HaveOccurred())
+			ctx.SaveCloudProvider(cp)
+			err = ctx.Save(&saved1)
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx2, err = config.NewConfigContext(ctx.Cookbook())
+			Expect(err).NotTo(HaveOccurred())
+			ctx2.RegisterValueResolver(func(field, value string) (string, error) {
+				Expect(field).To(Equal("secret_key"))
+				Expect(value).To(Equal("ref:vault/aws/secret_key"))
+				return "resolved secret_key", nil
+			})
+			err = ctx2.Load(strings.NewReader(saved1.String()))
+			Expect(err).NotTo(HaveOccurred())
+
+			cp, err = ctx2.GetCloudProvider("aws")
+			Expect(err).NotTo(HaveOccurred())
+			value, err := cp.GetValue("secret_key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("resolved secret_key"))
+
+			// references are saved in place of the resolved values
+			err = ctx2.Save(&saved2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(saved2.String()).To(Equal(saved1.String()))
+
+			// modified values replace the reference
+			form, err = cp.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("secret_key", "updated secret_key")
+			Expect(err).NotTo(HaveOccurred())
+			ctx2.SaveCloudProvider(cp)
+			saved2.Reset()
+			err = ctx2.Save(&saved2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(saved2.String()).To(ContainSubstring("updated secret_key"))
+			Expect(saved2.String()).NotTo(ContainSubstring("ref:vault/aws/secret_key"))
+
+			ctx2.RegisterValueResolver(func(field, value string) (string, error) {
+				return "", fmt.Errorf("secret not found")
+			})
+			err = ctx2.Load(strings.NewReader(saved1.String()))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("secret not found"))
+		})
+	})
+
+	Context("cloud config document version", func() {
+
+		It("writes the config version", func() {
+
+			err = ctx.Save(&outputBuffer)
+			Expect(err).NotTo(HaveOccurred())
+
+			actualConfigData := make(map[string]interface{})
+			err = json.Unmarshal([]byte(outputBuffer.String()), &actualConfigData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actualConfigData["version"]).To(Equal(float64(config.ConfigVersion)))
+		})
+
+		It("returns the location of errors when loading a config", func() {
+
+			var (
+				loadErr *config.LoadError
+			)
+
+			input := `{"cloud":{"providers":{"xyz":{}}}}`
+			err = ctx.Load(strings.NewReader(input))
+			Expect(errors.As(err, &loadErr)).To(BeTrue())
+			Expect(loadErr.Section).To(Equal("providers"))
+			Expect(loadErr.Key).To(Equal("xyz"))
+			Expect(loadErr.Offset).To(BeNumerically(">", strings.Index(input, "xyz")))
+			Expect(loadErr.Offset).To(BeNumerically("<=", strings.Index(input, "{}")))
+			Expect(err.Error()).To(Equal(
+				fmt.Sprintf("error loading config section 'providers' key 'xyz' at offset %d: invalid cloud provider 'xyz'", loadErr.Offset),
+			))
+
+			err = ctx.Load(strings.NewReader(`{"cloud":{"unknown":{}}}`))
+			Expect(errors.As(err, &loadErr)).To(BeTrue())
+			Expect(loadErr.Section).To(BeEmpty())
+			Expect(loadErr.Key).To(Equal("unknown"))
+		})
+
+		It("fails to load a config with a newer version", func() {
+			err = ctx.Load(strings.NewReader(`{"version":9999,"cloud":{}}`))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(
+				fmt.Sprintf("config version 9999 is newer than the supported version %d", config.ConfigVersion),
+			))
+		})
+	})
+
+	Context("cloud config document section", func() {
+
+		It("loads only the targets section of a configuration document", func() {
+
+			var (
+				tgt *target.Target
+			)
+
+			err = ctx.LoadSection(strings.NewReader(configDocument), "targets")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ctx.HasTarget("basic/aws/aa/")).To(BeTrue())
+			tgt, err = ctx.GetTarget("basic/aws/cc/appbrickscookbook")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.RecipeName).To(Equal("basic"))
+			Expect(tgt.RecipeIaas).To(Equal("aws"))
+		})
+
+		It("loads the sections of a configuration document using its index", func() {
+
+			var (
+				ctx2 config.Context
+
+				saved1, saved2 strings.Builder
+			)
+
+			err = ctx.Save(&saved1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(saved1.String()).To(ContainSubstring(`"sectionIndex":`))
+			data := []byte(saved1.String())
+
+			ctx2, err = config.NewConfigContext(ctx.Cookbook())
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.LoadAt(bytes.NewReader(data), int64(len(data)))
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.Save(&saved2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(saved2.String()).To(Equal(saved1.String()))
+
+			ctx2, err = config.NewConfigContext(ctx.Cookbook())
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.LoadSectionAt(bytes.NewReader(data), int64(len(data)), "targets")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctx2.HasTarget("basic/aws/aa/")).To(BeTrue())
+			Expect(ctx2.HasTarget("basic/aws/cc/appbrickscookbook")).To(BeTrue())
+
+			// documents without an index are read sequentially
+			ctx2, err = config.NewConfigContext(ctx.Cookbook())
+			Expect(err).NotTo(HaveOccurred())
+			err = ctx2.LoadAt(strings.NewReader(configDocument), int64(len(configDocument)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctx2.HasTarget("basic/aws/aa/")).To(BeTrue())
+		})
+
+		It("fails to load an unknown section", func() {
+			err = ctx.LoadSection(strings.NewReader(configDocument), "unknown")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid config section 'unknown'"))
+		})
+	})
+})
+
+const configDocument = `
+{
+	"cloud": {
+		"providers": {
+			"aws": ` + cloud_test_data.AWSProviderConfig + `,
+			"azure": ` + cloud_test_data.AzureProviderConfig + `,
+			"google": ` + cloud_test_data.GoogleProviderConfig + `
+		},
+		"backends": {
+			"s3": ` + cloud_test_data.S3BackendConfig + `,
+			"azurerm": ` + cloud_test_data.AzureRMBackendConfig + `,
+			"gcs": ` + cloud_test_data.GCSBackendConfig + `
+		},
+		"recipes": ` + test_data.CookbookConfigDocument + `,
+		"targets": [
+			{
+				"recipeName": "basic",
+				"recipeIaas": "aws",
+				"recipe": {
+					"variables": ` + test_data.AWSBasicRecipeVariables1 + `
+				},
+				"provider": ` + cloud_test_data.AWSProviderConfig + `,
 				"backend": ` + cloud_test_data.S3BackendConfig + `
 			},
 			{
@@ -318,3 +1903,12 @@ const configDocument = `
 	}
 }
 `
+
+func containsProvider(providers []provider.CloudProvider, name string) bool {
+	for _, cp := range providers {
+		if cp.Name() == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// error returned when loading a config that has been
+// bound to a device other than the bound device
+var ErrDeviceMismatch = errors.New("config is bound to a different device")
+
+// binds the encrypted config to the device with the given
+// id when the config is next saved. the id is authenticated
+// with the encrypted context so the config can only be
+// loaded once the same id has been set. configs saved
+// without a binding can be loaded on any device. binding
+// requires the config to be encrypted with a passphrase.
+//
+// in: id - the id of the device or installation or an
+//          empty string to remove the binding
+func (cf *configFile) SetDeviceBinding(id string) {
+	cf.deviceID = id
+	cf.dirty = true
+}
+
+// returns the data that authenticates the device binding
+// of the encrypted context. the hex encoding of this data
+// is saved with the config settings.
+func deviceBindingData(id string) []byte {
+	hash := sha256.Sum256([]byte("cloud-builder device binding:" + id))
+	return hash[:]
+}
+
+// checks that the config has been bound to the device
+// set via SetDeviceBinding
+//
+// out: the associated data the context was encrypted
+//      with or nil if the config is not bound
+func (cf *configFile) loadDeviceBinding() ([]byte, error) {
+
+	saved := cf.GetString("deviceBinding")
+	if len(saved) == 0 {
+		return nil, nil
+	}
+	aad := deviceBindingData(cf.deviceID)
+	if len(cf.deviceID) == 0 || hex.EncodeToString(aad) != saved {
+		return nil, ErrDeviceMismatch
+	}
+	return aad, nil
+}
+
+// saves the device binding with the config settings
+//
+// in: streamEncryption - whether the context is encrypted
+//                        as a stream with the passphrase
+// out: the associated data to encrypt the context with
+//      or nil if the config is not bound
+func (cf *configFile) saveDeviceBinding(streamEncryption bool) ([]byte, error) {
+
+	if len(cf.deviceID) == 0 {
+		if cf.IsSet("deviceBinding") {
+			cf.Set("deviceBinding", nil)
+		}
+		return nil, nil
+	}
+	if !streamEncryption {
+		return nil, fmt.Errorf(
+			"config '%s' can only be bound to a device if it is encrypted with a passphrase only",
+			cf.storeName())
+	}
+	aad := deviceBindingData(cf.deviceID)
+	cf.Set("deviceBinding", hex.EncodeToString(aad))
+	return aad, nil
+}
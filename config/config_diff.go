@@ -0,0 +1,263 @@
+package config
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mevansam/gocloud/backend"
+	"github.com/mevansam/gocloud/provider"
+	"github.com/mevansam/goforms/config"
+	"github.com/mevansam/goforms/forms"
+
+	"github.com/appbricks/cloud-builder/target"
+)
+
+// Change types
+type ChangeType int
+
+const (
+	Added ChangeType = iota
+	Removed
+	Modified
+)
+
+// describes a change to a config element
+// between two configuration contexts
+type Change struct {
+	Type ChangeType
+
+	// the type of config element that changed
+	// i.e. 'provider', 'backend' or 'target'
+	Element string
+	// the name of the element. this is the iaas
+	// name for providers, backend type for
+	// backends and the key for targets.
+	Name string
+
+	// names of the fields that were modified. fields
+	// of target elements are qualified with the name
+	// of the target component i.e. 'provider/region'
+	Fields []string
+}
+
+// returns the differences between two configuration contexts.
+// providers and backends are compared by their input form
+// field values and targets are paired using their keys
+// in the target set of each context.
+//
+// in: a - the original configuration context
+// in: b - the updated configuration context
+// out: list of changes required to update a to b
+func Diff(a, b Context) ([]Change, error) {
+
+	var (
+		err    error
+		exists bool
+		fields []string
+
+		cpA, cpB provider.CloudProvider
+		cbA, cbB backend.CloudBackend
+		tgtA     *target.Target
+	)
+
+	changes := []Change{}
+
+	for _, cpB = range b.CloudProviderTemplates() {
+		if cpA, err = a.GetCloudProvider(cpB.Name()); err != nil {
+			changes = append(changes, Change{Type: Added, Element: "provider", Name: cpB.Name()})
+			continue
+		}
+		if fields, err = diffFields(cpA, cpB, ""); err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			changes = append(changes, Change{Type: Modified, Element: "provider", Name: cpB.Name(), Fields: fields})
+		}
+	}
+	for _, cpA = range a.CloudProviderTemplates() {
+		if _, err = b.GetCloudProvider(cpA.Name()); err != nil {
+			changes = append(changes, Change{Type: Removed, Element: "provider", Name: cpA.Name()})
+		}
+	}
+
+	for _, cbB = range b.CloudBackendTemplates() {
+		if cbA, err = a.GetCloudBackend(cbB.Name()); err != nil {
+			changes = append(changes, Change{Type: Added, Element: "backend", Name: cbB.Name()})
+			continue
+		}
+		if fields, err = diffFields(cbA, cbB, ""); err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			changes = append(changes, Change{Type: Modified, Element: "backend", Name: cbB.Name(), Fields: fields})
+		}
+	}
+	for _, cbA = range a.CloudBackendTemplates() {
+		if _, err = b.GetCloudBackend(cbA.Name()); err != nil {
+			changes = append(changes, Change{Type: Removed, Element: "backend", Name: cbA.Name()})
+		}
+	}
+
+	targetSetA, targetSetB := a.TargetSet(), b.TargetSet()
+	targetsA := make(map[string]*target.Target)
+	for _, tgtA = range targetSetA.GetTargets() {
+		targetsA[targetSetA.KeyOf(tgtA)] = tgtA
+	}
+	targetsB := targetSetB.GetTargets()
+	sort.Slice(targetsB, func(i, j int) bool {
+		return targetSetB.KeyOf(targetsB[i]) < targetSetB.KeyOf(targetsB[j])
+	})
+	for _, tgtB := range targetsB {
+		key := targetSetB.KeyOf(tgtB)
+		if tgtA, exists = targetsA[key]; !exists {
+			changes = append(changes, Change{Type: Added, Element: "target", Name: key})
+			continue
+		}
+		delete(targetsA, key)
+
+		if fields, err = diffTargets(tgtA, tgtB); err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			changes = append(changes, Change{Type: Modified, Element: "target", Name: key, Fields: fields})
+		}
+	}
+	removed := make([]string, 0, len(targetsA))
+	for key := range targetsA {
+		removed = append(removed, key)
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		changes = append(changes, Change{Type: Removed, Element: "target", Name: key})
+	}
+
+	return changes, nil
+}
+
+// returns a summary of the change
+// i.e. "~ provider aws (secret_key changed)"
+func (c Change) String() string {
+
+	var (
+		summary strings.Builder
+	)
+
+	switch c.Type {
+	case Added:
+		summary.WriteString("+ ")
+	case Removed:
+		summary.WriteString("- ")
+	case Modified:
+		summary.WriteString("~ ")
+	}
+	summary.WriteString(c.Element)
+	summary.WriteByte(' ')
+	summary.WriteString(c.Name)
+	if len(c.Fields) > 0 {
+		summary.WriteString(" (")
+		summary.WriteString(strings.Join(c.Fields, ", "))
+		summary.WriteString(" changed)")
+	}
+	return summary.String()
+}
+
+// returns the names of the recipe, provider
+// and backend fields that differ between
+// two targets. a backend that only one of
+// the targets has is reported as 'backend'.
+func diffTargets(a, b *target.Target) ([]string, error) {
+
+	var (
+		err error
+		ff  []string
+	)
+
+	fields := []string{}
+	if ff, err = diffFields(a.Recipe, b.Recipe, "recipe/"); err != nil {
+		return nil, err
+	}
+	fields = append(fields, ff...)
+	if ff, err = diffFields(a.Provider, b.Provider, "provider/"); err != nil {
+		return nil, err
+	}
+	fields = append(fields, ff...)
+	switch {
+	case a.Backend == nil && b.Backend == nil:
+	case a.Backend == nil || b.Backend == nil:
+		// the backend has been added or removed
+		fields = append(fields, "backend")
+	default:
+		if ff, err = diffFields(a.Backend, b.Backend, "backend/"); err != nil {
+			return nil, err
+		}
+		fields = append(fields, ff...)
+	}
+	return fields, nil
+}
+
+// returns the names of the input form fields
+// having different values in a and b
+func diffFields(a, b config.Configurable, prefix string) ([]string, error) {
+
+	var (
+		err error
+
+		valuesA, valuesB map[string]*string
+	)
+
+	// the field values of each configurable need to be
+	// read before the other's input form is retrieved
+	// as configurables of the same type may share the
+	// same input form
+	if valuesA, err = fieldValues(a); err != nil {
+		return nil, err
+	}
+	if valuesB, err = fieldValues(b); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(valuesA))
+	for name := range valuesA {
+		names = append(names, name)
+	}
+	for name := range valuesB {
+		if _, exists := valuesA[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	fields := []string{}
+	for _, name := range names {
+		valueA, valueB := valuesA[name], valuesB[name]
+		if (valueA == nil) != (valueB == nil) ||
+			(valueA != nil && *valueA != *valueB) {
+			fields = append(fields, prefix+name)
+		}
+	}
+	return fields, nil
+}
+
+// returns a copy of the input form field
+// values of the given configurable
+func fieldValues(c config.Configurable) (map[string]*string, error) {
+
+	var (
+		err  error
+		form forms.InputForm
+	)
+
+	if form, err = c.InputForm(); err != nil {
+		return nil, err
+	}
+	values := make(map[string]*string)
+	for _, field := range form.InputFields() {
+		if value := field.Value(); value != nil {
+			v := *value
+			values[field.Name()] = &v
+		} else {
+			values[field.Name()] = nil
+		}
+	}
+	return values, nil
+}
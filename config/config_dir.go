@@ -0,0 +1,535 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mevansam/goutils/crypto"
+	"github.com/mevansam/goutils/logger"
+
+	"github.com/appbricks/cloud-builder/cookbook"
+	"github.com/appbricks/cloud-builder/internal/jsonenc"
+)
+
+// configuration saved to a directory where the config
+// settings and each section of the config context are
+// saved to separate files. this allows configs to be
+// versioned without all changes conflicting with each
+// other. the directory contains:
+//
+//   config.yml           - the config settings
+//   providers.(json|enc) - the cloud providers
+//   providerExpiry.(json|enc)
+//                        - expiry of provider credentials
+//   backends.(json|enc)  - the cloud backends
+//   recipes.(json|enc)   - the cookbook recipes
+//   targets/             - a file for each target
+//
+// files with the '.enc' extension are encrypted
+// with the config passphrase. the sections are not
+// compressed and recipients are not supported. with
+// field level encryption all sections are saved as
+// '.json' files with only sensitive fields encrypted.
+type configDir struct {
+	*configFile
+
+	dir string
+}
+
+// layout of a serialized config context
+// used to split it in to separate files
+type dirContext struct {
+	Version int `json:"version"`
+
+	Cloud struct {
+		Providers      json.RawMessage   `json:"providers,omitempty"`
+		ProviderExpiry json.RawMessage   `json:"providerExpiry,omitempty"`
+		Backends       json.RawMessage   `json:"backends,omitempty"`
+		Recipes        json.RawMessage   `json:"recipes,omitempty"`
+		Targets        []json.RawMessage `json:"targets,omitempty"`
+	} `json:"cloud"`
+}
+
+const (
+	plainShardExt     = ".json"
+	encryptedShardExt = ".enc"
+)
+
+// initializes directory based configuration
+//
+// in: dir - the path of the config directory
+// in: cookbook - the embedded cookbook the config should be
+//                assciated with
+// in: passphrase - callback to get the passphrase that will be
+//                  used for encrytion of sensitive information
+// out: a Config instance containing the global
+//      configuration for CloudBuilder
+func InitDirConfig(
+	dir string,
+	cookbook *cookbook.Cookbook,
+	getPassphrase GetPassphrase,
+) (Config, error) {
+
+	var (
+		err error
+		cfg Config
+	)
+
+	if cfg, err = InitFileConfig(
+		filepath.Join(dir, "config.yml"),
+		cookbook,
+		getPassphrase,
+	); err != nil {
+		return nil, err
+	}
+	return &configDir{
+		configFile: cfg.(*configFile),
+		dir:        dir,
+	}, nil
+}
+
+func (cd *configDir) Load() error {
+
+	var (
+		err    error
+		unlock func()
+
+		dc        dirContext
+		data      []byte
+		decrypted string
+		files     []os.FileInfo
+		shard     json.RawMessage
+
+		crypt *crypto.Crypt
+	)
+
+	if unlock, err = cd.lock(false); err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = cd.refreshSettings(); err != nil {
+		return err
+	}
+	if !cd.IsSet("contextVersion") {
+		// context has not been saved
+		logger.TraceMessage("Config loaded from: %s", cd.dir)
+		return nil
+	}
+	dc.Version = cd.GetInt("contextVersion")
+
+	if dc.Cloud.Providers, err = cd.readShard(filepath.Join(cd.dir, "providers")); err != nil {
+		return err
+	}
+	if dc.Cloud.ProviderExpiry, err = cd.readShard(filepath.Join(cd.dir, "providerExpiry")); err != nil {
+		return err
+	}
+	if dc.Cloud.Backends, err = cd.readShard(filepath.Join(cd.dir, "backends")); err != nil {
+		return err
+	}
+	if dc.Cloud.Recipes, err = cd.readShard(filepath.Join(cd.dir, "recipes")); err != nil {
+		return err
+	}
+
+	targetsDir := filepath.Join(cd.dir, "targets")
+	if files, err = ioutil.ReadDir(targetsDir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, f := range files {
+		name := f.Name()
+		ext := filepath.Ext(name)
+		if f.IsDir() || (ext != plainShardExt && ext != encryptedShardExt) {
+			continue
+		}
+		if shard, err = cd.readShard(
+			filepath.Join(targetsDir, strings.TrimSuffix(name, ext)),
+		); err != nil {
+			return err
+		}
+		dc.Cloud.Targets = append(dc.Cloud.Targets, shard)
+	}
+
+	if data, err = jsonenc.Marshal(&dc); err != nil {
+		return err
+	}
+	if cd.GetBool("fieldEncryption") {
+		if len(cd.passphrase) == 0 {
+			return fmt.Errorf("config has encrypted fields but a passphrase has not been provided")
+		}
+		if crypt, err = crypto.NewCrypt(
+			cd.passphraseKey(cd.savedKDFParams(), cd.timestamp),
+		); err != nil {
+			return err
+		}
+		if decrypted, err = cd.decryptFields(string(data), crypt); err != nil {
+			return err
+		}
+		data = []byte(decrypted)
+	}
+	if err = cd.context.Load(bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	logger.TraceMessage("Config loaded from: %s", cd.dir)
+	return nil
+}
+
+func (cd *configDir) Save() error {
+
+	var (
+		err error
+
+		marshalledContext string
+		saved             func()
+	)
+
+	if marshalledContext, saved, err = cd.writeContext(false); err != nil {
+		return err
+	}
+	if err = cd.save(marshalledContext); err != nil {
+		return err
+	}
+	saved()
+	return nil
+}
+
+// saves the config directory only if the config settings
+// or the config context have been modified since the
+// config was last loaded or saved
+//
+// out: true if the config was written
+func (cd *configDir) SaveIfDirty() (bool, error) {
+
+	var (
+		err error
+
+		marshalledContext string
+		saved             func()
+	)
+
+	if marshalledContext, saved, err = cd.writeContext(!cd.dirty); err != nil || saved == nil {
+		return false, err
+	}
+	if err = cd.save(marshalledContext); err != nil {
+		return false, err
+	}
+	saved()
+	return true, nil
+}
+
+func (cd *configDir) RotatePassphrase(
+	oldGetPassphrase,
+	newGetPassphrase GetPassphrase,
+) error {
+	return cd.rotatePassphrase(oldGetPassphrase, newGetPassphrase, cd.Load, cd.Save)
+}
+
+func (cd *configDir) ExportPlain(w io.Writer) error {
+	return cd.exportPlain(w, cd.Load)
+}
+
+func (cd *configDir) ImportPlain(r io.Reader) error {
+	return cd.importPlain(r, cd.Save)
+}
+
+// saves the config directory once all sections of the
+// config context have been checked. none of the files
+// are written if any section cannot be serialized. if a
+// section's file cannot be written the files of the other
+// sections are still written and the returned error lists
+// every section that could not be written.
+func (cd *configDir) SaveAllSections() error {
+
+	if err := cd.context.CheckSections(); err != nil {
+		return err
+	}
+	return cd.Save()
+}
+
+// configs saved to a directory cannot be shared with
+// recipients as each file would require its own key
+func (cd *configDir) AddRecipient(pubKey string) (string, error) {
+	return "", fmt.Errorf("recipients are not supported by configs saved to a directory")
+}
+
+// splits the serialized context in to separate files
+// and saves them with the config settings. all files are
+// written even if some cannot be written.
+//
+// out: an error listing each file that could
+//      not be written or nil if all succeed
+func (cd *configDir) save(marshalledContext string) error {
+
+	var (
+		err, errs error
+		unlock    func()
+
+		dc    dirContext
+		crypt *crypto.Crypt
+		files []os.FileInfo
+	)
+
+	// each file would need to be bound to the
+	// device as the files are encrypted separately
+	if len(cd.deviceID) > 0 {
+		return fmt.Errorf("device binding is not supported by configs saved to a directory")
+	}
+	if cd.integrityCheck {
+		return fmt.Errorf("integrity checks are not supported by configs saved to a directory")
+	}
+
+	if unlock, err = cd.lock(true); err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = cd.checkNotModified(); err != nil {
+		return err
+	}
+
+	// file mod times are in seconds so retrieve
+	// timestamp as seconds and convert to nanos
+	// for use as the seed
+	now := time.Unix(time.Now().Local().Unix(), 0)
+
+	if len(cd.passphrase) > 0 {
+		if crypt, err = crypto.NewCrypt(
+			cd.passphraseKey(cd.kdfParams, now.UnixNano()),
+		); err != nil {
+			return err
+		}
+	}
+
+	// with field level encryption the sections are saved
+	// as plain json with only the sensitive fields encrypted
+	fieldEncryption := cd.fieldEncryption && crypt != nil
+	if fieldEncryption || cd.IsSet("fieldEncryption") {
+		cd.Set("fieldEncryption", fieldEncryption)
+	}
+	if fieldEncryption {
+		if marshalledContext, err = cd.encryptFields(marshalledContext, crypt); err != nil {
+			return err
+		}
+		crypt = nil
+	}
+
+	if err = json.Unmarshal([]byte(marshalledContext), &dc); err != nil {
+		return err
+	}
+
+	// targets are serialized in key order
+	// followed by any orphaned targets
+	ts := cd.context.TargetSet()
+	keys := []string{}
+	for _, t := range ts.GetTargets() {
+		keys = append(keys, ts.KeyOf(t))
+	}
+	sort.Strings(keys)
+	if len(keys)+len(ts.OrphanedTargets()) != len(dc.Cloud.Targets) {
+		return fmt.Errorf("the target set was modified while the config was being saved")
+	}
+
+	// the remaining shards are written if a shard cannot
+	// be written and the errors of all shards are returned
+	if err = cd.writeShard(filepath.Join(cd.dir, "providers"), dc.Cloud.Providers, crypt); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("section 'providers': %w", err))
+	}
+	if dc.Cloud.ProviderExpiry != nil {
+		if err = cd.writeShard(filepath.Join(cd.dir, "providerExpiry"), dc.Cloud.ProviderExpiry, crypt); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("section 'providerExpiry': %w", err))
+		}
+	} else if err = cd.removeShard(filepath.Join(cd.dir, "providerExpiry")); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("section 'providerExpiry': %w", err))
+	}
+	if err = cd.writeShard(filepath.Join(cd.dir, "backends"), dc.Cloud.Backends, crypt); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("section 'backends': %w", err))
+	}
+	if err = cd.writeShard(filepath.Join(cd.dir, "recipes"), dc.Cloud.Recipes, crypt); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("section 'recipes': %w", err))
+	}
+
+	targetsDir := filepath.Join(cd.dir, "targets")
+	if err = os.MkdirAll(targetsDir, 0700); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("section 'targets': %w", err))
+	} else {
+		// the files of targets that could not be
+		// written are kept so they are not lost
+		written := make(map[string]bool)
+		for i, data := range dc.Cloud.Targets {
+			var name string
+			if i < len(keys) {
+				name = url.PathEscape(keys[i])
+			} else {
+				name = fmt.Sprintf("~orphaned-%d", i-len(keys))
+			}
+			if err = cd.writeShard(filepath.Join(targetsDir, name), data, crypt); err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("section 'targets': target '%s': %w", name, err))
+			}
+			written[name] = true
+		}
+		// remove the files of deleted targets
+		if files, err = ioutil.ReadDir(targetsDir); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("section 'targets': %w", err))
+		}
+		for _, f := range files {
+			name := f.Name()
+			if !written[strings.TrimSuffix(name, filepath.Ext(name))] {
+				if err = os.Remove(filepath.Join(targetsDir, name)); err != nil {
+					errs = multierror.Append(errs, fmt.Errorf("section 'targets': %w", err))
+				}
+			}
+		}
+	}
+
+	// the settings are saved even if a shard failed as
+	// the shards written are encrypted with the new seed
+	cd.Set("contextVersion", dc.Version)
+	if err = cd.saveSettings(now); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	return errs
+}
+
+// reads the section of the context saved to the file with
+// the given path. the path's extension determines whether
+// the file needs to be decrypted.
+//
+// in: path - the path of the file without its extension
+// out: the serialized section or nil if it was not saved
+func (cd *configDir) readShard(path string) (json.RawMessage, error) {
+
+	var (
+		err error
+
+		data      []byte
+		decrypted string
+
+		crypt *crypto.Crypt
+	)
+
+	if data, err = ioutil.ReadFile(path + encryptedShardExt); err == nil {
+		if len(cd.passphrase) == 0 {
+			return nil, fmt.Errorf(
+				"config file '%s' is encrypted but a passphrase has not been provided",
+				path+encryptedShardExt)
+		}
+		if crypt, err = crypto.NewCrypt(
+			cd.passphraseKey(cd.savedKDFParams(), cd.timestamp),
+		); err != nil {
+			return nil, err
+		}
+		if decrypted, err = crypt.DecryptB64(string(data)); err != nil {
+			return nil, err
+		}
+		return json.RawMessage(decrypted), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if data, err = ioutil.ReadFile(path + plainShardExt); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return json.RawMessage(data), nil
+}
+
+// writes a section of the context to the file with the
+// given path. the section is encrypted if a crypt is
+// given otherwise it is written as indented json so
+// that changes to it are easy to review.
+//
+// in: path - the path of the file without its extension
+// in: data - the serialized section
+// in: crypt - the crypt to encrypt the section with
+func (cd *configDir) writeShard(path string, data json.RawMessage, crypt *crypto.Crypt) error {
+
+	var (
+		err error
+
+		encrypted string
+		output    bytes.Buffer
+	)
+
+	ext, staleExt := plainShardExt, encryptedShardExt
+	if crypt != nil {
+		ext, staleExt = encryptedShardExt, plainShardExt
+		if encrypted, err = crypt.EncryptB64(string(data)); err != nil {
+			return err
+		}
+		output.WriteString(encrypted)
+	} else {
+		if err = json.Indent(&output, data, "", "  "); err != nil {
+			return err
+		}
+		output.WriteByte('\n')
+	}
+
+	if err = writeFileAtomic(path+ext, output.Bytes()); err != nil {
+		return err
+	}
+	if err = os.Remove(path + staleExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removes the file of a section that is no
+// longer saved with the context
+//
+// in: path - the path of the file without its extension
+func (cd *configDir) removeShard(path string) error {
+
+	for _, ext := range []string{plainShardExt, encryptedShardExt} {
+		if err := os.Remove(path + ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes the given data to a temporary file and moves it
+// over the file with the given path once it has been
+// completely written
+func writeFileAtomic(path string, data []byte) error {
+
+	var (
+		err error
+
+		tmpFile *os.File
+	)
+
+	if tmpFile, err = ioutil.TempFile(
+		filepath.Dir(path),
+		"."+filepath.Base(path)+".*",
+	); err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mevansam/gocloud/provider"
+	"github.com/mevansam/goforms/forms"
+	"github.com/mevansam/goutils/logger"
+)
+
+// sets the environment variables from which credentials of
+// the given provider will be read when the config is loaded.
+// only provider fields that do not have a value are updated
+// unless environment overrides have been enabled. values read
+// from the environment are not saved with the config unless
+// the field is modified after it has been set.
+//
+// in: iaas - the name of the provider
+// in: mapping - map of environment variable names to the
+//               names of the provider fields to set
+func (cc *configContext) SetCredentialEnvMapping(iaas string, mapping map[string]string) {
+
+	if len(mapping) == 0 {
+		delete(cc.credentialEnv, iaas)
+		return
+	}
+	m := make(map[string]string)
+	for envVar, field := range mapping {
+		m[envVar] = field
+	}
+	cc.credentialEnv[iaas] = m
+}
+
+// sets whether credentials read from the environment
+// override the values of provider fields in the config
+func (cc *configContext) SetCredentialEnvOverride(override bool) {
+	cc.credentialEnvOverride = override
+}
+
+// a provider field value set from the environment
+type injectedCredential struct {
+	// the value of the field before it was set
+	original *string
+	value    string
+}
+
+// sets the fields of the given provider from the
+// environment variables mapped to its fields
+func (cc *configContext) injectCredentials(cloudProvider provider.CloudProvider) error {
+
+	var (
+		err    error
+		exists bool
+
+		envValue   string
+		fieldValue *string
+
+		form forms.InputForm
+	)
+
+	delete(cc.injectedCredentials, cloudProvider.Name())
+
+	mapping := cc.credentialEnv[cloudProvider.Name()]
+	if len(mapping) == 0 {
+		return nil
+	}
+	if form, err = cloudProvider.InputForm(); err != nil {
+		return err
+	}
+
+	// set fields in a consistent order in case more
+	// than one variable is mapped to the same field
+	envVars := make([]string, 0, len(mapping))
+	for envVar := range mapping {
+		envVars = append(envVars, envVar)
+	}
+	sort.Strings(envVars)
+
+	injected := make(map[string]injectedCredential)
+	for _, envVar := range envVars {
+		field := mapping[envVar]
+		if envValue, exists = os.LookupEnv(envVar); !exists || len(envValue) == 0 {
+			continue
+		}
+		if fieldValue, err = form.GetFieldValue(field); err != nil {
+			return err
+		}
+		if !cc.credentialEnvOverride && fieldValue != nil && len(*fieldValue) > 0 {
+			continue
+		}
+		if err = form.SetFieldValue(field, envValue); err != nil {
+			return err
+		}
+		// the value the field had when it was first
+		// set is restored when the config is saved
+		ic, exists := injected[field]
+		if !exists && fieldValue != nil {
+			original := *fieldValue
+			ic.original = &original
+		}
+		ic.value = envValue
+		injected[field] = ic
+
+		logger.TraceMessage(
+			"Set field '%s' of provider '%s' from environment variable '%s'.",
+			field, cloudProvider.Name(), envVar)
+	}
+	if len(injected) > 0 {
+		cc.injectedCredentials[cloudProvider.Name()] = injected
+	}
+	return nil
+}
+
+// writes a shell script that exports the field values of the
+// given provider as environment variables. fields are exported
+// to the variables mapped to them via SetCredentialEnvMapping
+// as well as the conventional variables of the field. fields
+// without a value are not exported.
+//
+// in: iaas - the name of the provider to export
+// in: w - the writer to write the script to
+// in: maskSensitive - if true the values of sensitive fields
+//                     are masked in the script
+func (cc *configContext) ExportProviderEnv(iaas string, w io.Writer, maskSensitive bool) error {
+
+	var (
+		err error
+
+		cloudProvider provider.CloudProvider
+		form          forms.InputForm
+		value         *string
+	)
+
+	if cloudProvider, err = cc.GetCloudProvider(iaas); err != nil {
+		return err
+	}
+	if form, err = cloudProvider.InputForm(); err != nil {
+		return err
+	}
+
+	// env vars mapped to each
+	// field keyed by field name
+	fieldEnvVars := make(map[string][]string)
+	for envVar, field := range cc.credentialEnv[iaas] {
+		fieldEnvVars[field] = append(fieldEnvVars[field], envVar)
+	}
+
+	exports := make(map[string]string)
+	for _, field := range form.InputFields() {
+		if value = field.Value(); value == nil || len(*value) == 0 {
+			continue
+		}
+		exportValue := *value
+		if maskSensitive && field.Sensitive() {
+			exportValue = "********"
+		}
+		for _, envVar := range append(fieldEnvVars[field.Name()], field.EnvVars()...) {
+			if _, exists := exports[envVar]; !exists {
+				exports[envVar] = exportValue
+			}
+		}
+	}
+
+	envVars := make([]string, 0, len(exports))
+	for envVar := range exports {
+		envVars = append(envVars, envVar)
+	}
+	sort.Strings(envVars)
+
+	for _, envVar := range envVars {
+		if _, err = fmt.Fprintf(w, "export %s=%s\n", envVar, shellQuote(exports[envVar])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quotes the given value so that it is
+// read literally by a posix shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
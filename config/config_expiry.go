@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// implemented by providers configured with temporary
+// credentials to report when the credentials expire
+type expiringProvider interface {
+	ExpiresAt() time.Time
+}
+
+// sets the time at which the credentials of the provider
+// for the given iaas expire. a zero time clears the expiry.
+//
+// in: iaas - the name of the provider
+// in: expiresAt - the time the provider's credentials expire
+func (cc *configContext) SetProviderExpiry(iaas string, expiresAt time.Time) error {
+
+	if err := cc.initTemplates(); err != nil {
+		return err
+	}
+	if _, exists := cc.cloudProviders()[iaas]; !exists {
+		return fmt.Errorf(
+			"%w: iaas '%s'",
+			ErrProviderNotFound, iaas)
+	}
+	if expiresAt.IsZero() {
+		delete(cc.providerExpiry, iaas)
+	} else {
+		cc.providerExpiry[iaas] = expiresAt.UTC()
+	}
+	cc.markDirty("providers")
+	return nil
+}
+
+// out: the time at which the credentials of the
+//      provider for the given iaas expire
+// out: false if the provider's credentials do
+//      not have an expiry
+func (cc *configContext) ProviderExpiresAt(iaas string) (time.Time, bool) {
+	expiresAt, exists := cc.providerExpiry[iaas]
+	return expiresAt, exists
+}
+
+// returns the names of the iaas' whose
+// provider credentials have expired
+//
+// out: sorted list of iaas names
+func (cc *configContext) ExpiredProviders() []string {
+
+	now := time.Now()
+	expired := []string{}
+	for iaas, expiresAt := range cc.providerExpiry {
+		if !now.Before(expiresAt) {
+			expired = append(expired, iaas)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
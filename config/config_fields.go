@@ -0,0 +1,299 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mevansam/goforms/config"
+	"github.com/mevansam/goutils/crypto"
+
+	"github.com/appbricks/cloud-builder/internal/jsonenc"
+)
+
+// prefix of the values of sensitive fields that
+// have been encrypted with field level encryption
+const encryptedFieldPrefix = "enc:"
+
+// sets whether only the sensitive fields of providers,
+// backends and recipes and the outputs of targets are
+// encrypted when the config is saved with a passphrase.
+// all other fields are saved as plain json so
+// that changes to them can be reviewed. the serialized
+// context is not compressed when field level encryption
+// is enabled. field level encryption is not used when
+// the config is shared with recipients.
+//
+// the sensitive fields are identified by the input forms
+// of the configured providers, backends and recipes and are
+// matched to the serialized fields having the same name. the
+// outputs of a target are encrypted as a whole as their
+// sensitivity is only known once they have been deployed.
+func (cf *configFile) SetFieldEncryption(enabled bool) {
+	cf.fieldEncryption = enabled
+	cf.dirty = true
+}
+
+// encrypts the values of the sensitive fields and
+// the target outputs in the serialized context
+func (cf *configFile) encryptFields(marshalledContext string, crypt *crypto.Crypt) (string, error) {
+
+	return cf.transformFields(marshalledContext,
+		func(value string) (string, error) {
+			if len(value) == 0 || strings.HasPrefix(value, encryptedFieldPrefix) {
+				return value, nil
+			}
+			encrypted, err := crypt.EncryptB64(value)
+			if err != nil {
+				return "", err
+			}
+			return encryptedFieldPrefix + encrypted, nil
+		},
+	)
+}
+
+// decrypts the values of the sensitive fields and
+// the target outputs in the serialized context
+func (cf *configFile) decryptFields(marshalledContext string, crypt *crypto.Crypt) (string, error) {
+
+	return cf.transformFields(marshalledContext,
+		func(value string) (string, error) {
+			if !strings.HasPrefix(value, encryptedFieldPrefix) {
+				return value, nil
+			}
+			return crypt.DecryptB64(strings.TrimPrefix(value, encryptedFieldPrefix))
+		},
+	)
+}
+
+// applies the given transform to the string values of
+// the sensitive fields of the providers, backends and
+// recipes of the serialized context and the context's
+// targets and to the serialized outputs of the targets
+func (cf *configFile) transformFields(
+	marshalledContext string,
+	transform func(value string) (string, error),
+) (string, error) {
+
+	var (
+		err error
+
+		root map[string]interface{}
+		data []byte
+	)
+
+	decoder := json.NewDecoder(strings.NewReader(marshalledContext))
+	decoder.UseNumber()
+	if err = decoder.Decode(&root); err != nil {
+		return "", err
+	}
+	cloud, _ := root["cloud"].(map[string]interface{})
+	if cloud == nil {
+		return marshalledContext, nil
+	}
+
+	providerFields, backendFields := map[string]bool{}, map[string]bool{}
+	recipeFields := map[string]map[string]bool{}
+	for _, cp := range cf.context.CloudProviderTemplates() {
+		if err = addSensitiveFields(cp, providerFields); err != nil {
+			return "", err
+		}
+	}
+	for _, cb := range cf.context.CloudBackendTemplates() {
+		if err = addSensitiveFields(cb, backendFields); err != nil {
+			return "", err
+		}
+	}
+
+	if cookbook := cf.context.Cookbook(); cookbook != nil {
+		for _, info := range cookbook.RecipeList() {
+			for _, iaas := range info.IaaSList {
+				fields := map[string]bool{}
+				if r := cookbook.GetRecipe(info.Name, iaas.Name()); r != nil {
+					if err = addSensitiveFields(r, fields); err != nil {
+						return "", err
+					}
+				}
+				recipeFields[info.Name+"/"+iaas.Name()] = fields
+			}
+		}
+	}
+
+	if providers, ok := cloud["providers"].(map[string]interface{}); ok {
+		for _, p := range providers {
+			if err = transformFieldValues(p, providerFields, transform); err != nil {
+				return "", err
+			}
+		}
+	}
+	if backends, ok := cloud["backends"].(map[string]interface{}); ok {
+		for _, b := range backends {
+			if err = transformFieldValues(b, backendFields, transform); err != nil {
+				return "", err
+			}
+		}
+	}
+	if recipes, ok := cloud["recipes"].([]interface{}); ok {
+		for _, r := range recipes {
+			if rcp, ok := r.(map[string]interface{}); ok {
+				name, _ := rcp["name"].(string)
+				iaasConfigs, _ := rcp["config"].(map[string]interface{})
+				for iaas, c := range iaasConfigs {
+					if err = transformVariableValues(c, recipeFields[name+"/"+iaas], transform); err != nil {
+						return "", err
+					}
+				}
+			}
+		}
+	}
+	if targets, ok := cloud["targets"].([]interface{}); ok {
+		for _, t := range targets {
+			if tgt, ok := t.(map[string]interface{}); ok {
+				name, _ := tgt["recipeName"].(string)
+				iaas, _ := tgt["recipeIaas"].(string)
+				if err = transformVariableValues(tgt["recipe"], recipeFields[name+"/"+iaas], transform); err != nil {
+					return "", err
+				}
+				if output, ok := tgt["output"]; ok && output != nil {
+					if tgt["output"], err = transformOutput(output, transform); err != nil {
+						return "", err
+					}
+				}
+				if err = transformFieldValues(tgt["provider"], providerFields, transform); err != nil {
+					return "", err
+				}
+				if err = transformFieldValues(tgt["backend"], backendFields, transform); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+
+	if data, err = jsonenc.Marshal(root); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// adds the names of the sensitive fields of
+// the given configurable to the given set
+func addSensitiveFields(c config.Configurable, fields map[string]bool) error {
+
+	form, err := c.InputForm()
+	if err != nil {
+		return err
+	}
+	for _, field := range form.InputFields() {
+		if field.Sensitive() {
+			fields[field.Name()] = true
+		}
+	}
+	return nil
+}
+
+// applies the given transform to the string values
+// of the given fields of a decoded json value
+func transformFieldValues(
+	value interface{},
+	fields map[string]bool,
+	transform func(value string) (string, error),
+) error {
+
+	var (
+		err error
+	)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, fieldValue := range v {
+			if s, ok := fieldValue.(string); ok && fields[name] {
+				if v[name], err = transform(s); err != nil {
+					return fmt.Errorf("unable to transform field '%s': %w", name, err)
+				}
+			} else if err = transformFieldValues(fieldValue, fields, transform); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if err = transformFieldValues(elem, fields, transform); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applies the given transform to the values of the
+// given variables of a serialized recipe
+func transformVariableValues(
+	value interface{},
+	fields map[string]bool,
+	transform func(value string) (string, error),
+) error {
+
+	var (
+		err error
+	)
+
+	r, _ := value.(map[string]interface{})
+	if r == nil || len(fields) == 0 {
+		return nil
+	}
+	variables, _ := r["variables"].([]interface{})
+	for _, v := range variables {
+		if variable, ok := v.(map[string]interface{}); ok {
+			name, _ := variable["name"].(string)
+			if s, ok := variable["value"].(string); ok && fields[name] {
+				if variable["value"], err = transform(s); err != nil {
+					return fmt.Errorf("unable to transform variable '%s': %w", name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// applies the given transform to the serialized outputs
+// of a target. the outputs are transformed as a whole
+// and saved as a string when they are encrypted.
+func transformOutput(
+	output interface{},
+	transform func(value string) (string, error),
+) (interface{}, error) {
+
+	var (
+		err error
+
+		data        []byte
+		transformed string
+		decoded     interface{}
+	)
+
+	if s, ok := output.(string); ok {
+		if transformed, err = transform(s); err != nil {
+			return nil, fmt.Errorf("unable to transform target output: %w", err)
+		}
+		if transformed == s {
+			return s, nil
+		}
+		decoder := json.NewDecoder(strings.NewReader(transformed))
+		decoder.UseNumber()
+		if err = decoder.Decode(&decoded); err != nil {
+			return nil, err
+		}
+		return decoded, nil
+	}
+
+	if data, err = jsonenc.Marshal(output); err != nil {
+		return nil, err
+	}
+	if transformed, err = transform(string(data)); err != nil {
+		return nil, fmt.Errorf("unable to transform target output: %w", err)
+	}
+	if !strings.HasPrefix(transformed, encryptedFieldPrefix) {
+		// outputs that were not encrypted are left as is
+		return output, nil
+	}
+	return transformed, nil
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/base64"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -31,6 +34,12 @@ type configFile struct {
 
 	keyEncryptPassphrase string
 
+	// the store the config settings are saved to
+	// and the type the settings are serialized as
+	store      Store
+	configType string
+
+	// the path of file configs
 	path      string
 	timestamp int64
 
@@ -38,6 +47,38 @@ type configFile struct {
 	keyTimeout int64
 	passphrase string
 
+	// recipients of a shared config and the
+	// private key used to unlock the config
+	recipients map[string]*recipient
+	identity   *[32]byte
+
+	// true if the config settings have been
+	// modified since the config was last saved
+	dirty bool
+
+	// true if the serialized context should be
+	// compressed before it is encrypted
+	compress bool
+
+	// true if only the sensitive fields of the
+	// serialized context should be encrypted
+	fieldEncryption bool
+
+	// true if a checksum of the serialized context
+	// should be saved with unencrypted configs
+	integrityCheck bool
+
+	// parameters used to derive the encryption
+	// key from the passphrase when saving
+	kdfParams KDFParams
+
+	// time to wait for the config file lock
+	lockTimeout time.Duration
+
+	// the id of the device the config
+	// is bound to when it is saved
+	deviceID string
+
 	context Context
 }
 
@@ -59,9 +100,48 @@ func InitFileConfig(
 	var (
 		err error
 
-		absPath  string
-		fileInfo os.FileInfo
-		v        interface{}
+		absPath string
+		format  Format
+	)
+
+	if absPath, err = filepath.Abs(path); err != nil {
+		return nil, err
+	}
+	if format, err = DetectFormat(absPath); err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatDir:
+		return InitDirConfig(path, cookbook, getPassphrase)
+	case FormatJSON:
+		return initConfig(NewFileStore(absPath), "json", path, cookbook, getPassphrase)
+	default:
+		return initConfig(NewFileStore(absPath), "yaml", path, cookbook, getPassphrase)
+	}
+}
+
+// initializes configuration saved to the given store
+//
+// in: store - the store the config is saved to
+// in: configType - the type the settings are serialized as
+// in: path - the path of file configs
+// in: cookbook - the embedded cookbook the config should be
+//                assciated with
+// in: passphrase - callback to get the passphrase that will be
+//                  used for encrytion of sensitive information
+// out: the initialized config
+func initConfig(
+	store Store,
+	configType string,
+	path string,
+	cookbook *cookbook.Cookbook,
+	getPassphrase GetPassphrase,
+) (Config, error) {
+
+	var (
+		err error
+
+		v interface{}
 
 		crypt *crypto.Crypt
 	)
@@ -69,7 +149,11 @@ func InitFileConfig(
 	config := &configFile{
 		Viper: *viper.New(),
 
-		path: path,
+		store:      store,
+		configType: configType,
+		path:       path,
+
+		lockTimeout: defaultLockTimeout,
 	}
 
 	// initialize cookbook configuration context
@@ -77,35 +161,9 @@ func InitFileConfig(
 		return nil, err
 	}
 
-	// initialize and load viper config file
-	if absPath, err = filepath.Abs(path); err != nil {
-		return nil, err
-	}
-	configDir := filepath.Dir(absPath)
-	configFileName := filepath.Base(absPath)
-	configFileExt := filepath.Ext(absPath)
-	configName := configFileName[:len(configFileName)-len(configFileExt)]
-
-	config.SetConfigType(configFileExt[1:])
-	config.SetConfigName(configName)
-	config.AddConfigPath(configDir)
-
-	config.SetDefault("initialized", false)
-	config.SetDefault("keyTimeout", -1)
-
-	if err = config.ReadInConfig(); err != nil {
-
-		if err = os.MkdirAll(configDir, os.ModePerm); err != nil {
-			return nil, err
-		}
-		if err = config.WriteConfigAs(absPath); err != nil {
-			return nil, err
-		}
-		logger.TraceMessage(
-			"Creating empty config file: %s",
-			absPath)
-	}
-	if err = config.ReadInConfig(); err != nil {
+	// initialize and load viper config
+	config.initSettings()
+	if err = config.readSettings(); err != nil {
 		return nil, err
 	}
 	config.AutomaticEnv()
@@ -115,20 +173,9 @@ func InitFileConfig(
 		"Passphrase used to encrypt saved keys is '%s'.",
 		config.keyEncryptPassphrase)
 
-	// the last modification time of the configuration
-	// file is used as the seed for encryption keys
-	if fileInfo, err = os.Stat(config.path); err != nil {
+	if err = config.loadSettings(); err != nil {
 		return nil, err
 	}
-	configFileModeTime := fileInfo.ModTime()
-	logger.TraceMessage(
-		"Config path '%s' with timestamp of '%s'.",
-		config.path, configFileModeTime.String())
-
-	config.timestamp = configFileModeTime.UnixNano()
-
-	// retrieve key expiration
-	config.keyTimeout = config.GetInt64("keyTimeout")
 
 	// retrieve saved passphrase from config file if it has not expired
 	v = config.Get("key")
@@ -155,29 +202,86 @@ func InitFileConfig(
 		config.passphrase = getPassphrase()
 	}
 
-	logger.DebugMessage("Using config file: %s", config.ConfigFileUsed())
+	logger.DebugMessage("Using config: %s", config.storeName())
 	return config, nil
 }
 
 func (cf *configFile) Load() error {
 
 	var (
-		err error
+		err    error
+		unlock func()
 
 		decryptedContext string
 		encodedContext   []byte
 		contextReader    io.Reader
+		deviceBinding    []byte
 
 		crypt *crypto.Crypt
 	)
 
+	if unlock, err = cf.lock(false); err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the settings are re-read while the config is
+	// locked as they may have been saved by another
+	// process since the config was initialized
+	if err = cf.refreshSettings(); err != nil {
+		return err
+	}
+	if deviceBinding, err = cf.loadDeviceBinding(); err != nil {
+		return err
+	}
+
+	// the context is verified before it is
+	// decrypted or parsed if it has a checksum
+	if err = cf.verifyIntegrity(); err != nil {
+		return err
+	}
+
 	// load config context
 	contextData := cf.Get("context")
 	if contextData != nil {
 
-		if len(cf.passphrase) > 0 {
+		if len(cf.GetStringMap("recipients")) > 0 {
+			if decryptedContext, err = cf.decryptForRecipients(contextData.(string)); err != nil {
+				return err
+			}
+			logger.TraceMessage("Loading serialized context: %s", decryptedContext)
+			contextReader = strings.NewReader(decryptedContext)
+
+		} else if cf.GetBool("fieldEncryption") {
+			if len(cf.passphrase) == 0 {
+				return fmt.Errorf("config has encrypted fields but a passphrase has not been provided")
+			}
 			if crypt, err = crypto.NewCrypt(
-				crypto.KeyFromPassphrase(cf.passphrase, cf.timestamp),
+				cf.passphraseKey(cf.savedKDFParams(), cf.timestamp),
+			); err != nil {
+				return err
+			}
+			if decryptedContext, err = cf.decryptFields(contextData.(string), crypt); err != nil {
+				return err
+			}
+			contextReader = strings.NewReader(decryptedContext)
+
+		} else if cf.GetBool("streamEncryption") {
+			if len(cf.passphrase) == 0 {
+				return fmt.Errorf("config is encrypted but a passphrase has not been provided")
+			}
+			// the context is decrypted as it is loaded
+			if contextReader, err = decryptStream(
+				cf.passphraseKey(cf.savedKDFParams(), cf.timestamp),
+				deviceBinding,
+				contextData.(string),
+			); err != nil {
+				return err
+			}
+
+		} else if len(cf.passphrase) > 0 {
+			if crypt, err = crypto.NewCrypt(
+				cf.passphraseKey(cf.savedKDFParams(), cf.timestamp),
 			); err != nil {
 				return err
 			}
@@ -193,12 +297,15 @@ func (cf *configFile) Load() error {
 			}
 			contextReader = bytes.NewReader(encodedContext)
 		}
+		if contextReader, err = decompressContext(contextReader); err != nil {
+			return err
+		}
 		if err = cf.context.Load(contextReader); err != nil {
 			return err
 		}
 	}
 
-	logger.TraceMessage("Config loaded from: %s", cf.path)
+	logger.TraceMessage("Config loaded from: %s", cf.storeName())
 	return nil
 }
 
@@ -207,86 +314,420 @@ func (cf *configFile) Save() error {
 	var (
 		err error
 
-		contextOutput     strings.Builder
 		marshalledContext string
-		encryptedContext  string
-		key               string
+		saved             func()
+	)
+
+	// save config context
+	if marshalledContext, saved, err = cf.writeContext(false); err != nil {
+		return err
+	}
+	if err = cf.save(marshalledContext); err != nil {
+		return err
+	}
+	saved()
+	return nil
+}
+
+// saves the config file only if the config settings or
+// the config context have been modified since the config
+// was last loaded or saved
+//
+// out: true if the config file was written
+func (cf *configFile) SaveIfDirty() (bool, error) {
+
+	var (
+		err error
+
+		marshalledContext string
+		saved             func()
+	)
+
+	if marshalledContext, saved, err = cf.writeContext(!cf.dirty); err != nil || saved == nil {
+		return false, err
+	}
+	if err = cf.save(marshalledContext); err != nil {
+		return false, err
+	}
+	saved()
+	return true, nil
+}
+
+// implemented by contexts that can be serialized
+// without clearing their modified state
+type contextWriter interface {
+	write(output io.Writer) error
+	dirtyState() (uint64, bool)
+	markSaved(generation uint64)
+}
+
+// serializes the config context without clearing its
+// modified state so that changes are not lost if the
+// serialized context cannot be written to the store
+//
+// in: ifDirty - only serialize the context if it has
+//               been modified
+// out: the serialized context
+// out: function that marks the context as saved once
+//      it has been written or nil if it was not modified
+func (cf *configFile) writeContext(ifDirty bool) (string, func(), error) {
+
+	var (
+		err error
+
+		contextOutput strings.Builder
+	)
+
+	cw, ok := cf.context.(contextWriter)
+	if !ok {
+		// the context is marked as saved as it is serialized
+		saved := true
+		if ifDirty {
+			saved, err = cf.context.SaveIfDirty(&contextOutput)
+		} else {
+			err = cf.context.Save(&contextOutput)
+		}
+		if err != nil || !saved {
+			return "", nil, err
+		}
+		return contextOutput.String(), func() {}, nil
+	}
+
+	generation, dirty := cw.dirtyState()
+	if ifDirty && !dirty {
+		return "", nil, nil
+	}
+	if err = cw.write(&contextOutput); err != nil {
+		return "", nil, err
+	}
+	return contextOutput.String(), func() { cw.markSaved(generation) }, nil
+}
+
+// saves the config file once all sections of the config
+// context have been checked. unlike Save which returns the
+// first error encountered the returned error lists every
+// element of each section that cannot be serialized. the
+// config file is not written if any section fails.
+func (cf *configFile) SaveAllSections() error {
+
+	if err := cf.context.CheckSections(); err != nil {
+		return err
+	}
+	return cf.Save()
+}
+
+// encrypts the serialized context and writes
+// it to the config file with the config settings
+func (cf *configFile) save(marshalledContext string) error {
+
+	var (
+		err    error
+		unlock func()
+
+		encryptedContext string
+		deviceBinding    []byte
 
 		crypt *crypto.Crypt
 	)
 
+	if unlock, err = cf.lock(true); err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = cf.checkNotModified(); err != nil {
+		return err
+	}
+
 	// file mod times are in seconds so retrieve
 	// timestamp as seconds and convert to nanos
 	// for use as the seed
 	now := time.Unix(time.Now().Local().Unix(), 0)
 	timestamp := now.UnixNano()
 
-	// save config context
-	if err = cf.context.Save(&contextOutput); err != nil {
+	logger.TraceMessage("Saving serialized context: %s", marshalledContext)
+
+	// the context is saved as plain json with only the sensitive
+	// fields encrypted if field level encryption is enabled
+	fieldEncryption := cf.fieldEncryption && len(cf.recipients) == 0 && len(cf.passphrase) > 0
+	if fieldEncryption || cf.IsSet("fieldEncryption") {
+		cf.Set("fieldEncryption", fieldEncryption)
+	}
+	// contexts encrypted with only the passphrase are
+	// encrypted as a stream so they can be decrypted
+	// as they are loaded
+	streamEncryption := len(cf.recipients) == 0 && len(cf.passphrase) > 0 && !fieldEncryption
+	if streamEncryption || cf.IsSet("streamEncryption") {
+		cf.Set("streamEncryption", streamEncryption)
+	}
+	if deviceBinding, err = cf.saveDeviceBinding(streamEncryption); err != nil {
 		return err
 	}
-	marshalledContext = contextOutput.String()
-	logger.TraceMessage("Saving serialized context: %s", marshalledContext)
 
-	if len(cf.passphrase) > 0 {
-		// encrypt config context
-		if crypt, err = crypto.NewCrypt(
-			crypto.KeyFromPassphrase(cf.passphrase, timestamp),
-		); err != nil {
+	if cf.compress && !fieldEncryption {
+		if marshalledContext, err = compressContext(marshalledContext); err != nil {
 			return err
 		}
-		if encryptedContext, err = crypt.EncryptB64(marshalledContext); err != nil {
-			return err
-		}
-		cf.Set("context", encryptedContext)
+	}
+	if cf.compress || cf.IsSet("compression") {
+		cf.Set("compression", cf.compress)
+	}
 
-		// if the key timeout is set then save the encrypted passphrase. this
-		// key will expire if the config file is not l
-		if cf.keyTimeout > 0 {
+	if len(cf.recipients) == 0 && cf.IsSet("recipients") {
+		// config is no longer shared
+		cf.Set("recipients", nil)
+		cf.Set("dataKey", nil)
+	}
 
+	if len(cf.recipients) > 0 || len(cf.passphrase) > 0 {
+		// encrypt config context
+		if len(cf.recipients) > 0 {
+			if encryptedContext, err = cf.encryptForRecipients(marshalledContext, timestamp); err != nil {
+				return err
+			}
+		} else if fieldEncryption {
 			if crypt, err = crypto.NewCrypt(
-				crypto.KeyFromPassphrase(
-					cf.keyEncryptPassphrase,
-					timestamp,
-				),
+				cf.passphraseKey(cf.kdfParams, timestamp),
 			); err != nil {
 				return err
 			}
-			if key, err = crypt.EncryptB64(cf.passphrase); err != nil {
+			if encryptedContext, err = cf.encryptFields(marshalledContext, crypt); err != nil {
 				return err
 			}
-			cf.Set("key", key)
-
 		} else {
-			cf.Set("key", nil)
+			if encryptedContext, err = encryptStream(
+				cf.passphraseKey(cf.kdfParams, timestamp),
+				deviceBinding,
+				marshalledContext,
+			); err != nil {
+				return err
+			}
 		}
+		cf.Set("context", encryptedContext)
 
 	} else {
 		cf.Set("context", base64.URLEncoding.EncodeToString([]byte(marshalledContext)))
 	}
+	cf.saveIntegrity(cf.GetString("context"), len(cf.recipients) > 0 || len(cf.passphrase) > 0)
+
+	return cf.saveSettings(now)
+}
+
+// sets the type and defaults of the config settings
+func (cf *configFile) initSettings() {
+	cf.SetConfigType(cf.configType)
+	cf.SetDefault("initialized", false)
+	cf.SetDefault("keyTimeout", -1)
+}
+
+// retrieves the options the config is saved
+// with from the config settings read from
+// the config's store
+func (cf *configFile) loadSettings() error {
+
+	var (
+		err error
+	)
+
+	// the time the config was last saved is
+	// used as the seed for encryption keys
+	if cf.timestamp, err = cf.storeTimestamp(); err != nil {
+		return err
+	}
+	logger.TraceMessage(
+		"Config '%s' with timestamp of '%s'.",
+		cf.storeName(), time.Unix(0, cf.timestamp).String())
+
+	// retrieve key expiration
+	cf.keyTimeout = cf.GetInt64("keyTimeout")
+
+	// retrieve whether context should be compressed
+	cf.compress = cf.GetBool("compression")
+
+	// retrieve whether only sensitive fields should be encrypted
+	cf.fieldEncryption = cf.GetBool("fieldEncryption")
+
+	// retrieve whether the context's integrity should be checked
+	cf.integrityCheck = cf.IsSet("integrity")
+
+	// retrieve the key derivation parameters
+	cf.kdfParams = cf.savedKDFParams()
+
+	// retrieve recipients of a shared config
+	return cf.loadRecipients()
+}
+
+// writes the config settings to the config file. the
+// modification time of the file is set to the given
+// time which is the seed for the encryption keys.
+func (cf *configFile) saveSettings(now time.Time) error {
+
+	var (
+		err error
+		key string
+
+		crypt *crypto.Crypt
+	)
+	timestamp := now.UnixNano()
+
+	cf.saveKDFParams()
+
+	// if the key timeout is set then save the encrypted passphrase. this
+	// key will expire if the config file is not l
+	if len(cf.passphrase) > 0 && cf.keyTimeout > 0 {
+
+		if crypt, err = crypto.NewCrypt(
+			crypto.KeyFromPassphrase(
+				cf.keyEncryptPassphrase,
+				timestamp,
+			),
+		); err != nil {
+			return err
+		}
+		if key, err = crypt.EncryptB64(cf.passphrase); err != nil {
+			return err
+		}
+		cf.Set("key", key)
+
+	} else {
+		cf.Set("key", nil)
+	}
 
 	cf.Set("keyTimeout", cf.keyTimeout)
 
-	// save config file
-	if err = cf.WriteConfig(); err != nil {
+	// stores that do not record when they were
+	// written save the timestamp with the config
+	store, timestamped := cf.store.(timestampedStore)
+	if !timestamped {
+		cf.Set("timestamp", timestamp)
+	}
+
+	// the sequence is compared with the saved
+	// sequence to detect stale writes
+	sequence := cf.GetInt64("sequence")
+	cf.Set("sequence", sequence+1)
+
+	// save config settings
+	if err = cf.writeSettings(); err != nil {
+		cf.Set("sequence", sequence)
 		return err
 	}
 
 	// set config file modification time to timestamp
-	if err = os.Chtimes(cf.path, now, now); err != nil {
+	if timestamped {
+		if err = store.SetTimestamp(now); err != nil {
+			return err
+		}
+	}
+	cf.timestamp = timestamp
+	cf.dirty = false
+
+	logger.TraceMessage("Config saved to: %s (seed time %s)", cf.storeName(), now.String())
+	return nil
+}
+
+// re-encrypts the config with a new passphrase. the config
+// is first loaded using the old passphrase and is only saved
+// with the new passphrase if it could be decrypted.
+//
+// in: oldGetPassphrase - callback to get the current passphrase
+// in: newGetPassphrase - callback to get the new passphrase
+func (cf *configFile) RotatePassphrase(
+	oldGetPassphrase,
+	newGetPassphrase GetPassphrase,
+) error {
+	return cf.rotatePassphrase(oldGetPassphrase, newGetPassphrase, cf.Load, cf.Save)
+}
+
+// re-encrypts the config with a new passphrase
+// using the given functions to load and save
+// the config
+func (cf *configFile) rotatePassphrase(
+	oldGetPassphrase,
+	newGetPassphrase GetPassphrase,
+	load, save func() error,
+) error {
+
+	var (
+		err error
+	)
+
+	passphrase := cf.passphrase
+	keyTimeout := cf.keyTimeout
+
+	cf.passphrase = oldGetPassphrase()
+	if err = load(); err != nil {
+		cf.passphrase = passphrase
 		return err
 	}
 
-	logger.TraceMessage("Config saved to: %s (seed time %s)", cf.path, now.String())
+	newPassphrase := newGetPassphrase()
+	cf.SetPassphrase(newPassphrase)
+	if len(newPassphrase) > 0 && keyTimeout > 0 {
+		// retain any saved key timeout
+		cf.keyTimeout = keyTimeout
+	}
+
+	if err = save(); err != nil {
+		cf.passphrase = passphrase
+		cf.keyTimeout = keyTimeout
+		return err
+	}
 	return nil
 }
 
+// loads the config and writes its context unencrypted to
+// the given stream. the output can be restored to a config
+// with ImportPlain.
+//
+// in: w - the stream to write the config context to
+func (cf *configFile) ExportPlain(w io.Writer) error {
+	return cf.exportPlain(w, cf.Load)
+}
+
+// replaces the config context with the unencrypted context
+// read from the given stream and saves the config. the
+// context is encrypted with the current passphrase.
+//
+// in: r - the stream to read the config context from
+func (cf *configFile) ImportPlain(r io.Reader) error {
+	return cf.importPlain(r, cf.Save)
+}
+
+func (cf *configFile) exportPlain(w io.Writer, load func() error) error {
+
+	var (
+		err error
+	)
+
+	if err = load(); err != nil {
+		return err
+	}
+	return cf.context.Save(w)
+}
+
+func (cf *configFile) importPlain(r io.Reader, save func() error) error {
+
+	var (
+		err error
+	)
+
+	if err = cf.context.Reset(); err != nil {
+		return err
+	}
+	if err = cf.context.Load(r); err != nil {
+		return err
+	}
+	return save()
+}
+
 func (cf *configFile) EULAAccepted() bool {
 	return cf.GetBool("eulaaccepted")
 }
 
 func (cf *configFile) SetEULAAccepted() {
 	cf.Set("eulaaccepted", true)
+	cf.dirty = true
 }
 
 func (cf *configFile) Initialized() bool {
@@ -295,6 +736,7 @@ func (cf *configFile) Initialized() bool {
 
 func (cf *configFile) SetInitialized() {
 	cf.Set("initialized", true)
+	cf.dirty = true
 }
 
 func (cf *configFile) HasPassphrase() bool {
@@ -303,6 +745,7 @@ func (cf *configFile) HasPassphrase() bool {
 
 func (cf *configFile) SetPassphrase(passphrase string) {
 	cf.passphrase = passphrase
+	cf.dirty = true
 
 	if len(passphrase) == 0 {
 		cf.keyTimeout = -1
@@ -313,12 +756,84 @@ func (cf *configFile) SetPassphrase(passphrase string) {
 
 func (cf *configFile) SetKeyTimeout(timeout time.Duration) {
 	cf.keyTimeout = int64(timeout)
+	cf.dirty = true
+}
+
+// returns the duration the passphrase key is saved
+// for. this will be 0 if the key is not saved.
+func (cf *configFile) KeyTimeout() time.Duration {
+	if cf.keyTimeout > 0 {
+		return time.Duration(cf.keyTimeout)
+	}
+	return 0
+}
+
+// returns the time at which the saved passphrase key
+// expires. the key expires once the key timeout has
+// elapsed since the config file was last saved.
+//
+// out: the expiration time of the saved key
+// out: false if no key has been saved
+func (cf *configFile) KeyExpiresAt() (time.Time, bool) {
+
+	keyTimeout := cf.GetInt64("keyTimeout")
+	if cf.Get("key") == nil || keyTimeout <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, cf.timestamp+keyTimeout), true
+}
+
+// sets whether the serialized context is compressed
+// before it is encrypted. configs are read regardless
+// of whether they were saved with compression.
+func (cf *configFile) SetCompression(compress bool) {
+	cf.compress = compress
+	cf.dirty = true
 }
 
 func (cf *configFile) Context() Context {
 	return cf.context
 }
 
+// compresses the serialized context using gzip
+func compressContext(data string) (string, error) {
+
+	var (
+		err error
+
+		compressed strings.Builder
+	)
+
+	writer := gzip.NewWriter(&compressed)
+	if _, err = io.WriteString(writer, data); err != nil {
+		return "", err
+	}
+	if err = writer.Close(); err != nil {
+		return "", err
+	}
+	return compressed.String(), nil
+}
+
+// returns a reader that decompresses the serialized
+// context if it was saved with compression. compressed
+// data is detected using the gzip header's magic bytes.
+func decompressContext(input io.Reader) (io.Reader, error) {
+
+	var (
+		err    error
+		header []byte
+	)
+
+	reader := bufio.NewReader(input)
+	if header, err = reader.Peek(2); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(header) == 2 && header[0] == 0x1f && header[1] == 0x8b {
+		return gzip.NewReader(reader)
+	}
+	return reader, nil
+}
+
 func init() {
 
 	// retrieve the program executable's timestamp
@@ -1,19 +1,25 @@
 package config_test
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gobuffalo/packr/v2"
+	"github.com/spf13/afero"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	"github.com/mevansam/goforms/forms"
 	"github.com/appbricks/cloud-builder/config"
 	"github.com/appbricks/cloud-builder/cookbook"
+	"github.com/appbricks/cloud-builder/target"
+	"github.com/appbricks/cloud-builder/terraform"
 	"github.com/mevansam/gocloud/provider"
 
 	test_data "github.com/appbricks/cloud-builder/test/data"
@@ -66,53 +72,942 @@ var _ = Describe("Config File", func() {
 			// Load saved configuration and validate
 			cfg = initConfigFile(cfgPath, cb, "")
 			validateContextTestData(cfg.Context())
+
+			Expect(cfg.KeyTimeout()).To(Equal(time.Duration(0)))
+			_, saved := cfg.KeyExpiresAt()
+			Expect(saved).To(BeFalse())
+		})
+	})
+
+	Context("encrypted config file", func() {
+
+		It("initializes config and sets some data", func() {
+
+			var (
+				cfg config.Config
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			updateContextWithTestData(cfg.Context())
+
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			// Load saved configuration and validate
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			validateContextTestData(cfg.Context())
+		})
+
+		It("fails to read if passphrase is incorrect", func() {
+
+			var (
+				cfg config.Config
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			updateContextWithTestData(cfg.Context())
+
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg, err = config.InitFileConfig(cfgPath, cb,
+				// getPassphrase
+				func() string {
+					return "incorrect password"
+				})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg).NotTo(BeNil())
+
+			err = cfg.Load()
+			// config should fail to load
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("cipher: message authentication failed"))
+		})
+
+		It("fails to read if the encrypted config has been modified", func() {
+
+			var (
+				cfg  config.Config
+				info os.FileInfo
+				data []byte
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			updateContextWithTestData(cfg.Context())
+
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			info, err = os.Stat(cfgPath)
+			Expect(err).ToNot(HaveOccurred())
+			data, err = ioutil.ReadFile(cfgPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring("streamencryption: true"))
+
+			// modify a byte within the encrypted context retaining
+			// the file's timestamp which seeds the encryption key
+			i := strings.Index(string(data), "context: ") + 100
+			if data[i] == 'A' {
+				data[i] = 'B'
+			} else {
+				data[i] = 'A'
+			}
+			err = ioutil.WriteFile(cfgPath, data, 0600)
+			Expect(err).ToNot(HaveOccurred())
+			err = os.Chtimes(cfgPath, info.ModTime(), info.ModTime())
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg, err = config.InitFileConfig(cfgPath, cb,
+				// getPassphrase
+				func() string {
+					return "this is a test passphrase"
+				})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = cfg.Load()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("cipher: message authentication failed"))
+		})
+	})
+
+	Context("saving a config file", func() {
+
+		It("does not modify the config file if the save fails", func() {
+
+			var (
+				cfg config.Config
+
+				savedData, data []byte
+				info            os.FileInfo
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			updateContextWithTestData(cfg.Context())
+
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			info, err = os.Stat(cfgPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+			savedData, err = ioutil.ReadFile(cfgPath)
+			Expect(err).ToNot(HaveOccurred())
+
+			// simulate an error while the config is being written
+			cfg.(interface{ SetFs(afero.Fs) }).SetFs(&failingFs{Fs: afero.NewOsFs()})
+			cfg.SetEULAAccepted()
+
+			err = cfg.Save()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("simulated write error"))
+
+			data, err = ioutil.ReadFile(cfgPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal(savedData))
+
+			// load saved configuration and validate
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			Expect(cfg.EULAAccepted()).To(BeFalse())
+			validateContextTestData(cfg.Context())
+		})
+
+		It("keeps the context modified if the save fails", func() {
+
+			var (
+				cfg   config.Config
+				saved bool
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			updateContextWithTestData(cfg.Context())
+			cfg.(interface{ SetFs(afero.Fs) }).SetFs(&failingFs{Fs: afero.NewOsFs()})
+			saved, err = cfg.SaveIfDirty()
+			Expect(err).To(HaveOccurred())
+			Expect(saved).To(BeFalse())
+
+			// the changes are saved once the
+			// config file can be written
+			cfg.(interface{ SetFs(afero.Fs) }).SetFs(afero.NewOsFs())
+			saved, err = cfg.SaveIfDirty()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(saved).To(BeTrue())
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			validateContextTestData(cfg.Context())
+		})
+	})
+
+	Context("saving all sections of a config file", func() {
+
+		It("does not write the config file if a section fails", func() {
+
+			var (
+				cfg config.Config
+				tgt *target.Target
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "")
+			updateContextWithTestData(cfg.Context())
+			tgt, err = cfg.Context().NewTarget("basic", "aws")
+			Expect(err).ToNot(HaveOccurred())
+			tgt.Output = &map[string]terraform.Output{
+				"cb_node_description": {Value: make(chan int)},
+			}
+			cfg.Context().SaveTarget(tgt.Key(), tgt)
+
+			err = cfg.SaveAllSections()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("section 'targets': target "))
+			_, err = os.Stat(cfgPath)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			tgt.Output = nil
+			err = cfg.SaveAllSections()
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg = initConfigFile(cfgPath, cb, "")
+			validateContextTestData(cfg.Context())
+		})
+	})
+
+	Context("rotating the encryption passphrase", func() {
+
+		It("re-encrypts the config with a new passphrase", func() {
+
+			var (
+				cfg config.Config
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			updateContextWithTestData(cfg.Context())
+
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			err = cfg.RotatePassphrase(
+				func() string {
+					return "this is a test passphrase"
+				},
+				func() string {
+					return "this is a new test passphrase"
+				},
+			)
+			Expect(err).ToNot(HaveOccurred())
+
+			// load using the new passphrase
+			cfg = initConfigFile(cfgPath, cb, "this is a new test passphrase")
+			validateContextTestData(cfg.Context())
+
+			// old passphrase should no longer decrypt the config
+			cfg, err = config.InitFileConfig(cfgPath, cb,
+				// getPassphrase
+				func() string {
+					return "this is a test passphrase"
+				})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = cfg.Load()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("cipher: message authentication failed"))
+		})
+
+		It("fails to rotate the passphrase if the old passphrase is incorrect", func() {
+
+			var (
+				cfg config.Config
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			updateContextWithTestData(cfg.Context())
+
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			err = cfg.RotatePassphrase(
+				func() string {
+					return "incorrect password"
+				},
+				func() string {
+					return "this is a new test passphrase"
+				},
+			)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("cipher: message authentication failed"))
+
+			// config should still load with the original passphrase
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			validateContextTestData(cfg.Context())
+		})
+	})
+
+	Context("compressed config file", func() {
+
+		It("saves and loads a large config with and without compression", func() {
+
+			var (
+				cfg      config.Config
+				fileInfo os.FileInfo
+
+				uncompressedSize int64
+				value            *string
+			)
+
+			largeValue := strings.Repeat("a large value for a provider field. ", 10000)
+
+			for _, compress := range []bool{false, true} {
+
+				os.Remove(cfgPath)
+				cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+				updateContextWithTestData(cfg.Context())
+
+				cp, err := cfg.Context().GetCloudProvider("aws")
+				Expect(err).ToNot(HaveOccurred())
+				form, err := cp.InputForm()
+				Expect(err).ToNot(HaveOccurred())
+				err = form.SetFieldValue("region", largeValue)
+				Expect(err).ToNot(HaveOccurred())
+				cfg.Context().SaveCloudProvider(cp)
+
+				cfg.SetCompression(compress)
+				err = cfg.Save()
+				Expect(err).ToNot(HaveOccurred())
+
+				fileInfo, err = os.Stat(cfgPath)
+				Expect(err).ToNot(HaveOccurred())
+				if compress {
+					Expect(fileInfo.Size()).To(BeNumerically("<", uncompressedSize/10))
+				} else {
+					uncompressedSize = fileInfo.Size()
+				}
+
+				cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+				validateContextTestData(cfg.Context())
+				cp, err = cfg.Context().GetCloudProvider("aws")
+				Expect(err).ToNot(HaveOccurred())
+				value, err = cp.GetValue("region")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(*value).To(Equal(largeValue))
+			}
+		})
+	})
+
+	Context("config file with custom key derivation parameters", func() {
+
+		It("loads a config using the parameters it was saved with", func() {
+
+			var (
+				cfg config.Config
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			updateContextWithTestData(cfg.Context())
+			cfg.SetKDFParams(config.KDFParams{
+				Iterations:  2,
+				Memory:      8 * 1024,
+				Parallelism: 1,
+			})
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			validateContextTestData(cfg.Context())
+
+			// restoring the default parameters saves
+			// the config with the default derivation
+			cfg.SetKDFParams(config.KDFParams{})
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			validateContextTestData(cfg.Context())
+		})
+	})
+
+	Context("config file bound to a device", func() {
+
+		It("loads the config only with the device it was bound to", func() {
+
+			var (
+				cfg config.Config
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			updateContextWithTestData(cfg.Context())
+			cfg.SetDeviceBinding("device-1")
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg, err = config.InitFileConfig(cfgPath, cb,
+				func() string {
+					return "this is a test passphrase"
+				})
+			Expect(err).ToNot(HaveOccurred())
+			err = cfg.Load()
+			Expect(err).To(Equal(config.ErrDeviceMismatch))
+
+			cfg.SetDeviceBinding("device-2")
+			err = cfg.Load()
+			Expect(err).To(Equal(config.ErrDeviceMismatch))
+
+			cfg.SetDeviceBinding("device-1")
+			err = cfg.Load()
+			Expect(err).ToNot(HaveOccurred())
+			validateContextTestData(cfg.Context())
+
+			// removing the binding saves a config
+			// that can be loaded on any device
+			cfg.SetDeviceBinding("")
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			validateContextTestData(cfg.Context())
+		})
+
+		It("does not bind an unencrypted config to a device", func() {
+
+			var (
+				cfg config.Config
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "")
+			updateContextWithTestData(cfg.Context())
+			cfg.SetDeviceBinding("device-1")
+			err = cfg.Save()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("config file with an integrity check", func() {
+
+		It("detects a modified config before it is loaded", func() {
+
+			var (
+				cfg  config.Config
+				data []byte
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "")
+			updateContextWithTestData(cfg.Context())
+			cfg.SetIntegrityCheck(true)
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			data, err = ioutil.ReadFile(cfgPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring("integrity: sha256:"))
+
+			cfg = initConfigFile(cfgPath, cb, "")
+			validateContextTestData(cfg.Context())
+
+			// modify a character of the saved context
+			lines := strings.Split(string(data), "\n")
+			for i, line := range lines {
+				if strings.HasPrefix(line, "context: ") {
+					c := line[len(line)-2]
+					if c == 'A' {
+						c = 'B'
+					} else {
+						c = 'A'
+					}
+					lines[i] = line[:len(line)-2] + string(c) + line[len(line)-1:]
+				}
+			}
+			err = ioutil.WriteFile(cfgPath, []byte(strings.Join(lines, "\n")), 0600)
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg, err = config.InitFileConfig(cfgPath, cb, func() string { return "" })
+			Expect(err).ToNot(HaveOccurred())
+			err = cfg.Load()
+			Expect(errors.Is(err, config.ErrIntegrityFailure)).To(BeTrue())
+		})
+
+		It("loads configs saved without a checksum", func() {
+
+			var (
+				cfg  config.Config
+				data []byte
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "")
+			updateContextWithTestData(cfg.Context())
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			data, err = ioutil.ReadFile(cfgPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).ToNot(ContainSubstring("integrity:"))
+
+			cfg = initConfigFile(cfgPath, cb, "")
+			validateContextTestData(cfg.Context())
+		})
+	})
+
+	Context("config file with field level encryption", func() {
+
+		It("encrypts only the sensitive fields of the config", func() {
+
+			var (
+				cfg  config.Config
+				data []byte
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			updateContextWithTestData(cfg.Context())
+			cfg.SetFieldEncryption(true)
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			data, err = ioutil.ReadFile(cfgPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring("providers"))
+			Expect(string(data)).To(ContainSubstring("enc:"))
+			Expect(string(data)).ToNot(ContainSubstring("test secret key"))
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			validateContextTestData(cfg.Context())
+
+			// disabling field level encryption
+			// encrypts the whole context
+			cfg.SetFieldEncryption(false)
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			data, err = ioutil.ReadFile(cfgPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).ToNot(ContainSubstring("providers"))
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			validateContextTestData(cfg.Context())
+		})
+
+		It("encrypts the sensitive recipe fields and outputs of targets", func() {
+
+			var (
+				cfg  config.Config
+				tgt  *target.Target
+				form forms.InputForm
+				data []byte
+			)
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			ctx := cfg.Context()
+
+			form, err = ctx.Cookbook().GetRecipe("basic", "aws").InputForm()
+			Expect(err).ToNot(HaveOccurred())
+			err = form.SetFieldValue("test_input_5", "test cookbook secret")
+			Expect(err).ToNot(HaveOccurred())
+
+			tgt, err = ctx.NewTarget("basic", "aws")
+			Expect(err).ToNot(HaveOccurred())
+			form, err = tgt.Recipe.InputForm()
+			Expect(err).ToNot(HaveOccurred())
+			err = form.SetFieldValue("test_input_1", "aa")
+			Expect(err).ToNot(HaveOccurred())
+			err = form.SetFieldValue("test_input_5", "test recipe secret")
+			Expect(err).ToNot(HaveOccurred())
+			tgt.Output = &map[string]terraform.Output{
+				"test_output_1": {Sensitive: true, Value: "test output secret"},
+			}
+			ctx.SaveTarget(tgt.Key(), tgt)
+
+			cfg.SetFieldEncryption(true)
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			data, err = ioutil.ReadFile(cfgPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring("recipes"))
+			Expect(string(data)).To(ContainSubstring("enc:"))
+			Expect(string(data)).ToNot(ContainSubstring("test cookbook secret"))
+			Expect(string(data)).ToNot(ContainSubstring("test recipe secret"))
+			Expect(string(data)).ToNot(ContainSubstring("test output secret"))
+
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			ctx = cfg.Context()
+
+			value, err := ctx.Cookbook().GetRecipe("basic", "aws").GetValue("test_input_5")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*value).To(Equal("test cookbook secret"))
+
+			tgt, err = ctx.GetTarget("basic/aws/aa/")
+			Expect(err).ToNot(HaveOccurred())
+			value, err = tgt.Recipe.GetValue("test_input_5")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*value).To(Equal("test recipe secret"))
+			Expect(tgt.Output).ToNot(BeNil())
+			Expect((*tgt.Output)["test_output_1"].Value).To(Equal("test output secret"))
 		})
 	})
 
-	Context("encrypted config file", func() {
+	Context("config encrypted with a key file", func() {
 
-		It("initializes config and sets some data", func() {
+		It("encrypts the config with a key file and rotates it to a passphrase", func() {
 
 			var (
 				cfg config.Config
 			)
 
+			keyFilePath := filepath.Join(filepath.Dir(cfgPath), "config.key")
+			err = ioutil.WriteFile(keyFilePath, []byte("this is a test key\n"), 0600)
+			Expect(err).ToNot(HaveOccurred())
+			defer os.Remove(keyFilePath)
+
+			cfg, err = config.InitFileConfigWithKeyFile(cfgPath, cb, keyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			err = cfg.Load()
+			Expect(err).ToNot(HaveOccurred())
+			updateContextWithTestData(cfg.Context())
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			// the key file contents are the passphrase
+			cfg = initConfigFile(cfgPath, cb, "this is a test key")
+			validateContextTestData(cfg.Context())
+
+			cfg, err = config.InitFileConfigWithKeyFile(cfgPath, cb, keyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			getKey, err := config.KeyFilePassphrase(keyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			err = cfg.RotatePassphrase(getKey, func() string {
+				return "this is a test passphrase"
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg, err = config.InitFileConfigWithKeyFile(cfgPath, cb, keyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			err = cfg.Load()
+			Expect(err).To(HaveOccurred())
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			validateContextTestData(cfg.Context())
+
+			// empty key files are rejected
+			err = ioutil.WriteFile(keyFilePath, []byte("\n"), 0600)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = config.InitFileConfigWithKeyFile(cfgPath, cb, keyFilePath)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(fmt.Sprintf("key file '%s' is empty", keyFilePath)))
+		})
+	})
+
+	Context("plain config export", func() {
+
+		It("exports and imports an encrypted config", func() {
+
+			var (
+				cfg    config.Config
+				backup strings.Builder
+			)
+
 			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
 			updateContextWithTestData(cfg.Context())
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			err = cfg.ExportPlain(&backup)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backup.String()).To(ContainSubstring("test access key"))
+
+			os.Remove(cfgPath)
+			cfg = initConfigFile(cfgPath, cb, "another test passphrase")
+			err = cfg.ImportPlain(strings.NewReader(backup.String()))
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg = initConfigFile(cfgPath, cb, "another test passphrase")
+			validateContextTestData(cfg.Context())
+		})
+	})
+
+	Context("config file locking", func() {
+
+		It("serializes saves of the same config file", func() {
+
+			var (
+				wg sync.WaitGroup
+			)
+
+			cfgs := []config.Config{
+				initConfigFile(cfgPath, cb, ""),
+				initConfigFile(cfgPath, cb, ""),
+			}
+			for _, cfg := range cfgs {
+				updateContextWithTestData(cfg.Context())
+				cfg.SetLockTimeout(time.Minute)
+
+				wg.Add(1)
+				go func(cfg config.Config) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					for i := 0; i < 10; i++ {
+						// saves of a config that was saved by the
+						// other config since it was loaded fail
+						err := cfg.Save()
+						for errors.Is(err, config.ErrConfigModified) {
+							Expect(cfg.Load()).To(Succeed())
+							err = cfg.Save()
+						}
+						Expect(err).ToNot(HaveOccurred())
+					}
+				}(cfg)
+			}
+			wg.Wait()
+
+			cfg := initConfigFile(cfgPath, cb, "")
+			validateContextTestData(cfg.Context())
+		})
 
+		It("does not overwrite a config saved by another process", func() {
+
+			cfg1 := initConfigFile(cfgPath, cb, "")
+			cfg2 := initConfigFile(cfgPath, cb, "")
+
+			updateContextWithTestData(cfg1.Context())
+			err = cfg1.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			// the stale config is not saved
+			err = cfg2.Save()
+			Expect(errors.Is(err, config.ErrConfigModified)).To(BeTrue())
+
+			// loading the config re-reads the
+			// config saved by the other config
+			err = cfg2.Load()
+			Expect(err).ToNot(HaveOccurred())
+			validateContextTestData(cfg2.Context())
+			err = cfg2.Save()
+			Expect(err).ToNot(HaveOccurred())
+
+			err = cfg1.Save()
+			Expect(errors.Is(err, config.ErrConfigModified)).To(BeTrue())
+		})
+	})
+
+	Context("config format detection", func() {
+
+		It("detects the format of a config from its content or path", func() {
+
+			var (
+				format config.Format
+			)
+
+			cfgDir := filepath.Join(os.TempDir(), ".cb-format")
+			os.RemoveAll(cfgDir)
+			err = os.MkdirAll(cfgDir, 0700)
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(cfgDir)
+
+			format, err = config.DetectFormat(cfgDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(format).To(Equal(config.FormatDir))
+
+			format, err = config.DetectFormat(filepath.Join(cfgDir, "new.json"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(format).To(Equal(config.FormatJSON))
+			format, err = config.DetectFormat(filepath.Join(cfgDir, "new.yaml"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(format).To(Equal(config.FormatYAML))
+
+			unknownPath := filepath.Join(cfgDir, "new.txt")
+			_, err = config.DetectFormat(unknownPath)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(fmt.Sprintf(
+				"unable to detect the format of config '%s'. supported formats are json, yaml and directory",
+				unknownPath)))
+
+			// existing configs are detected by their content
+			cfgFilePath := filepath.Join(cfgDir, "config.cfg")
+			err = ioutil.WriteFile(cfgFilePath, []byte(`{"initialized": true}`), 0600)
+			Expect(err).ToNot(HaveOccurred())
+			format, err = config.DetectFormat(cfgFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(format).To(Equal(config.FormatJSON))
+
+			cfg := initConfigFile(cfgFilePath, cb, "")
+			updateContextWithTestData(cfg.Context())
 			err = cfg.Save()
 			Expect(err).ToNot(HaveOccurred())
+			cfg = initConfigFile(cfgFilePath, cb, "")
+			validateContextTestData(cfg.Context())
 
-			// Load saved configuration and validate
-			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			err = ioutil.WriteFile(cfgFilePath, []byte("initialized: true\n"), 0600)
+			Expect(err).ToNot(HaveOccurred())
+			format, err = config.DetectFormat(cfgFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(format).To(Equal(config.FormatYAML))
+		})
+	})
+
+	Context("config store", func() {
+
+		It("saves and loads a config using a custom store", func() {
+
+			var (
+				cfg config.Config
+			)
+
+			store := &memStore{}
+			cfg, err = config.InitStoreConfig(store, cb, func() string { return "this is a test passphrase" })
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.data).ToNot(BeEmpty())
+
+			err = cfg.Load()
+			Expect(err).ToNot(HaveOccurred())
+			updateContextWithTestData(cfg.Context())
+			err = cfg.Save()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(store.data)).ToNot(ContainSubstring("test access key"))
+
+			cfg, err = config.InitStoreConfig(store, cb, func() string { return "this is a test passphrase" })
+			Expect(err).ToNot(HaveOccurred())
+			err = cfg.Load()
+			Expect(err).ToNot(HaveOccurred())
 			validateContextTestData(cfg.Context())
+
+			cfg, err = config.InitStoreConfig(store, cb, func() string { return "an incorrect passphrase" })
+			Expect(err).ToNot(HaveOccurred())
+			err = cfg.Load()
+			Expect(err).To(HaveOccurred())
 		})
+	})
 
-		It("fails to read if passphrase is incorrect", func() {
+	Context("config directory", func() {
+
+		It("saves each section of the config to a separate file", func() {
+
+			var (
+				cfg config.Config
+			)
+
+			cfgDir := filepath.Join(os.TempDir(), ".cb-dir")
+			os.RemoveAll(cfgDir)
+			defer os.RemoveAll(cfgDir)
+
+			for _, passphrase := range []string{"", "this is a test passphrase"} {
+
+				ext := ".json"
+				if len(passphrase) > 0 {
+					ext = ".enc"
+				}
+
+				cfg = initDirConfig(cfgDir, cb, passphrase)
+				updateContextWithTestData(cfg.Context())
+				err = cfg.Save()
+				Expect(err).ToNot(HaveOccurred())
+
+				for _, name := range []string{"config.yml", "providers" + ext, "backends" + ext, "recipes" + ext} {
+					_, err = os.Stat(filepath.Join(cfgDir, name))
+					Expect(err).ToNot(HaveOccurred())
+				}
+				_, err = os.Stat(filepath.Join(cfgDir, "targets"))
+				Expect(err).ToNot(HaveOccurred())
+
+				cfg = initDirConfig(cfgDir, cb, passphrase)
+				validateContextTestData(cfg.Context())
+			}
+		})
+
+		It("writes the remaining sections if a section cannot be written", func() {
+
+			var (
+				cfg config.Config
+			)
+
+			cfgDir := filepath.Join(os.TempDir(), ".cb-dir")
+			os.RemoveAll(cfgDir)
+			defer os.RemoveAll(cfgDir)
+
+			// the backends file cannot replace a non-empty directory
+			err = os.MkdirAll(filepath.Join(cfgDir, "backends.json", "blocked"), 0700)
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg = initDirConfig(cfgDir, cb, "")
+			updateContextWithTestData(cfg.Context())
+			err = cfg.SaveAllSections()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("section 'backends'"))
+			Expect(err.Error()).ToNot(ContainSubstring("section 'providers'"))
+
+			for _, name := range []string{"config.yml", "providers.json", "recipes.json"} {
+				_, err = os.Stat(filepath.Join(cfgDir, name))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			files, err := ioutil.ReadDir(filepath.Join(cfgDir, "targets"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(HaveLen(2))
+
+			err = os.RemoveAll(filepath.Join(cfgDir, "backends.json"))
+			Expect(err).ToNot(HaveOccurred())
+			err = cfg.SaveAllSections()
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg = initDirConfig(cfgDir, cb, "")
+			validateContextTestData(cfg.Context())
+		})
+	})
+
+	Context("shared config file with multiple recipients", func() {
+
+		It("can be unlocked by each recipient", func() {
 
 			var (
 				cfg config.Config
+
+				pubKey1, privKey1,
+				pubKey2, privKey2,
+				id2 string
 			)
 
+			pubKey1, privKey1, err = config.GenerateRecipientKey()
+			Expect(err).ToNot(HaveOccurred())
+			pubKey2, privKey2, err = config.GenerateRecipientKey()
+			Expect(err).ToNot(HaveOccurred())
+
 			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
 			updateContextWithTestData(cfg.Context())
 
+			_, err = cfg.AddRecipient(pubKey1)
+			Expect(err).ToNot(HaveOccurred())
+			id2, err = cfg.AddRecipient(pubKey2)
+			Expect(err).ToNot(HaveOccurred())
 			err = cfg.Save()
 			Expect(err).ToNot(HaveOccurred())
 
-			cfg, err = config.InitFileConfig(cfgPath, cb,
-				// getPassphrase
-				func() string {
-					return "incorrect password"
-				})
+			// the owner can still unlock the config with the passphrase
+			cfg = initConfigFile(cfgPath, cb, "this is a test passphrase")
+			validateContextTestData(cfg.Context())
+
+			// each recipient can unlock the config with their private key
+			for _, privKey := range []string{privKey1, privKey2} {
+				cfg, err = config.InitFileConfig(cfgPath, cb, func() string { return "" })
+				Expect(err).ToNot(HaveOccurred())
+				err = cfg.SetIdentity(privKey)
+				Expect(err).ToNot(HaveOccurred())
+				err = cfg.Load()
+				Expect(err).ToNot(HaveOccurred())
+				validateContextTestData(cfg.Context())
+			}
 
+			// a removed recipient can no longer unlock the config
+			err = cfg.RemoveRecipient(id2)
+			Expect(err).ToNot(HaveOccurred())
+			err = cfg.RemoveRecipient(id2)
+			Expect(err).To(HaveOccurred())
+			err = cfg.Save()
 			Expect(err).ToNot(HaveOccurred())
-			Expect(cfg).NotTo(BeNil())
 
+			cfg, err = config.InitFileConfig(cfgPath, cb, func() string { return "" })
+			Expect(err).ToNot(HaveOccurred())
+			err = cfg.SetIdentity(privKey2)
+			Expect(err).ToNot(HaveOccurred())
 			err = cfg.Load()
-			// config should fail to load
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(Equal("cipher: message authentication failed"))
+			Expect(err.Error()).To(Equal("the config could not be unlocked by any of its recipients"))
 		})
 	})
 
@@ -142,6 +1037,12 @@ var _ = Describe("Config File", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(cfg).NotTo(BeNil())
 
+			Expect(cfg.KeyTimeout()).To(Equal(10 * time.Second))
+			expiresAt, saved := cfg.KeyExpiresAt()
+			Expect(saved).To(BeTrue())
+			Expect(expiresAt).To(BeTemporally(">", time.Now()))
+			Expect(expiresAt).To(BeTemporally("<=", time.Now().Add(10*time.Second)))
+
 			err = cfg.Load()
 			validateContextTestData(cfg.Context())
 
@@ -167,6 +1068,47 @@ var _ = Describe("Config File", func() {
 	})
 })
 
+// store that saves the config in memory
+type memStore struct {
+	data []byte
+}
+
+func (s *memStore) Read() ([]byte, error) {
+	return s.data, nil
+}
+
+func (s *memStore) Write(data []byte) error {
+	s.data = append([]byte{}, data...)
+	return nil
+}
+
+// file system that fails part
+// way through writing a file
+type failingFs struct {
+	afero.Fs
+}
+
+type failingFile struct {
+	afero.File
+}
+
+func (fs *failingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &failingFile{File: f}, nil
+}
+
+func (f *failingFile) Write(b []byte) (int, error) {
+	n, _ := f.File.Write(b[:len(b)/2])
+	return n, fmt.Errorf("simulated write error")
+}
+
+func (f *failingFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
 func initConfigFile(
 	cfgPath string,
 	cb *cookbook.Cookbook,
@@ -196,6 +1138,35 @@ func initConfigFile(
 	return cfg
 }
 
+func initDirConfig(
+	cfgDir string,
+	cb *cookbook.Cookbook,
+	passphrase string,
+) config.Config {
+
+	var (
+		err error
+		cfg config.Config
+	)
+
+	cfg, err = config.InitDirConfig(cfgDir, cb,
+		// getPassphrase
+		func() string {
+			return passphrase
+		})
+
+	Expect(err).ToNot(HaveOccurred())
+	Expect(cfg).NotTo(BeNil())
+
+	err = cfg.Load()
+	Expect(err).ToNot(HaveOccurred())
+
+	if !cfg.HasPassphrase() && len(passphrase) > 0 {
+		cfg.SetPassphrase(passphrase)
+	}
+	return cfg
+}
+
 func updateContextWithTestData(ctx config.Context) {
 
 	var (
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the format a config is saved in
+type Format int
+
+const (
+	FormatUnknown Format = iota
+	FormatJSON
+	FormatYAML
+	FormatDir
+)
+
+var formatNames = []string{
+	"unknown",
+	"json",
+	"yaml",
+	"directory",
+}
+
+func (f Format) String() string {
+	if f >= FormatUnknown && int(f) < len(formatNames) {
+		return formatNames[f]
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// returns the format of the config at the given path. the
+// format of an existing config file is detected from its
+// content. the format of a new config is detected from the
+// extension of the path.
+//
+// in: path - the path of the config file or directory
+// out: the format of the config
+func DetectFormat(path string) (Format, error) {
+
+	var (
+		err error
+
+		fileInfo os.FileInfo
+		data     []byte
+	)
+
+	if fileInfo, err = os.Stat(path); err == nil {
+		if fileInfo.IsDir() {
+			return FormatDir, nil
+		}
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return FormatUnknown, err
+		}
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			if data[0] == '{' {
+				return FormatJSON, nil
+			}
+			settings := make(map[string]interface{})
+			if err = yaml.Unmarshal(data, &settings); err == nil {
+				return FormatYAML, nil
+			}
+			return FormatUnknown, unknownFormatError(path)
+		}
+	} else if !os.IsNotExist(err) {
+		return FormatUnknown, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON, nil
+	case ".yml", ".yaml":
+		return FormatYAML, nil
+	}
+	return FormatUnknown, unknownFormatError(path)
+}
+
+func unknownFormatError(path string) error {
+	return fmt.Errorf(
+		"unable to detect the format of config '%s'. supported formats are %s, %s and %s",
+		path, FormatJSON, FormatYAML, FormatDir)
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// the root key of the section index
+const sectionIndexKey = "sectionIndex"
+
+// the number of bytes at the end of a serialized
+// config that are searched for the section index
+const sectionIndexMaxSize = 1024
+
+// index of the byte offsets of each section of a
+// serialized config. it is written as the last root
+// key of the config so it can be read from the end
+// of the config without reading the sections.
+type sectionIndex struct {
+	Version int `json:"version"`
+
+	// the start and end offsets of each
+	// section's value keyed by section
+	Sections map[string][2]int64 `json:"sections"`
+}
+
+// writer that counts the bytes written to it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// reader that counts the bytes read from it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// loads the cloud configuration from the given reader. each
+// section is read directly using the section index written
+// by Save. configs without an index are read sequentially.
+//
+// in: r - the reader to read the configuration from
+// in: size - the size of the serialized configuration
+func (cc *configContext) LoadAt(r io.ReaderAt, size int64) error {
+
+	var (
+		err   error
+		index *sectionIndex
+	)
+
+	if index, err = readSectionIndex(r, size); err != nil {
+		return err
+	}
+	if index == nil {
+		return cc.Load(io.NewSectionReader(r, 0, size))
+	}
+	for _, section := range []string{"providers", "providerExpiry", "backends", "recipes", "targets"} {
+		if err = cc.loadSectionAt(r, index, section); err != nil {
+			return err
+		}
+	}
+
+	cc.clearDirty()
+	return nil
+}
+
+// loads only the given section of the cloud configuration
+// from the given reader. the section is read directly
+// using the section index written by Save.
+//
+// in: r - the reader to read the configuration from
+// in: size - the size of the serialized configuration
+// in: section - one of 'providers', 'backends', 'recipes'
+//               or 'targets'
+func (cc *configContext) LoadSectionAt(r io.ReaderAt, size int64, section string) error {
+
+	var (
+		err   error
+		index *sectionIndex
+	)
+
+	if index, err = readSectionIndex(r, size); err != nil {
+		return err
+	}
+	if index == nil {
+		return cc.LoadSection(io.NewSectionReader(r, 0, size), section)
+	}
+
+	switch section {
+	case "providers", "backends", "recipes", "targets":
+	default:
+		return fmt.Errorf(
+			"invalid config section '%s'",
+			section)
+	}
+	if err = cc.loadSectionAt(r, index, section); err != nil {
+		return err
+	}
+	if section == "providers" {
+		// provider expiry is loaded
+		// with the providers section
+		if err = cc.loadSectionAt(r, index, "providerExpiry"); err != nil {
+			return err
+		}
+	}
+	cc.clearDirty(section)
+	return nil
+}
+
+// decodes the given section at its indexed offsets
+func (cc *configContext) loadSectionAt(r io.ReaderAt, index *sectionIndex, section string) error {
+
+	offsets, exists := index.Sections[section]
+	if !exists {
+		return nil
+	}
+	return cc.decodeSection(
+		json.NewDecoder(io.NewSectionReader(r, offsets[0], offsets[1]-offsets[0])),
+		section,
+	)
+}
+
+// reads the section index from the end of a serialized config
+//
+// in: r - the reader to read the configuration from
+// in: size - the size of the serialized configuration
+// out: the section index or nil if the config does not have
+//      an index that can be used by this version
+func readSectionIndex(r io.ReaderAt, size int64) (*sectionIndex, error) {
+
+	var (
+		err   error
+		n     int
+		index sectionIndex
+	)
+
+	tailSize := int64(sectionIndexMaxSize)
+	if size < tailSize {
+		tailSize = size
+	}
+	tail := make([]byte, tailSize)
+	if n, err = r.ReadAt(tail, size-tailSize); err != nil && !(err == io.EOF && int64(n) == tailSize) {
+		return nil, err
+	}
+
+	// the index is the value of the last root
+	// key which is followed by the root's close
+	// brace at the end of the config
+	tail = bytes.TrimSpace(tail)
+	key := []byte(fmt.Sprintf("\"%s\":", sectionIndexKey))
+	i := bytes.LastIndex(tail, key)
+	if i == -1 || len(tail) == 0 || tail[len(tail)-1] != '}' {
+		return nil, nil
+	}
+	if err = json.Unmarshal(tail[i+len(key):len(tail)-1], &index); err != nil {
+		// not a section index
+		return nil, nil
+	}
+	if index.Version != ConfigVersion {
+		// config needs to be read
+		// sequentially to migrate it
+		return nil, nil
+	}
+	for section, offsets := range index.Sections {
+		if offsets[0] < 0 || offsets[1] < offsets[0] || offsets[1] > size {
+			return nil, fmt.Errorf(
+				"the index of config section '%s' is invalid",
+				section)
+		}
+	}
+	return &index, nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// error returned when loading a config whose
+// context does not match its integrity checksum
+var ErrIntegrityFailure = errors.New("config integrity check failed")
+
+// sets whether a checksum of the serialized context is saved
+// with configs whose context is not encrypted with the
+// passphrase or for recipients. the checksum is verified
+// before the context is loaded so that corruption of the
+// config is detected before it is parsed. contexts that are
+// encrypted are authenticated when they are decrypted and do
+// not require a checksum. configs saved without a checksum
+// are loaded without verifying their integrity.
+//
+// in: enabled - whether the checksum should be saved
+func (cf *configFile) SetIntegrityCheck(enabled bool) {
+	cf.integrityCheck = enabled
+	cf.dirty = true
+}
+
+// returns the checksum of the given serialized context
+func contextChecksum(context string) string {
+	sum := sha256.Sum256([]byte(context))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// verifies the saved context against the checksum
+// saved with it if the config has a checksum
+func (cf *configFile) verifyIntegrity() error {
+
+	checksum := cf.GetString("integrity")
+	if len(checksum) == 0 {
+		return nil
+	}
+	context, ok := cf.Get("context").(string)
+	if !ok || contextChecksum(context) != checksum {
+		return fmt.Errorf("config '%s': %w", cf.storeName(), ErrIntegrityFailure)
+	}
+	return nil
+}
+
+// saves the checksum of the given saved context
+// with the config settings
+//
+// in: context - the context as it is saved
+// in: authenticated - whether the context is encrypted
+//                     and authenticated when decrypted
+func (cf *configFile) saveIntegrity(context string, authenticated bool) {
+
+	if cf.integrityCheck && !authenticated {
+		cf.Set("integrity", contextChecksum(context))
+	} else if cf.IsSet("integrity") {
+		cf.Set("integrity", nil)
+	}
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/binary"
+
+	"github.com/mevansam/goutils/crypto"
+	"golang.org/x/crypto/argon2"
+)
+
+// parameters of the argon2id key derivation function
+// used to derive the config encryption key from the
+// config passphrase. the zero value selects the key
+// derivation used by configs saved without parameters.
+type KDFParams struct {
+	// number of passes over the memory
+	Iterations uint32
+	// memory used in KiB
+	Memory uint32
+	// number of threads used
+	Parallelism uint8
+}
+
+// parameters used for any field of
+// custom parameters that is not set
+var defaultKDFParams = KDFParams{
+	Iterations:  1,
+	Memory:      64 * 1024,
+	Parallelism: 4,
+}
+
+// sets the cost parameters of the key derivation function used
+// to derive the encryption key from the passphrase when the
+// config is next saved. the parameters are saved in the config
+// file alongside the encrypted context as the same parameters
+// are required to derive the key when the config is loaded. a
+// config saved with parameters can therefore only be opened by
+// versions that read the saved parameters. setting the zero
+// value restores the default key derivation which can be read
+// by all versions.
+//
+// in: params - the key derivation parameters
+func (cf *configFile) SetKDFParams(params KDFParams) {
+
+	if params != (KDFParams{}) {
+		if params.Iterations == 0 {
+			params.Iterations = defaultKDFParams.Iterations
+		}
+		if params.Memory == 0 {
+			params.Memory = defaultKDFParams.Memory
+		}
+		if params.Parallelism == 0 {
+			params.Parallelism = defaultKDFParams.Parallelism
+		}
+	}
+	cf.kdfParams = params
+	cf.dirty = true
+}
+
+// returns the key derivation parameters the
+// config file was last saved with
+func (cf *configFile) savedKDFParams() KDFParams {
+
+	if !cf.IsSet("kdf") {
+		return KDFParams{}
+	}
+	return KDFParams{
+		Iterations:  cf.GetUint32("kdf.iterations"),
+		Memory:      cf.GetUint32("kdf.memory"),
+		Parallelism: uint8(cf.GetUint32("kdf.parallelism")),
+	}
+}
+
+// saves the key derivation parameters
+// with the config file settings
+func (cf *configFile) saveKDFParams() {
+
+	if cf.kdfParams == (KDFParams{}) {
+		if cf.IsSet("kdf") {
+			cf.Set("kdf", nil)
+		}
+		return
+	}
+	cf.Set("kdf", map[string]interface{}{
+		"iterations":  cf.kdfParams.Iterations,
+		"memory":      cf.kdfParams.Memory,
+		"parallelism": cf.kdfParams.Parallelism,
+	})
+}
+
+// derives the encryption key from the config's passphrase
+//
+// in: params - the key derivation parameters
+// in: timestamp - the seed for the key
+// out: the derived key
+func (cf *configFile) passphraseKey(params KDFParams, timestamp int64) []byte {
+
+	if params == (KDFParams{}) {
+		return crypto.KeyFromPassphrase(cf.passphrase, timestamp)
+	}
+
+	salt := make([]byte, 8)
+	binary.BigEndian.PutUint64(salt, uint64(timestamp))
+	return argon2.IDKey(
+		[]byte(cf.passphrase), salt,
+		params.Iterations, params.Memory, params.Parallelism,
+		32,
+	)
+}
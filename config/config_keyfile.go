@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/mevansam/goutils/logger"
+
+	"github.com/appbricks/cloud-builder/cookbook"
+)
+
+// initializes file based configuration that is encrypted
+// with a key read from a key file instead of a passphrase
+// entered by the user. this allows configs to be used by
+// automation that cannot prompt for a passphrase.
+//
+// in: path - the path of the config file
+// in: cookbook - the embedded cookbook the config should be
+//                assciated with
+// in: keyFilePath - the path of the file containing the key
+// out: a Config instance containing the global
+//      configuration for CloudBuilder
+func InitFileConfigWithKeyFile(
+	path string,
+	cookbook *cookbook.Cookbook,
+	keyFilePath string,
+) (Config, error) {
+
+	var (
+		err error
+
+		getKey GetPassphrase
+		config Config
+	)
+
+	if getKey, err = KeyFilePassphrase(keyFilePath); err != nil {
+		return nil, err
+	}
+	if config, err = InitFileConfig(path, cookbook, getKey); err != nil {
+		return nil, err
+	}
+
+	// the key is always read from the key file
+	// instead of any passphrase key saved with
+	// the config
+	cf, ok := config.(*configFile)
+	if !ok {
+		cf = config.(*configDir).configFile
+	}
+	cf.passphrase = getKey()
+	if cf.keyTimeout == -1 {
+		cf.keyTimeout = 0
+	}
+	return config, nil
+}
+
+// returns a callback that returns the contents of the given
+// key file as the passphrase. the callback can be used to
+// rotate a config from a passphrase to a key file and back
+// via RotatePassphrase. the key file is read immediately.
+//
+// in: keyFilePath - the path of the file containing the key
+// out: callback that returns the key
+func KeyFilePassphrase(keyFilePath string) (GetPassphrase, error) {
+
+	var (
+		err error
+
+		fileInfo os.FileInfo
+		data     []byte
+	)
+
+	if fileInfo, err = os.Stat(keyFilePath); err != nil {
+		return nil, err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return nil, fmt.Errorf("key file '%s' is not a regular file", keyFilePath)
+	}
+	if runtime.GOOS != "windows" && fileInfo.Mode().Perm()&0004 != 0 {
+		logger.WarnMessage(
+			"Key file '%s' is readable by all users. Its permissions should be restricted to its owner.",
+			filepath.Clean(keyFilePath))
+	}
+	if data, err = ioutil.ReadFile(keyFilePath); err != nil {
+		return nil, err
+	}
+
+	key := strings.TrimSpace(string(data))
+	if len(key) == 0 {
+		return nil, fmt.Errorf("key file '%s' is empty", keyFilePath)
+	}
+	return func() string {
+		return key
+	}, nil
+}
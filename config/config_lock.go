@@ -0,0 +1,158 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mevansam/goutils/logger"
+	"github.com/spf13/viper"
+)
+
+// error returned when the config file could not be
+// locked as it is locked by another process
+var ErrConfigLocked = errors.New("config is locked by another process")
+
+// error returned when saving a config that has been
+// saved by another process since it was last loaded
+var ErrConfigModified = errors.New("config was saved by another process since it was loaded")
+
+// the time to wait for the config file lock by default
+const defaultLockTimeout = 10 * time.Second
+
+// the interval at which a locked
+// config file lock is retried
+const lockRetryInterval = 50 * time.Millisecond
+
+// sets how long to wait to lock the config file when it
+// is locked by another process. if the lock cannot be
+// acquired within the timeout the config is not loaded
+// or saved and ErrConfigLocked is returned. a timeout
+// of 0 fails immediately if the config file is locked.
+//
+// in: timeout - the time to wait for the lock
+func (cf *configFile) SetLockTimeout(timeout time.Duration) {
+	cf.lockTimeout = timeout
+}
+
+// acquires an advisory lock on the config file. the lock
+// is held on a separate lock file in the config directory
+// as the config file is replaced when it is saved. configs
+// that are not saved to a file are not locked. advisory
+// locks are not implemented on windows where the lock is
+// always acquired and concurrent saves are only detected
+// by the sequence check when the config is saved.
+//
+// in: exclusive - true to acquire an exclusive lock to
+//                 write the config otherwise a shared
+//                 lock is acquired to read the config
+// out: function to release the lock
+func (cf *configFile) lock(exclusive bool) (func(), error) {
+
+	var (
+		err    error
+		locked bool
+
+		lockFile *os.File
+	)
+
+	if len(cf.path) == 0 {
+		// configs saved to other stores are
+		// serialized by the store
+		return func() {}, nil
+	}
+	lockPath := filepath.Join(
+		filepath.Dir(cf.path),
+		"."+filepath.Base(cf.path)+".lock",
+	)
+	if lockFile, err = os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(cf.lockTimeout)
+	for {
+		if locked, err = tryLockFile(lockFile, exclusive); err != nil {
+			lockFile.Close()
+			return nil, err
+		}
+		if locked {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			lockFile.Close()
+			return nil, ErrConfigLocked
+		}
+		time.Sleep(lockRetryInterval)
+	}
+
+	return func() {
+		unlockFile(lockFile)
+		lockFile.Close()
+	}, nil
+}
+
+// returns the sequence of the config settings saved
+// in the config's store. the sequence is incremented
+// each time the config is saved.
+func (cf *configFile) storeSequence() (int64, error) {
+
+	var (
+		err  error
+		data []byte
+	)
+
+	if data, err = cf.store.Read(); err != nil || data == nil {
+		return 0, err
+	}
+	v := viper.New()
+	v.SetConfigType(cf.configType)
+	if err = v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return 0, err
+	}
+	return v.GetInt64("sequence"), nil
+}
+
+// re-reads the config settings if they have been saved
+// by another process since they were last read. settings
+// that have not been saved are discarded in that case.
+// the config must be locked.
+func (cf *configFile) refreshSettings() error {
+
+	sequence, err := cf.storeSequence()
+	if err != nil {
+		return err
+	}
+	if sequence == cf.GetInt64("sequence") {
+		return nil
+	}
+	logger.TraceMessage(
+		"Config '%s' was saved by another process. Re-reading its settings.",
+		cf.storeName())
+
+	cf.Viper = *viper.New()
+	cf.initSettings()
+	if err = cf.readSettings(); err != nil {
+		return err
+	}
+	cf.AutomaticEnv()
+	return cf.loadSettings()
+}
+
+// returns ErrConfigModified if the config has been saved
+// by another process since it was last loaded or saved
+// so that the changes saved by the other process are not
+// overwritten. the config must be locked.
+func (cf *configFile) checkNotModified() error {
+
+	sequence, err := cf.storeSequence()
+	if err != nil {
+		return err
+	}
+	if sequence != cf.GetInt64("sequence") {
+		return fmt.Errorf("config '%s': %w", cf.storeName(), ErrConfigModified)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+// +build !windows
+
+package config
+
+import (
+	"os"
+	"syscall"
+)
+
+// attempts to lock the given file without blocking
+//
+// out: false if the file is locked by another process
+func tryLockFile(f *os.File, exclusive bool) (bool, error) {
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,19 @@
+// +build windows
+
+package config
+
+import (
+	"os"
+)
+
+// advisory file locks are not implemented on windows
+// so the lock always succeeds and the config file is
+// never considered locked. concurrent saves are still
+// detected by comparing the saved config sequence.
+func tryLockFile(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/mevansam/goutils/logger"
+
+	"github.com/appbricks/cloud-builder/internal/jsonenc"
+)
+
+// the version of the serialized config
+// structure written by this release
+const ConfigVersion = 1
+
+// upgrades the 'cloud' section of a serialized
+// config from one version to a later version
+type Migration func(cloud map[string]json.RawMessage) error
+
+type migration struct {
+	to int
+	fn Migration
+}
+
+// registered migrations keyed by
+// the version they upgrade from
+var migrations = make(map[int]migration)
+
+// registers a function that upgrades the 'cloud' section
+// of a config serialized with version 'from' to version
+// 'to'. migrations are chained when a config is loaded
+// until it has been upgraded to the current version.
+//
+// in: from - the version the migration upgrades from
+// in: to - the version the migration upgrades to
+// in: fn - the function that upgrades the 'cloud' section
+//          of the config. sections are keyed by name.
+func RegisterMigration(from, to int, fn func(map[string]json.RawMessage) error) {
+
+	if to <= from {
+		panic(fmt.Sprintf(
+			"invalid config migration from version %d to version %d",
+			from, to))
+	}
+	if _, exists := migrations[from]; exists {
+		panic(fmt.Sprintf(
+			"a config migration from version %d has already been registered",
+			from))
+	}
+	migrations[from] = migration{
+		to: to,
+		fn: fn,
+	}
+}
+
+// reads a serialized config from the given stream and
+// upgrades it to the current version if it was saved
+// by an older release. unversioned configs are treated
+// as version 0.
+//
+// in: input - the stream to read the configuration from
+// out: a stream from which the current version of the
+//      configuration can be read
+func migrateConfig(input io.Reader) (io.Reader, error) {
+
+	var (
+		err     error
+		exists  bool
+		data    []byte
+		value   json.RawMessage
+		version int
+
+		m migration
+	)
+
+	if data, err = ioutil.ReadAll(input); err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		// nothing to migrate
+		return bytes.NewReader(data), nil
+	}
+	root := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if value, exists = root["version"]; exists {
+		if err = json.Unmarshal(value, &version); err != nil {
+			return nil, err
+		}
+	}
+
+	if version > ConfigVersion {
+		return nil, fmt.Errorf(
+			"config version %d is newer than the supported version %d",
+			version, ConfigVersion)
+	}
+	if version == ConfigVersion {
+		return bytes.NewReader(data), nil
+	}
+
+	cloud := make(map[string]json.RawMessage)
+	if value, exists = root["cloud"]; exists {
+		if err = json.Unmarshal(value, &cloud); err != nil {
+			return nil, err
+		}
+	}
+	for version < ConfigVersion {
+		if m, exists = migrations[version]; !exists {
+			return nil, fmt.Errorf(
+				"no migration has been registered for config version %d",
+				version)
+		}
+		logger.TraceMessage(
+			"Migrating config from version %d to version %d.",
+			version, m.to)
+
+		if err = m.fn(cloud); err != nil {
+			return nil, err
+		}
+		version = m.to
+	}
+
+	// the offsets of the section index are
+	// invalid once the config is re-encoded
+	delete(root, sectionIndexKey)
+
+	if root["cloud"], err = jsonenc.Marshal(cloud); err != nil {
+		return nil, err
+	}
+	if root["version"], err = jsonenc.Marshal(version); err != nil {
+		return nil, err
+	}
+	if data, err = jsonenc.Marshal(root); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func init() {
+
+	// unversioned configs have the same
+	// structure as version 1 configs
+	RegisterMigration(0, 1,
+		func(cloud map[string]json.RawMessage) error {
+			return nil
+		},
+	)
+}
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/mevansam/goforms/config"
+
+	"github.com/appbricks/cloud-builder/internal/jsonenc"
+)
+
+// types of the events of a newline delimited json config
+const (
+	ndjsonVersion        = "version"
+	ndjsonProvider       = "provider"
+	ndjsonBackend        = "backend"
+	ndjsonProviderExpiry = "providerExpiry"
+	ndjsonRecipe         = "recipe"
+	ndjsonTarget         = "target"
+)
+
+// a line of a newline delimited json config
+type ndjsonEvent struct {
+	Type    string          `json:"type"`
+	Name    string          `json:"name,omitempty"`
+	Version int             `json:"version,omitempty"`
+	Value   json.RawMessage `json:"value,omitempty"`
+}
+
+// saves the cloud configuration to the given stream as newline
+// delimited json. the config version is written first followed
+// by one json object per line for each provider, backend,
+// recipe and target. each object is tagged with its type and
+// its value is serialized as it is in the document written
+// by Save.
+//
+// in: w - the stream to write the configuration to
+func (cc *configContext) SaveNDJSON(w io.Writer) error {
+
+	var (
+		err error
+
+		saved    config.Configurable
+		data     []byte
+		elements []json.RawMessage
+		targets  bytes.Buffer
+	)
+
+	write := func(event *ndjsonEvent) error {
+		line, err := jsonenc.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(line, '\n'))
+		return err
+	}
+
+	if err = write(&ndjsonEvent{Type: ndjsonVersion, Version: ConfigVersion}); err != nil {
+		return err
+	}
+
+	providers := cc.cloudProviders()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if saved, err = cc.unresolvedValues("providers", name, providers[name]); err != nil {
+			return err
+		}
+		if data, err = jsonenc.Marshal(saved); err != nil {
+			return err
+		}
+		if err = write(&ndjsonEvent{Type: ndjsonProvider, Name: name, Value: data}); err != nil {
+			return err
+		}
+	}
+
+	backends := cc.cloudBackends()
+	names = make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if saved, err = cc.unresolvedValues("backends", name, backends[name]); err != nil {
+			return err
+		}
+		if data, err = jsonenc.Marshal(saved); err != nil {
+			return err
+		}
+		if err = write(&ndjsonEvent{Type: ndjsonBackend, Name: name, Value: data}); err != nil {
+			return err
+		}
+	}
+
+	if len(cc.providerExpiry) > 0 {
+		if data, err = jsonenc.Marshal(cc.providerExpiry); err != nil {
+			return err
+		}
+		if err = write(&ndjsonEvent{Type: ndjsonProviderExpiry, Value: data}); err != nil {
+			return err
+		}
+	}
+
+	// the cookbook and target set are serialized as
+	// arrays which are split in to their elements
+	if data, err = jsonenc.Marshal(cc.cookbook); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+	for _, element := range elements {
+		recipe := struct {
+			Name string `json:"name"`
+		}{}
+		if err = json.Unmarshal(element, &recipe); err != nil {
+			return err
+		}
+		if err = write(&ndjsonEvent{Type: ndjsonRecipe, Name: recipe.Name, Value: element}); err != nil {
+			return err
+		}
+	}
+
+	if _, err = cc.targets.WriteTo(&targets); err != nil {
+		return err
+	}
+	elements = nil
+	if err = json.Unmarshal(targets.Bytes(), &elements); err != nil {
+		return err
+	}
+	for _, element := range elements {
+		if err = write(&ndjsonEvent{Type: ndjsonTarget, Value: element}); err != nil {
+			return err
+		}
+	}
+
+	cc.clearDirty()
+	return nil
+}
+
+// loads the cloud configuration from a stream written by
+// SaveNDJSON. each line is applied to the context as it is
+// read so the stream does not need to be read in its entirety.
+//
+// in: r - the stream to read the configuration from
+func (cc *configContext) LoadNDJSON(r io.Reader) error {
+
+	var (
+		err error
+
+		event ndjsonEvent
+
+		targetsDecoded bool
+	)
+
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+
+		event = ndjsonEvent{}
+		if err = decoder.Decode(&event); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if line == 1 {
+			if event.Type != ndjsonVersion {
+				return fmt.Errorf("the config stream does not begin with the config version")
+			}
+			if event.Version != ConfigVersion {
+				return fmt.Errorf(
+					"config stream version %d is not supported by this version which reads version %d",
+					event.Version, ConfigVersion)
+			}
+			continue
+		}
+
+		switch event.Type {
+		case ndjsonProvider:
+			err = cc.decodeCloudProvider(event.Name, json.NewDecoder(bytes.NewReader(event.Value)))
+		case ndjsonBackend:
+			err = cc.decodeCloudBackend(event.Name, json.NewDecoder(bytes.NewReader(event.Value)))
+		case ndjsonProviderExpiry:
+			err = json.Unmarshal(event.Value, &cc.providerExpiry)
+		case ndjsonRecipe:
+			cc.recipeCache.clear()
+			err = json.Unmarshal(ndjsonArray(event.Value), cc.cookbook)
+		case ndjsonTarget:
+			// targets after the first continue the
+			// target set so its orphans are retained
+			decoder := json.NewDecoder(bytes.NewReader(ndjsonArray(event.Value)))
+			if targetsDecoded {
+				err = cc.targets.DecodeMore(decoder, nil)
+			} else {
+				err = cc.targets.Decode(decoder, nil)
+			}
+			targetsDecoded = true
+		default:
+			err = fmt.Errorf("unknown config stream event type '%s'", event.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d of the config stream: %w", line, err)
+		}
+	}
+
+	cc.clearDirty()
+	return nil
+}
+
+// returns a json array containing the given element
+func ndjsonArray(element json.RawMessage) []byte {
+
+	data := make([]byte, 0, len(element)+2)
+	data = append(data, '[')
+	data = append(data, element...)
+	return append(data, ']')
+}
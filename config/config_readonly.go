@@ -0,0 +1,356 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/mevansam/gocloud/backend"
+	"github.com/mevansam/gocloud/provider"
+	"github.com/mevansam/goutils/logger"
+
+	"github.com/appbricks/cloud-builder/cookbook"
+	"github.com/appbricks/cloud-builder/target"
+	"github.com/appbricks/cloud-builder/terraform"
+)
+
+// error returned by methods of a read-only
+// context that would modify the context
+var ErrReadOnly = errors.New("config context is read-only")
+
+// configuration context that does not allow
+// the underlying context to be modified
+type readOnlyContext struct {
+	ctx Context
+}
+
+// returns a context that delegates to the given context
+// but does not allow it to be modified. methods that would
+// modify the context return ErrReadOnly or panic if they
+// do not return an error.
+//
+// in: ctx - the context to wrap
+// out: a read-only view of the context
+func ReadOnly(ctx Context) Context {
+
+	if ro, ok := ctx.(*readOnlyContext); ok {
+		return ro
+	}
+	return &readOnlyContext{
+		ctx: ctx,
+	}
+}
+
+func (ro *readOnlyContext) Load(input io.Reader) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) LoadContext(ctx context.Context, input io.Reader) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) LoadWithProgress(input io.Reader, cb func(section string, count int)) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) LoadSection(input io.Reader, section string) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) LoadAt(r io.ReaderAt, size int64) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) LoadSectionAt(r io.ReaderAt, size int64, section string) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) Save(output io.Writer) error {
+	// saving resets the modified
+	// state of the context
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) SaveIfDirty(output io.Writer) (bool, error) {
+	// saving resets the modified
+	// state of the context
+	return false, ErrReadOnly
+}
+
+func (ro *readOnlyContext) LoadNDJSON(r io.Reader) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) SaveNDJSON(w io.Writer) error {
+	// saving resets the modified
+	// state of the context
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) MarkAllDirty() {
+	panic(ErrReadOnly)
+}
+
+func (ro *readOnlyContext) CheckSections() error {
+	return ro.ctx.CheckSections()
+}
+
+func (ro *readOnlyContext) Compact() (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (ro *readOnlyContext) Fingerprint() (string, error) {
+	return ro.ctx.Fingerprint()
+}
+
+func (ro *readOnlyContext) Reset() error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) LoadYAML(input io.Reader) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) SaveYAML(output io.Writer) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) Validate() []error {
+	return ro.ctx.Validate()
+}
+
+func (ro *readOnlyContext) CookbookTimestamp() string {
+	return ro.ctx.CookbookTimestamp()
+}
+
+func (ro *readOnlyContext) IsTargetCurrent(name string) (bool, error) {
+	return ro.ctx.IsTargetCurrent(name)
+}
+
+func (ro *readOnlyContext) StaleTargets() []*target.Target {
+	return ro.ctx.StaleTargets()
+}
+
+func (ro *readOnlyContext) TargetsNeedingReapply() []*target.Target {
+	return ro.ctx.TargetsNeedingReapply()
+}
+
+// returns a copy of the underlying context's cookbook
+// so that its recipes cannot be modified via the cookbook.
+// nil is returned if the cookbook could not be copied.
+func (ro *readOnlyContext) Cookbook() *cookbook.Cookbook {
+
+	cb, err := ro.ctx.Cookbook().Copy()
+	if err != nil {
+		logger.ErrorMessage("Unable to copy the cookbook of a read-only context: %s", err.Error())
+		return nil
+	}
+	return cb
+}
+
+func (ro *readOnlyContext) GetCookbookRecipe(recipe, iaas string) (cookbook.Recipe, error) {
+	return ro.ctx.GetCookbookRecipe(recipe, iaas)
+}
+
+func (ro *readOnlyContext) SaveCookbookRecipe(recipe cookbook.Recipe) {
+	panic(ErrReadOnly)
+}
+
+func (ro *readOnlyContext) SetRecipeCacheSize(n int) {
+	// the cache does not
+	// modify the context
+	ro.ctx.SetRecipeCacheSize(n)
+}
+
+func (ro *readOnlyContext) ValidateRecipeInput(recipeName, iaas string, values map[string]string) []error {
+	return ro.ctx.ValidateRecipeInput(recipeName, iaas, values)
+}
+
+func (ro *readOnlyContext) SearchRecipes(query string) []cookbook.Recipe {
+	return ro.ctx.SearchRecipes(query)
+}
+
+func (ro *readOnlyContext) ListRecipes() []RecipeInfo {
+	return ro.ctx.ListRecipes()
+}
+
+func (ro *readOnlyContext) RecipeIaaSList(recipeName string) ([]string, error) {
+	return ro.ctx.RecipeIaaSList(recipeName)
+}
+
+func (ro *readOnlyContext) CloudProviderTemplates() []provider.CloudProvider {
+	return ro.ctx.CloudProviderTemplates()
+}
+
+func (ro *readOnlyContext) ConfiguredProviders() []provider.CloudProvider {
+	return ro.ctx.ConfiguredProviders()
+}
+
+func (ro *readOnlyContext) ProviderCompleteness(iaas string) ([]string, error) {
+	return ro.ctx.ProviderCompleteness(iaas)
+}
+
+func (ro *readOnlyContext) GetCloudProvider(iaas string) (provider.CloudProvider, error) {
+	return ro.ctx.GetCloudProvider(iaas)
+}
+
+func (ro *readOnlyContext) SaveCloudProvider(provider provider.CloudProvider) {
+	panic(ErrReadOnly)
+}
+
+func (ro *readOnlyContext) DeleteProvider(iaas string, cascade bool) ([]string, error) {
+	return nil, ErrReadOnly
+}
+
+func (ro *readOnlyContext) CloneProvider(iaas string, overrides map[string]string) (provider.CloudProvider, error) {
+	return ro.ctx.CloneProvider(iaas, overrides)
+}
+
+func (ro *readOnlyContext) PatchProvider(iaas string, patches map[string]string) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) SetProviderExpiry(iaas string, expiresAt time.Time) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) ProviderExpiresAt(iaas string) (time.Time, bool) {
+	return ro.ctx.ProviderExpiresAt(iaas)
+}
+
+func (ro *readOnlyContext) ExpiredProviders() []string {
+	return ro.ctx.ExpiredProviders()
+}
+
+func (ro *readOnlyContext) TestProvider(iaas string) error {
+	return ro.ctx.TestProvider(iaas)
+}
+
+func (ro *readOnlyContext) SetCredentialEnvMapping(iaas string, mapping map[string]string) {
+	panic(ErrReadOnly)
+}
+
+func (ro *readOnlyContext) SetCredentialEnvOverride(override bool) {
+	panic(ErrReadOnly)
+}
+
+func (ro *readOnlyContext) SetProviderDefaultTags(iaas string, tags map[string]string) {
+	panic(ErrReadOnly)
+}
+
+func (ro *readOnlyContext) RegisterValueResolver(fn func(field, value string) (string, error)) {
+	panic(ErrReadOnly)
+}
+
+func (ro *readOnlyContext) ExportProviderEnv(iaas string, w io.Writer, maskSensitive bool) error {
+	return ro.ctx.ExportProviderEnv(iaas, w, maskSensitive)
+}
+
+func (ro *readOnlyContext) CloudBackendTemplates() []backend.CloudBackend {
+	return ro.ctx.CloudBackendTemplates()
+}
+
+func (ro *readOnlyContext) GetCloudBackend(name string) (backend.CloudBackend, error) {
+	return ro.ctx.GetCloudBackend(name)
+}
+
+func (ro *readOnlyContext) SaveCloudBackend(backend backend.CloudBackend) {
+	panic(ErrReadOnly)
+}
+
+func (ro *readOnlyContext) CompatibleProviders(backendType string) ([]provider.CloudProvider, string, error) {
+	return ro.ctx.CompatibleProviders(backendType)
+}
+
+func (ro *readOnlyContext) BackendsInUse() map[string][]*target.Target {
+	return ro.ctx.BackendsInUse()
+}
+
+func (ro *readOnlyContext) TargetsByAccount() map[string][]*target.Target {
+	return ro.ctx.TargetsByAccount()
+}
+
+func (ro *readOnlyContext) BackendPathConflicts() map[string][]*target.Target {
+	return ro.ctx.BackendPathConflicts()
+}
+
+func (ro *readOnlyContext) NewTarget(recipeName, recipeIaas string) (*target.Target, error) {
+	return nil, ErrReadOnly
+}
+
+func (ro *readOnlyContext) NewTargetWithBackend(recipeName, recipeIaas, backendType string) (*target.Target, error) {
+	return nil, ErrReadOnly
+}
+
+func (ro *readOnlyContext) NewTargetWithDefaults(recipeName, recipeIaas string, defaults map[string]string) (*target.Target, error) {
+	return nil, ErrReadOnly
+}
+
+// returns a copy of the underlying context's target
+// set so that it cannot be modified via the target set
+func (ro *readOnlyContext) TargetSet() *target.TargetSet {
+
+	ts, err := ro.ctx.TargetSet().Copy()
+	if err != nil {
+		logger.ErrorMessage("Unable to copy the target set of a read-only context: %s", err.Error())
+		return target.NewTargetSet(ro)
+	}
+	return ts
+}
+
+func (ro *readOnlyContext) HasTarget(name string) bool {
+	return ro.ctx.HasTarget(name)
+}
+
+func (ro *readOnlyContext) GetTarget(name string) (*target.Target, error) {
+	return ro.ctx.GetTarget(name)
+}
+
+func (ro *readOnlyContext) SaveTarget(key string, target *target.Target) {
+	panic(ErrReadOnly)
+}
+
+func (ro *readOnlyContext) UpdateTarget(name string, mutate func(*target.Target) error) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) ValidateTarget(t *target.Target) error {
+	return ro.ctx.ValidateTarget(t)
+}
+
+func (ro *readOnlyContext) MigrateTargetBackend(name, newBackendType string) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) RefreshTargetRecipe(name string) (*target.Target, []string, error) {
+	return ro.ctx.RefreshTargetRecipe(name)
+}
+
+func (ro *readOnlyContext) CloneTarget(srcName, newDeploymentName string) (*target.Target, error) {
+	return nil, ErrReadOnly
+}
+
+func (ro *readOnlyContext) ClearTargetOutput(name string) error {
+	return ErrReadOnly
+}
+
+func (ro *readOnlyContext) ClearAllOutputs() int {
+	panic(ErrReadOnly)
+}
+
+func (ro *readOnlyContext) SyncFrom(remote Context, strategy SyncStrategy) (SyncReport, error) {
+	return SyncReport{}, ErrReadOnly
+}
+
+func (ro *readOnlyContext) ExportTarget(name string, w io.Writer, stripCredentials bool) error {
+	return ro.ctx.ExportTarget(name, w, stripCredentials)
+}
+
+func (ro *readOnlyContext) ImportTarget(r io.Reader) (*target.Target, error) {
+	return nil, ErrReadOnly
+}
+
+func (ro *readOnlyContext) ImportTargetFromOutputs(recipeName, iaas, deploymentName string, outputs map[string]terraform.Output) (*target.Target, error) {
+	return nil, ErrReadOnly
+}
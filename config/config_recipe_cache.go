@@ -0,0 +1,128 @@
+package config
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/mevansam/goforms/config"
+
+	"github.com/appbricks/cloud-builder/cookbook"
+)
+
+// the number of recipes cached by default
+const defaultRecipeCacheSize = 16
+
+// least recently used cache of copies of the cookbook
+// recipes keyed by 'recipe/iaas'. the cached copies are
+// never returned. each lookup returns a new copy of the
+// cached recipe which shares its immutable metadata.
+type recipeCache struct {
+	mx sync.Mutex
+
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type recipeCacheEntry struct {
+	key    string
+	recipe cookbook.Recipe
+}
+
+func newRecipeCache(size int) *recipeCache {
+	return &recipeCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// sets the maximum number of recipes cached by the context.
+// recipes returned by GetCookbookRecipe are copies of the
+// cached recipes so updating a returned recipe does not
+// change the recipes returned by later calls. the cache is
+// cleared when recipes are saved or loaded. recipes updated
+// directly via the context's cookbook are not tracked so
+// the cache should be disabled if the cookbook is modified.
+//
+// in: n - the number of recipes to cache. a size of 0
+//         or less disables the cache.
+func (cc *configContext) SetRecipeCacheSize(n int) {
+	cc.recipeCache.resize(n)
+}
+
+// returns a copy of the recipe cached with the given key
+// or creates one using the given function and caches it
+//
+// in: key - the key of the recipe
+// in: load - function that returns a copy of the recipe
+// out: a copy of the recipe
+func (c *recipeCache) get(key string, load func() (cookbook.Recipe, error)) (cookbook.Recipe, error) {
+
+	var (
+		err error
+
+		r    cookbook.Recipe
+		copy config.Configurable
+	)
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.size <= 0 {
+		return load()
+	}
+	if elem, exists := c.entries[key]; exists {
+		c.order.MoveToFront(elem)
+		r = elem.Value.(*recipeCacheEntry).recipe
+	} else {
+		if r, err = load(); err != nil {
+			return nil, err
+		}
+		c.entries[key] = c.order.PushFront(&recipeCacheEntry{key: key, recipe: r})
+		c.evict()
+	}
+	if copy, err = r.Copy(); err != nil {
+		return nil, err
+	}
+	return copy.(cookbook.Recipe), nil
+}
+
+// removes the recipe with the given key from the cache
+func (c *recipeCache) remove(key string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// removes all recipes from the cache
+func (c *recipeCache) clear() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *recipeCache) resize(size int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.size = size
+	c.evict()
+}
+
+// removes the least recently used recipes until the
+// cache is within its size. the caller must hold the
+// cache's lock.
+func (c *recipeCache) evict() {
+	for c.order.Len() > 0 && c.order.Len() > c.size {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		delete(c.entries, elem.Value.(*recipeCacheEntry).key)
+	}
+}
@@ -0,0 +1,306 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/mevansam/goutils/crypto"
+	"github.com/mevansam/goutils/logger"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// a member of a shared config who can
+// unlock the config with their private key
+type recipient struct {
+	publicKey *[32]byte
+}
+
+// generates a key pair that can be used to add a
+// recipient to a shared configuration
+//
+// out: the public key to add as a recipient
+// out: the private key the recipient will use
+//      to unlock the configuration
+func GenerateRecipientKey() (string, string, error) {
+
+	var (
+		err error
+
+		publicKey, privateKey *[32]byte
+	)
+
+	if publicKey, privateKey, err = box.GenerateKey(rand.Reader); err != nil {
+		return "", "", err
+	}
+	return base64.URLEncoding.EncodeToString(publicKey[:]),
+		base64.URLEncoding.EncodeToString(privateKey[:]),
+		nil
+}
+
+// adds a recipient who will be able to unlock the
+// configuration with the private key of the given
+// public key. the configuration's data key will be
+// encrypted for the recipient when it is next saved.
+//
+// in: pubKey - the public key of the recipient
+// out: the id of the recipient
+func (cf *configFile) AddRecipient(pubKey string) (string, error) {
+
+	var (
+		err error
+
+		publicKey *[32]byte
+	)
+
+	if publicKey, err = decodeRecipientKey(pubKey); err != nil {
+		return "", err
+	}
+	id := recipientID(publicKey)
+	cf.recipients[id] = &recipient{
+		publicKey: publicKey,
+	}
+	cf.dirty = true
+	return id, nil
+}
+
+// removes a recipient from the configuration. the
+// recipient will no longer be able to unlock the
+// configuration once it has been saved.
+//
+// in: id - the id of the recipient to remove
+func (cf *configFile) RemoveRecipient(id string) error {
+
+	if _, exists := cf.recipients[id]; !exists {
+		return fmt.Errorf("recipient '%s' does not exist", id)
+	}
+	delete(cf.recipients, id)
+	cf.dirty = true
+	return nil
+}
+
+// sets the private key used to unlock a configuration
+// that has been shared with multiple recipients
+//
+// in: privKey - the private key of a recipient
+func (cf *configFile) SetIdentity(privKey string) error {
+
+	var (
+		err error
+	)
+
+	cf.identity, err = decodeRecipientKey(privKey)
+	return err
+}
+
+// reads the recipients saved in the config file
+func (cf *configFile) loadRecipients() error {
+
+	var (
+		err error
+
+		publicKey *[32]byte
+	)
+
+	cf.recipients = make(map[string]*recipient)
+	for id := range cf.GetStringMap("recipients") {
+		fields := cf.GetStringMapString("recipients." + id)
+		if publicKey, err = decodeRecipientKey(fields["publickey"]); err != nil {
+			return err
+		}
+		cf.recipients[id] = &recipient{
+			publicKey: publicKey,
+		}
+	}
+	return nil
+}
+
+// encrypts the given data with a new data key and saves
+// the data key encrypted for each recipient. if the
+// config has a passphrase the data key is also saved
+// encrypted with the passphrase so it remains
+// accessible to the owner of the config.
+//
+// in: data - the data to encrypt
+// in: timestamp - the seed for the passphrase key
+// out: the encrypted data
+func (cf *configFile) encryptForRecipients(data string, timestamp int64) (string, error) {
+
+	var (
+		err error
+
+		wrappedKey    string
+		encryptedData string
+
+		crypt *crypto.Crypt
+	)
+
+	dataKey := make([]byte, 32)
+	if _, err = io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	if crypt, err = crypto.NewCrypt(dataKey); err != nil {
+		return "", err
+	}
+	if encryptedData, err = crypt.EncryptB64(data); err != nil {
+		return "", err
+	}
+
+	recipients := make(map[string]interface{})
+	for id, r := range cf.recipients {
+		if wrappedKey, err = wrapDataKey(dataKey, r.publicKey); err != nil {
+			return "", err
+		}
+		recipients[id] = map[string]interface{}{
+			"publickey": base64.URLEncoding.EncodeToString(r.publicKey[:]),
+			"key":       wrappedKey,
+		}
+	}
+	cf.Set("recipients", recipients)
+
+	if len(cf.passphrase) > 0 {
+		if crypt, err = crypto.NewCrypt(
+			cf.passphraseKey(cf.kdfParams, timestamp),
+		); err != nil {
+			return "", err
+		}
+		if wrappedKey, err = crypt.EncryptB64(
+			base64.URLEncoding.EncodeToString(dataKey),
+		); err != nil {
+			return "", err
+		}
+		cf.Set("dataKey", wrappedKey)
+	} else {
+		cf.Set("dataKey", nil)
+	}
+	return encryptedData, nil
+}
+
+// decrypts data that was encrypted for the config's
+// recipients. the data key is unlocked by trying the
+// identity against each recipient's encrypted key and
+// then the passphrase if one has been set.
+//
+// in: data - the data to decrypt
+// out: the decrypted data
+func (cf *configFile) decryptForRecipients(data string) (string, error) {
+
+	var (
+		err error
+		ok  bool
+
+		dataKey    []byte
+		encodedKey string
+
+		crypt *crypto.Crypt
+	)
+
+	if cf.identity != nil {
+		for id := range cf.GetStringMap("recipients") {
+			fields := cf.GetStringMapString("recipients." + id)
+			if dataKey, ok = unwrapDataKey(fields["key"], cf.identity); ok {
+				logger.TraceMessage("Config unlocked by recipient '%s'.", id)
+				break
+			}
+		}
+	}
+	if dataKey == nil && len(cf.passphrase) > 0 {
+		if wrappedKey := cf.GetString("dataKey"); len(wrappedKey) > 0 {
+			if crypt, err = crypto.NewCrypt(
+				cf.passphraseKey(cf.savedKDFParams(), cf.timestamp),
+			); err != nil {
+				return "", err
+			}
+			if encodedKey, err = crypt.DecryptB64(wrappedKey); err == nil {
+				dataKey, err = base64.URLEncoding.DecodeString(encodedKey)
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	if dataKey == nil {
+		return "", fmt.Errorf("the config could not be unlocked by any of its recipients")
+	}
+
+	if crypt, err = crypto.NewCrypt(dataKey); err != nil {
+		return "", err
+	}
+	return crypt.DecryptB64(data)
+}
+
+// encrypts the data key for a recipient using an
+// ephemeral key pair. the ephemeral public key and
+// nonce are prepended to the encrypted key.
+func wrapDataKey(dataKey []byte, publicKey *[32]byte) (string, error) {
+
+	var (
+		err error
+
+		nonce [24]byte
+
+		ephemeralPublicKey, ephemeralPrivateKey *[32]byte
+	)
+
+	if ephemeralPublicKey, ephemeralPrivateKey, err = box.GenerateKey(rand.Reader); err != nil {
+		return "", err
+	}
+	if _, err = io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return "", err
+	}
+
+	wrappedKey := make([]byte, 0, 32+24+len(dataKey)+box.Overhead)
+	wrappedKey = append(wrappedKey, ephemeralPublicKey[:]...)
+	wrappedKey = append(wrappedKey, nonce[:]...)
+	wrappedKey = box.Seal(wrappedKey, dataKey, &nonce, publicKey, ephemeralPrivateKey)
+	return base64.URLEncoding.EncodeToString(wrappedKey), nil
+}
+
+// decrypts a data key encrypted for a recipient
+func unwrapDataKey(wrappedKey string, privateKey *[32]byte) ([]byte, bool) {
+
+	var (
+		err error
+
+		data               []byte
+		nonce              [24]byte
+		ephemeralPublicKey [32]byte
+	)
+
+	if data, err = base64.URLEncoding.DecodeString(wrappedKey); err != nil ||
+		len(data) < 32+24+box.Overhead {
+		return nil, false
+	}
+	copy(ephemeralPublicKey[:], data[:32])
+	copy(nonce[:], data[32:56])
+	return box.Open(nil, data[56:], &nonce, &ephemeralPublicKey, privateKey)
+}
+
+func decodeRecipientKey(key string) (*[32]byte, error) {
+
+	var (
+		err  error
+		data []byte
+
+		k [32]byte
+	)
+
+	if data, err = base64.URLEncoding.DecodeString(key); err != nil {
+		return nil, err
+	}
+	if len(data) != len(k) {
+		return nil, fmt.Errorf("recipient key must be %d bytes", len(k))
+	}
+	copy(k[:], data)
+	return &k, nil
+}
+
+// the id of a recipient is derived
+// from its public key
+func recipientID(publicKey *[32]byte) string {
+	sum := sha256.Sum256(publicKey[:])
+	return hex.EncodeToString(sum[:8])
+}
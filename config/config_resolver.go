@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mevansam/goforms/config"
+	"github.com/mevansam/goforms/forms"
+)
+
+// prefix of provider and backend field values that
+// reference a value kept in an external secret store
+const ValueReferencePrefix = "ref:"
+
+// a field value resolved via the value resolver
+type resolvedValue struct {
+	reference string
+	value     string
+}
+
+// registers a callback that resolves provider and backend
+// field values prefixed with ValueReferencePrefix when the
+// config is loaded. the resolved values are used in memory
+// but the references are saved in their place unless the
+// field is modified after it has been resolved.
+//
+// in: fn - callback that is given the name of the field and
+//          the reference and returns the resolved value
+func (cc *configContext) RegisterValueResolver(fn func(field, value string) (string, error)) {
+	cc.valueResolver = fn
+}
+
+// resolves the referenced field values of the given
+// provider or backend via the value resolver
+//
+// in: section - the section of the provider or backend
+// in: name - the name of the provider or backend
+// in: c - the provider or backend
+func (cc *configContext) resolveValues(section, name string, c config.Configurable) error {
+
+	var (
+		err  error
+		form forms.InputForm
+
+		resolved string
+	)
+
+	key := section + "/" + name
+	delete(cc.resolvedValues, key)
+	if cc.valueResolver == nil {
+		return nil
+	}
+	if form, err = c.InputForm(); err != nil {
+		return err
+	}
+
+	values := make(map[string]resolvedValue)
+	for _, field := range form.InputFields() {
+		value := field.Value()
+		if value == nil || !strings.HasPrefix(*value, ValueReferencePrefix) {
+			continue
+		}
+		if resolved, err = cc.valueResolver(field.Name(), *value); err != nil {
+			return fmt.Errorf(
+				"unable to resolve the value of field '%s' of '%s': %w",
+				field.Name(), key, err)
+		}
+		values[field.Name()] = resolvedValue{
+			reference: *value,
+			value:     resolved,
+		}
+		if err = field.SetValue(&resolved); err != nil {
+			return err
+		}
+	}
+	if len(values) > 0 {
+		cc.resolvedValues[key] = values
+	}
+	return nil
+}
+
+// returns the provider or backend to save in place of the
+// given provider or backend. if any of its values were
+// resolved or set from the environment a copy is returned
+// with the references of the values and the values of the
+// fields set from the environment that have not been
+// modified since they were resolved or set.
+//
+// in: section - the section of the provider or backend
+// in: name - the name of the provider or backend
+// in: c - the provider or backend
+func (cc *configContext) unresolvedValues(section, name string, c config.Configurable) (config.Configurable, error) {
+
+	var (
+		err   error
+		copy  config.Configurable
+		form  forms.InputForm
+		field *forms.InputField
+
+		injected map[string]injectedCredential
+	)
+
+	values := cc.resolvedValues[section+"/"+name]
+	if section == "providers" {
+		injected = cc.injectedCredentials[name]
+	}
+	if len(values) == 0 && len(injected) == 0 {
+		return c, nil
+	}
+	if copy, err = c.Copy(); err != nil {
+		return nil, err
+	}
+	if form, err = copy.InputForm(); err != nil {
+		return nil, err
+	}
+	// fields set from the environment are restored
+	// first as their original values may be resolved
+	for fieldName, ic := range injected {
+		if field, err = form.GetInputField(fieldName); err != nil {
+			return nil, err
+		}
+		if value := field.Value(); value != nil && *value == ic.value {
+			if err = field.SetValue(ic.original); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for fieldName, rv := range values {
+		if field, err = form.GetInputField(fieldName); err != nil {
+			return nil, err
+		}
+		if value := field.Value(); value != nil && *value == rv.value {
+			reference := rv.reference
+			if err = field.SetValue(&reference); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return copy, nil
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mevansam/goutils/logger"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v2"
+
+	"github.com/appbricks/cloud-builder/cookbook"
+)
+
+// persistent storage of the serialized config settings
+// which include the encrypted config context. stores
+// are responsible for serializing concurrent writes.
+type Store interface {
+	// returns the saved config settings or
+	// nil if the settings have not been saved
+	Read() ([]byte, error)
+	// replaces the saved config settings
+	Write(data []byte) error
+}
+
+// implemented by stores that record the time the config
+// settings were last written. the timestamp seeds the
+// encryption keys so stores that do not record it have
+// the timestamp saved with the config settings.
+type timestampedStore interface {
+	Timestamp() (time.Time, error)
+	SetTimestamp(timestamp time.Time) error
+}
+
+// store that saves the config settings to a file. the
+// file's modification time is used as the timestamp.
+type fileStore struct {
+	path string
+	fs   afero.Fs
+}
+
+// initializes configuration saved to the given store. the
+// config settings are saved to the store as YAML.
+//
+// in: store - the store the config is saved to
+// in: cookbook - the embedded cookbook the config should be
+//                assciated with
+// in: passphrase - callback to get the passphrase that will be
+//                  used for encrytion of sensitive information
+// out: a Config instance containing the global
+//      configuration for CloudBuilder
+func InitStoreConfig(
+	store Store,
+	cookbook *cookbook.Cookbook,
+	getPassphrase GetPassphrase,
+) (Config, error) {
+	return initConfig(store, "yaml", "", cookbook, getPassphrase)
+}
+
+// returns a store that saves the
+// config to the file with the given path
+func NewFileStore(path string) Store {
+	return &fileStore{
+		path: path,
+		fs:   afero.NewOsFs(),
+	}
+}
+
+func (fs *fileStore) Read() ([]byte, error) {
+
+	data, err := afero.ReadFile(fs.fs, fs.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// writes the data to a temporary file and moves it over
+// the config file once it has been completely written
+func (fs *fileStore) Write(data []byte) error {
+
+	var (
+		err error
+
+		tmpFile afero.File
+	)
+
+	configDir := filepath.Dir(fs.path)
+	if err = fs.fs.MkdirAll(configDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// the temporary file is created with 0600 permissions
+	// as the config may contain cloud credentials
+	if tmpFile, err = afero.TempFile(
+		fs.fs, configDir,
+		"."+filepath.Base(fs.path)+".",
+	); err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		fs.fs.Remove(tmpPath)
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		fs.fs.Remove(tmpPath)
+		return err
+	}
+	if err = fs.fs.Rename(tmpPath, fs.path); err != nil {
+		fs.fs.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func (fs *fileStore) Timestamp() (time.Time, error) {
+
+	fileInfo, err := fs.fs.Stat(fs.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fileInfo.ModTime(), nil
+}
+
+func (fs *fileStore) SetTimestamp(timestamp time.Time) error {
+	return fs.fs.Chtimes(fs.path, timestamp, timestamp)
+}
+
+// sets the file system the config is read from and
+// written to. this is only used by file configs.
+func (cf *configFile) SetFs(fs afero.Fs) {
+	cf.Viper.SetFs(fs)
+	if store, ok := cf.store.(*fileStore); ok {
+		store.fs = fs
+	}
+}
+
+// returns the config settings
+// serialized as the config type
+func (cf *configFile) marshalSettings() ([]byte, error) {
+
+	switch cf.configType {
+	case "yaml", "yml":
+		return yaml.Marshal(cf.AllSettings())
+	case "json":
+		return json.MarshalIndent(cf.AllSettings(), "", "  ")
+	}
+	return nil, fmt.Errorf("unsupported config type '%s'", cf.configType)
+}
+
+// reads the config settings from the config's store
+func (cf *configFile) readSettings() error {
+
+	var (
+		err  error
+		data []byte
+	)
+
+	if data, err = cf.store.Read(); err != nil {
+		return err
+	}
+	if data == nil {
+		// save empty settings so
+		// the timestamp is recorded
+		if err = cf.writeSettings(); err != nil {
+			return err
+		}
+		logger.TraceMessage("Creating empty config: %s", cf.storeName())
+		if data, err = cf.store.Read(); err != nil {
+			return err
+		}
+	}
+	return cf.ReadConfig(bytes.NewReader(data))
+}
+
+// writes the config settings to the config's store
+func (cf *configFile) writeSettings() error {
+
+	data, err := cf.marshalSettings()
+	if err != nil {
+		return err
+	}
+	return cf.store.Write(data)
+}
+
+// returns the timestamp the config settings
+// were saved with which seeds the encryption
+// keys
+func (cf *configFile) storeTimestamp() (int64, error) {
+
+	if store, ok := cf.store.(timestampedStore); ok {
+		timestamp, err := store.Timestamp()
+		if err != nil {
+			return 0, err
+		}
+		return timestamp.UnixNano(), nil
+	}
+	return cf.GetInt64("timestamp"), nil
+}
+
+// returns the name of the config's store for logging
+func (cf *configFile) storeName() string {
+	if len(cf.path) > 0 {
+		return cf.path
+	}
+	return fmt.Sprintf("%T", cf.store)
+}
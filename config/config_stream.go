@@ -0,0 +1,254 @@
+package config
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// the serialized context is encrypted as a sequence of
+// chunks each of which is sealed with AES-GCM. the nonce
+// of each chunk is made up of a random prefix, the index
+// of the chunk and a flag that is set for the last chunk.
+// each chunk is authenticated as it is decrypted and the
+// nonces ensure chunks cannot be reordered, dropped or
+// truncated without the decryption failing.
+const (
+	streamVersion    = 1
+	streamChunkSize  = 64 * 1024
+	streamPrefixSize = 7
+)
+
+// writer that encrypts the data written to
+// it as a stream of authenticated chunks
+type streamEncrypter struct {
+	aead   cipher.AEAD
+	aad    []byte
+	w      io.Writer
+	prefix []byte
+	index  uint32
+	buffer []byte
+}
+
+// reader that decrypts and authenticates a
+// stream of chunks written by streamEncrypter
+type streamDecrypter struct {
+	aead   cipher.AEAD
+	aad    []byte
+	r      *bufio.Reader
+	prefix []byte
+	index  uint32
+	chunk  []byte
+	plain  []byte
+	last   bool
+}
+
+// encrypts the given data as a base64 encoded stream
+//
+// in: key - the encryption key
+// in: aad - additional data authenticated with each chunk
+// in: data - the data to encrypt
+// out: the encrypted data
+func encryptStream(key, aad []byte, data string) (string, error) {
+
+	var (
+		err error
+
+		output    strings.Builder
+		encrypter *streamEncrypter
+	)
+
+	encoder := base64.NewEncoder(base64.URLEncoding, &output)
+	if encrypter, err = newStreamEncrypter(key, aad, encoder); err != nil {
+		return "", err
+	}
+	if _, err = io.WriteString(encrypter, data); err != nil {
+		return "", err
+	}
+	if err = encrypter.Close(); err != nil {
+		return "", err
+	}
+	if err = encoder.Close(); err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
+
+// returns a reader that decrypts the given base64 encoded
+// stream as it is read so that the decrypted data is never
+// held in memory in its entirety. reads return an error as
+// soon as a chunk of the stream fails authentication.
+//
+// in: key - the encryption key
+// in: aad - additional data the stream was encrypted with
+// in: data - the encrypted data
+// out: a reader of the decrypted data
+func decryptStream(key, aad []byte, data string) (io.Reader, error) {
+	return newStreamDecrypter(
+		key, aad,
+		base64.NewDecoder(base64.URLEncoding, strings.NewReader(data)),
+	)
+}
+
+func newStreamAEAD(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newStreamEncrypter(key, aad []byte, w io.Writer) (*streamEncrypter, error) {
+
+	var (
+		err  error
+		aead cipher.AEAD
+	)
+
+	if aead, err = newStreamAEAD(key); err != nil {
+		return nil, err
+	}
+	se := &streamEncrypter{
+		aead:   aead,
+		aad:    aad,
+		w:      w,
+		prefix: make([]byte, streamPrefixSize),
+		buffer: make([]byte, 0, streamChunkSize),
+	}
+	if _, err = io.ReadFull(rand.Reader, se.prefix); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(append([]byte{streamVersion}, se.prefix...)); err != nil {
+		return nil, err
+	}
+	return se, nil
+}
+
+func (se *streamEncrypter) Write(p []byte) (int, error) {
+
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data is
+		// written as the last chunk is sealed on close
+		if len(se.buffer) == streamChunkSize {
+			if err := se.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(se.buffer[len(se.buffer):streamChunkSize], p)
+		se.buffer = se.buffer[:len(se.buffer)+n]
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// seals the remaining data as the last chunk
+func (se *streamEncrypter) Close() error {
+	return se.seal(true)
+}
+
+func (se *streamEncrypter) seal(last bool) error {
+
+	sealed := se.aead.Seal(nil, streamNonce(se.prefix, se.index, last), se.buffer, se.aad)
+	if _, err := se.w.Write(sealed); err != nil {
+		return err
+	}
+	se.index++
+	se.buffer = se.buffer[:0]
+	return nil
+}
+
+func newStreamDecrypter(key, aad []byte, r io.Reader) (*streamDecrypter, error) {
+
+	var (
+		err    error
+		aead   cipher.AEAD
+		header []byte
+	)
+
+	if aead, err = newStreamAEAD(key); err != nil {
+		return nil, err
+	}
+	header = make([]byte, 1+streamPrefixSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != streamVersion {
+		return nil, fmt.Errorf("unsupported encrypted stream version %d", header[0])
+	}
+	return &streamDecrypter{
+		aead:   aead,
+		aad:    aad,
+		r:      bufio.NewReader(r),
+		prefix: header[1:],
+		chunk:  make([]byte, streamChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (sd *streamDecrypter) Read(p []byte) (int, error) {
+
+	for len(sd.plain) == 0 {
+		if sd.last {
+			return 0, io.EOF
+		}
+		if err := sd.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sd.plain)
+	sd.plain = sd.plain[n:]
+	return n, nil
+}
+
+// reads and authenticates the next chunk
+func (sd *streamDecrypter) open() error {
+
+	var (
+		err error
+		n   int
+	)
+
+	n, err = io.ReadFull(sd.r, sd.chunk)
+	switch err {
+	case nil:
+		// a full chunk is the last
+		// chunk if no data follows it
+		if _, err = sd.r.Peek(1); err == io.EOF {
+			sd.last = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		sd.last = true
+	default:
+		return err
+	}
+
+	if sd.plain, err = sd.aead.Open(
+		sd.chunk[:0], streamNonce(sd.prefix, sd.index, sd.last), sd.chunk[:n], sd.aad,
+	); err != nil {
+		return err
+	}
+	sd.index++
+	return nil
+}
+
+// returns the nonce of the chunk with the given index
+func streamNonce(prefix []byte, index uint32, last bool) []byte {
+
+	nonce := make([]byte, streamPrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"sort"
+
+	"github.com/mevansam/gocloud/backend"
+	"github.com/mevansam/gocloud/provider"
+	"github.com/mevansam/goforms/config"
+
+	"github.com/appbricks/cloud-builder/target"
+)
+
+// Sync conflict resolution strategies
+type SyncStrategy int
+
+const (
+	// keep the local element
+	LocalWins SyncStrategy = iota
+	// replace the local element with the remote element
+	RemoteWins
+	// keep the element that was saved last. providers
+	// and backends do not have timestamps so the local
+	// provider or backend is kept.
+	NewestWins
+)
+
+// the elements changed by a sync. elements are named by
+// their type and name i.e. 'provider/aws', 'backend/s3'
+// and 'target/<key>'.
+type SyncReport struct {
+	// remote elements added to the local context
+	Added []string
+	// local elements replaced by the remote element
+	Updated []string
+	// local elements that differ from the remote
+	// element but were kept by the strategy
+	Conflicted []string
+}
+
+// reconciles this context with a remote context. elements of
+// the remote context that do not exist in this context are
+// added and elements that exist in both contexts but differ
+// are resolved using the given strategy. elements that exist
+// only in this context are not removed. targets are paired
+// using the keys of this context's target set.
+//
+// in: remote - the context to sync from
+// in: strategy - how to resolve elements that differ
+// out: the elements changed by the sync
+func (cc *configContext) SyncFrom(remote Context, strategy SyncStrategy) (SyncReport, error) {
+
+	var (
+		err      error
+		fields   []string
+		elemCopy config.Configurable
+
+		cpLocal provider.CloudProvider
+		cbLocal backend.CloudBackend
+
+		tgtLocal, tgtCopy *target.Target
+	)
+
+	report := SyncReport{
+		Added:      []string{},
+		Updated:    []string{},
+		Conflicted: []string{},
+	}
+
+	for _, cpRemote := range remote.CloudProviderTemplates() {
+		name := "provider/" + cpRemote.Name()
+		if cpLocal, err = cc.GetCloudProvider(cpRemote.Name()); err == nil {
+			if fields, err = diffFields(cpLocal, cpRemote, ""); err != nil {
+				return report, err
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			if strategy != RemoteWins {
+				report.Conflicted = append(report.Conflicted, name)
+				continue
+			}
+			report.Updated = append(report.Updated, name)
+		} else {
+			report.Added = append(report.Added, name)
+		}
+		if elemCopy, err = cpRemote.Copy(); err != nil {
+			return report, err
+		}
+		cc.SaveCloudProvider(elemCopy.(provider.CloudProvider))
+	}
+
+	for _, cbRemote := range remote.CloudBackendTemplates() {
+		name := "backend/" + cbRemote.Name()
+		if cbLocal, err = cc.GetCloudBackend(cbRemote.Name()); err == nil {
+			if fields, err = diffFields(cbLocal, cbRemote, ""); err != nil {
+				return report, err
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			if strategy != RemoteWins {
+				report.Conflicted = append(report.Conflicted, name)
+				continue
+			}
+			report.Updated = append(report.Updated, name)
+		} else {
+			report.Added = append(report.Added, name)
+		}
+		if elemCopy, err = cbRemote.Copy(); err != nil {
+			return report, err
+		}
+		cc.SaveCloudBackend(elemCopy.(backend.CloudBackend))
+	}
+
+	remoteTargets := remote.TargetSet().GetTargets()
+	keys := make(map[*target.Target]string, len(remoteTargets))
+	for _, tgtRemote := range remoteTargets {
+		keys[tgtRemote] = cc.targets.KeyOf(tgtRemote)
+	}
+	sort.Slice(remoteTargets, func(i, j int) bool {
+		return keys[remoteTargets[i]] < keys[remoteTargets[j]]
+	})
+
+	for _, tgtRemote := range remoteTargets {
+		key := keys[tgtRemote]
+		name := "target/" + key
+		if tgtLocal = cc.targets.GetTarget(key); tgtLocal != nil {
+			if tgtLocal.Equal(tgtRemote) {
+				continue
+			}
+			if strategy == LocalWins ||
+				(strategy == NewestWins && !tgtRemote.UpdatedAt().After(tgtLocal.UpdatedAt())) {
+				report.Conflicted = append(report.Conflicted, name)
+				continue
+			}
+			report.Updated = append(report.Updated, name)
+		} else {
+			report.Added = append(report.Added, name)
+		}
+		if tgtCopy, err = tgtRemote.Copy(); err != nil {
+			return report, err
+		}
+		cc.SaveTarget(key, tgtCopy)
+	}
+
+	return report, nil
+}
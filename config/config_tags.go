@@ -0,0 +1,39 @@
+package config
+
+import (
+	"github.com/appbricks/cloud-builder/target"
+)
+
+// sets the tags added to targets created for the provider of
+// the given iaas. the tags are added when the target is created
+// so tags set on the target afterwards take precedence over the
+// defaults. the defaults apply to targets created with this
+// context and are not saved with the config.
+//
+// in: iaas - the name of the provider
+// in: tags - map of tag keys to values. an empty map
+//            clears the provider's default tags
+func (cc *configContext) SetProviderDefaultTags(iaas string, tags map[string]string) {
+
+	if len(tags) == 0 {
+		delete(cc.providerDefaultTags, iaas)
+		return
+	}
+	t := make(map[string]string)
+	for key, value := range tags {
+		t[key] = value
+	}
+	cc.providerDefaultTags[iaas] = t
+}
+
+// adds the default tags of the target's provider to
+// the tags of the target. tags already set on the
+// target are not replaced.
+func (cc *configContext) applyProviderDefaultTags(tgt *target.Target) {
+
+	for key, value := range cc.providerDefaultTags[tgt.Provider.Name()] {
+		if _, exists := tgt.Tags[key]; !exists {
+			tgt.Tags[key] = value
+		}
+	}
+}
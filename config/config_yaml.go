@@ -0,0 +1,113 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loads the cloud configuration from the given YAML stream.
+// the YAML document has the same structure as the JSON
+// document read by Load.
+func (cc *configContext) LoadYAML(input io.Reader) error {
+
+	var (
+		err error
+
+		data      []byte
+		yamlValue interface{}
+		jsonValue interface{}
+	)
+
+	if data, err = ioutil.ReadAll(input); err != nil {
+		return err
+	}
+	if err = yaml.Unmarshal(data, &yamlValue); err != nil {
+		return err
+	}
+	if yamlValue == nil {
+		// empty document
+		return nil
+	}
+	if jsonValue, err = yamlToJSONValue(yamlValue); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(jsonValue); err != nil {
+		return err
+	}
+	return cc.Load(bytes.NewReader(data))
+}
+
+// saves the cloud configuration to the given stream as a
+// YAML document having the same structure as the JSON
+// document written by Save.
+func (cc *configContext) SaveYAML(output io.Writer) error {
+
+	var (
+		err error
+
+		jsonOutput bytes.Buffer
+		jsonValue  interface{}
+		data       []byte
+	)
+
+	if err = cc.Save(&jsonOutput); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(jsonOutput.Bytes(), &jsonValue); err != nil {
+		return err
+	}
+	// the section index is only
+	// valid for the JSON document
+	if root, ok := jsonValue.(map[string]interface{}); ok {
+		delete(root, sectionIndexKey)
+	}
+	if data, err = yaml.Marshal(jsonValue); err != nil {
+		return err
+	}
+	_, err = output.Write(data)
+	return err
+}
+
+// converts a value unmarshalled from YAML to one that
+// can be marshalled as JSON. YAML maps are unmarshalled
+// with interface{} keys which need to be converted to
+// string keys.
+func yamlToJSONValue(value interface{}) (interface{}, error) {
+
+	var (
+		err error
+		ok  bool
+		key string
+	)
+
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for k, vv := range v {
+			if key, ok = k.(string); !ok {
+				return nil, fmt.Errorf(
+					"config key '%v' is not a string",
+					k)
+			}
+			if m[key], err = yamlToJSONValue(vv); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, vv := range v {
+			if l[i], err = yamlToJSONValue(vv); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	}
+	return value, nil
+}
@@ -11,11 +11,15 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/gobuffalo/packr/v2"
 	"github.com/mevansam/gocloud/provider"
+	"github.com/mevansam/goforms/config"
 	"github.com/mevansam/goutils/logger"
 	"github.com/mevansam/goutils/utils"
+
+	"github.com/appbricks/cloud-builder/internal/jsonenc"
 )
 
 const (
@@ -27,8 +31,37 @@ type Cookbook struct {
 	path  string
 	files []string
 
+	// the modification time of the cookbook
+	// archive in seconds since the epoch
+	timestamp string
+
 	// nested map [recipe_name][iaas_name]
 	recipes map[string]map[string]Recipe
+
+	// each recipe is parsed when it is first
+	// accessed from the recipe paths found when
+	// the cookbook was extracted
+	recipesMx     sync.Mutex
+	recipesLoaded bool
+	recipesErr    error
+	sources       []*recipeSource
+
+	workspacePath,
+	tfPluginPath,
+	tfCLIPath string
+}
+
+// location of a recipe in the cookbook
+type recipeSource struct {
+	name, iaas string
+
+	// the recipe's path relative to the cookbook
+	pathSuffix string
+
+	// whether the recipe has been parsed
+	// and the error parsing it
+	loaded bool
+	err    error
 }
 
 type CookbookRecipeInfo struct {
@@ -52,14 +85,10 @@ func NewCookbook(
 
 	var (
 		err error
-		ok  bool
 
-		cookbookTimestamp,
-		tfPluginPath,
-		tfCLIPath string
+		cookbookTimestamp string
 
 		c *Cookbook
-		r Recipe
 	)
 
 	if cookbookTimestamp, err = box.FindString(cookbookModTime); err != nil {
@@ -68,45 +97,30 @@ func NewCookbook(
 	cookbookTimestamp = strings.Trim(cookbookTimestamp, "\n")
 
 	c = &Cookbook{
-		path:    filepath.Join(workspacePath, "cookbook", cookbookTimestamp),
-		recipes: make(map[string]map[string]Recipe),
+		path:      filepath.Join(workspacePath, "cookbook", cookbookTimestamp),
+		timestamp: cookbookTimestamp,
+		recipes:   make(map[string]map[string]Recipe),
+
+		workspacePath: workspacePath,
 	}
 
-	// Updates cookbook metadata
+	// Updates cookbook metadata. recipes are
+	// only parsed when they are first accessed.
 	addMetadata := func(file string) error {
 
 		var (
 			match bool
-			rr    map[string]Recipe
 		)
 
 		if match = recipePathMatcher.Match([]byte(file)); match {
 
 			elems := strings.Split(file, filePathSeparator)
 			if len(elems) >= 4 {
-				pathSuffix := filepath.Join(elems[0], elems[1], elems[2])
-
-				name := elems[1]
-				iaas := elems[2]
-
-				if rr, ok = c.recipes[name]; !ok {
-					rr = make(map[string]Recipe)
-					c.recipes[name] = rr
-				}
-				if r, err = NewRecipe(
-					name,
-					iaas,
-					filepath.Join(c.path, pathSuffix),
-					tfPluginPath,
-					tfCLIPath,
-					filepath.Join(workspacePath, "run", pathSuffix),
-					cookbookTimestamp,
-				); err != nil {
-					return err
-				}
-
-				logger.TraceMessage("Initialized recipe: %#v\n", r)
-				rr[iaas] = r
+				c.sources = append(c.sources, &recipeSource{
+					name:       elems[1],
+					iaas:       elems[2],
+					pathSuffix: filepath.Join(elems[0], elems[1], elems[2]),
+				})
 			}
 		}
 		return nil
@@ -128,16 +142,16 @@ func NewCookbook(
 	if info.IsDir() {
 
 		// embedded cookbook plugin path
-		tfPluginPath = filepath.Join(
+		c.tfPluginPath = filepath.Join(
 			c.path, "bin", "plugins",
 			fmt.Sprintf("%s_%s", runtime.GOOS, runtime.GOARCH),
 		)
 		if runtime.GOOS == "windows" {
 			// windows cli
-			tfCLIPath = filepath.Join(c.path, "bin", "terraform.exe")
+			c.tfCLIPath = filepath.Join(c.path, "bin", "terraform.exe")
 		} else {
 			// *nix cli
-			tfCLIPath = filepath.Join(c.path, "bin", "terraform")
+			c.tfCLIPath = filepath.Join(c.path, "bin", "terraform")
 		}
 
 		// Retrieve cookbook file list by walking
@@ -173,12 +187,145 @@ func NewCookbook(
 	return c, nil
 }
 
+// parses the cookbook's recipes that have not been parsed
+// the first time it is called. recipes are not parsed when
+// the cookbook is created as most commands only access a
+// single recipe.
+//
+// out: the error parsing the recipes
+func (c *Cookbook) loadRecipes() error {
+	c.recipesMx.Lock()
+	defer c.recipesMx.Unlock()
+
+	if c.recipesLoaded {
+		return c.recipesErr
+	}
+	c.recipesLoaded = true
+
+	for _, src := range c.sources {
+		if c.recipesErr = c.loadRecipeSource(src); c.recipesErr != nil {
+			return c.recipesErr
+		}
+	}
+	return nil
+}
+
+// parses the given recipe the first time it is accessed.
+// the other recipes of the cookbook are not parsed.
+//
+// in: recipe - the name of the recipe
+// in: iaas - the name of the recipe's iaas
+// out: the error parsing the recipe
+func (c *Cookbook) loadRecipe(recipe, iaas string) error {
+	c.recipesMx.Lock()
+	defer c.recipesMx.Unlock()
+
+	for _, src := range c.sources {
+		if src.name == recipe && src.iaas == iaas {
+			return c.loadRecipeSource(src)
+		}
+	}
+	return nil
+}
+
+// parses the recipe at the given source and adds it to
+// the cookbook unless it has already been parsed. the
+// caller must hold the recipe lock.
+//
+// in: src - the location of the recipe
+// out: the error parsing the recipe
+func (c *Cookbook) loadRecipeSource(src *recipeSource) error {
+
+	var (
+		ok bool
+
+		rr map[string]Recipe
+		r  Recipe
+	)
+
+	if src.loaded {
+		return src.err
+	}
+	src.loaded = true
+
+	if r, src.err = NewRecipe(
+		src.name,
+		src.iaas,
+		filepath.Join(c.path, src.pathSuffix),
+		c.tfPluginPath,
+		c.tfCLIPath,
+		filepath.Join(c.workspacePath, "run", src.pathSuffix),
+		c.timestamp,
+	); src.err != nil {
+		logger.ErrorMessage(
+			"Unable to load recipe '%s/%s' from cookbook: %s",
+			src.name, src.iaas, src.err.Error())
+		return src.err
+	}
+
+	logger.TraceMessage("Initialized recipe: %#v\n", r)
+	if rr, ok = c.recipes[src.name]; !ok {
+		rr = make(map[string]Recipe)
+		c.recipes[src.name] = rr
+	}
+	rr[src.iaas] = r
+	return nil
+}
+
+// returns the error parsing the cookbook's recipes. as
+// the recipes are parsed when they are first accessed the
+// getters that do not return errors return no recipes if
+// the recipes could not be parsed. callers that need to
+// distinguish a missing recipe from this error should
+// check it.
+//
+// out: the error parsing the recipes
+func (c *Cookbook) Err() error {
+	return c.loadRecipes()
+}
+
+// returns the error parsing the given recipe. unlike
+// Err only the given recipe is parsed if it has not
+// been parsed.
+//
+// in: recipe - the name of the recipe
+// in: iaas - the name of the recipe's iaas
+// out: the error parsing the recipe
+func (c *Cookbook) RecipeErr(recipe, iaas string) error {
+	return c.loadRecipe(recipe, iaas)
+}
+
+// out: true if all the cookbook's recipes have been parsed
+func (c *Cookbook) RecipesLoaded() bool {
+	c.recipesMx.Lock()
+	defer c.recipesMx.Unlock()
+
+	return c.recipesLoaded
+}
+
+// out: true if the given recipe has been parsed
+func (c *Cookbook) RecipeLoaded(recipe, iaas string) bool {
+	c.recipesMx.Lock()
+	defer c.recipesMx.Unlock()
+
+	for _, src := range c.sources {
+		if src.name == recipe && src.iaas == iaas {
+			return src.loaded
+		}
+	}
+	return false
+}
+
 func (c *Cookbook) Validate() error {
 
 	var (
 		err error
 	)
 
+	if err = c.loadRecipes(); err != nil {
+		return err
+	}
+
 	// Validate files in cookbook
 	for _, f := range c.files {
 
@@ -224,7 +371,61 @@ func (c *Cookbook) Validate() error {
 	return nil
 }
 
+// returns a copy of the cookbook whose recipes are copies
+// of this cookbook's recipes so that changes made to the
+// copy do not change this cookbook
+//
+// out: the copy of the cookbook
+func (c *Cookbook) Copy() (*Cookbook, error) {
+
+	var (
+		err  error
+		copy config.Configurable
+	)
+
+	if err = c.loadRecipes(); err != nil {
+		return nil, err
+	}
+	c.recipesMx.Lock()
+	defer c.recipesMx.Unlock()
+
+	cookbookCopy := &Cookbook{
+		path:      c.path,
+		files:     c.files,
+		timestamp: c.timestamp,
+		recipes:   make(map[string]map[string]Recipe),
+
+		recipesLoaded: true,
+		sources:       make([]*recipeSource, 0, len(c.sources)),
+
+		workspacePath: c.workspacePath,
+		tfPluginPath:  c.tfPluginPath,
+		tfCLIPath:     c.tfCLIPath,
+	}
+	for _, src := range c.sources {
+		srcCopy := *src
+		cookbookCopy.sources = append(cookbookCopy.sources, &srcCopy)
+	}
+	for name, rr := range c.recipes {
+		rrCopy := make(map[string]Recipe)
+		for iaas, r := range rr {
+			if copy, err = r.Copy(); err != nil {
+				return nil, err
+			}
+			rrCopy[iaas] = copy.(Recipe)
+		}
+		cookbookCopy.recipes[name] = rrCopy
+	}
+	return cookbookCopy, nil
+}
+
+// out: the version timestamp of the cookbook
+func (c *Cookbook) Timestamp() string {
+	return c.timestamp
+}
+
 func (c *Cookbook) IaaSList() []provider.CloudProvider {
+	c.loadRecipes()
 
 	iaasSet := make(map[string]provider.CloudProvider)
 	for _, rr := range c.recipes {
@@ -252,6 +453,7 @@ func (c *Cookbook) RecipeList() []CookbookRecipeInfo {
 
 		recipeInfo CookbookRecipeInfo
 	)
+	c.loadRecipes()
 
 	recipeInfos := make([]CookbookRecipeInfo, 0, len(c.recipes))
 	l := 0
@@ -290,6 +492,10 @@ func (c *Cookbook) HasRecipe(recipe, iaas string) bool {
 		ok bool
 		rr map[string]Recipe
 	)
+	c.loadRecipe(recipe, iaas)
+
+	c.recipesMx.Lock()
+	defer c.recipesMx.Unlock()
 
 	if rr, ok = c.recipes[recipe]; ok {
 		_, ok = rr[iaas]
@@ -304,6 +510,10 @@ func (c *Cookbook) GetRecipe(recipe, iaas string) Recipe {
 		rr map[string]Recipe
 		r  Recipe
 	)
+	c.loadRecipe(recipe, iaas)
+
+	c.recipesMx.Lock()
+	defer c.recipesMx.Unlock()
 
 	r = nil
 	if rr, ok = c.recipes[recipe]; ok {
@@ -321,6 +531,16 @@ func (c *Cookbook) SetRecipe(recipe Recipe) {
 
 	nameElements := strings.Split(recipe.Name(), "/")
 
+	// the recipe replaces the cookbook's
+	// recipe which no longer needs parsing
+	c.recipesMx.Lock()
+	defer c.recipesMx.Unlock()
+
+	for _, src := range c.sources {
+		if src.name == nameElements[0] && src.iaas == nameElements[1] {
+			src.loaded, src.err = true, nil
+		}
+	}
 	if rr, ok = c.recipes[nameElements[0]]; !ok {
 		rr = make(map[string]Recipe)
 		c.recipes[nameElements[0]] = rr
@@ -429,6 +649,9 @@ func (c *Cookbook) readRecipeIaaSConfigs(recipeName string, decoder *json.Decode
 		switch t := token.(type) {
 
 		case string:
+			if err = c.loadRecipe(recipeName, t); err != nil {
+				return err
+			}
 			if r = c.GetRecipe(recipeName, t); r == nil {
 				return fmt.Errorf(
 					"recipe '%s' for IaaS '%s' was not found",
@@ -452,33 +675,54 @@ func (c *Cookbook) MarshalJSON() ([]byte, error) {
 		out            bytes.Buffer
 		first1, first2 bool
 	)
-	encoder := json.NewEncoder(&out)
+	if err = c.loadRecipes(); err != nil {
+		return nil, err
+	}
+	encoder := jsonenc.NewEncoder(&out)
 
 	out.WriteRune('[')
 	first1 = true
 
-	for name, rr := range c.recipes {
+	// recipes are written in name and iaas
+	// order so the output is deterministic
+	names := make([]string, 0, len(c.recipes))
+	for name := range c.recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rr := c.recipes[name]
 		if first1 {
 			first1 = false
 		} else {
 			out.WriteRune(',')
 		}
 
-		out.WriteString("{\"name\":\"")
-		out.WriteString(name)
-		out.WriteString("\",\"config\":{")
+		out.WriteString("{\"name\":")
+		if err = encoder.Encode(name); err != nil {
+			return nil, err
+		}
+		out.WriteString(",\"config\":{")
 		first2 = true
 
-		for iaas, r := range rr {
+		iaasNames := make([]string, 0, len(rr))
+		for iaas := range rr {
+			iaasNames = append(iaasNames, iaas)
+		}
+		sort.Strings(iaasNames)
+
+		for _, iaas := range iaasNames {
+			r := rr[iaas]
 			if first2 {
 				first2 = false
 			} else {
 				out.WriteRune(',')
 			}
 
-			out.WriteRune('"')
-			out.WriteString(iaas)
-			out.WriteString("\":")
+			if err = encoder.WriteKey(iaas); err != nil {
+				return nil, err
+			}
 			if err = encoder.Encode(r); err != nil {
 				return nil, err
 			}
@@ -3,6 +3,7 @@ package cookbook_test
 import (
 	"encoding/json"
 	"strings"
+	"time"
 
 	"github.com/gobuffalo/packr/v2"
 
@@ -85,6 +86,60 @@ var _ = Describe("Cookbook", func() {
 		})
 	})
 
+	Describe("Cookbook Loading", func() {
+
+		It("parses recipes when they are first accessed", func() {
+
+			cookbookDistPath := workspacePath + "/dist"
+			box := packr.New(cookbookDistPath, cookbookDistPath)
+
+			// the cookbook has already been extracted so creating
+			// it only lists its files. the recipes are parsed by
+			// the first getter that accesses them.
+			c, err = cookbook.NewCookbook(box, workspacePath, &outputBuffer, &errorBuffer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.RecipesLoaded()).To(BeFalse())
+
+			// only the accessed recipe is parsed
+			Expect(c.HasRecipe("basic", "aws")).To(BeTrue())
+			Expect(c.RecipeLoaded("basic", "aws")).To(BeTrue())
+			Expect(c.RecipeLoaded("basic", "google")).To(BeFalse())
+			Expect(c.RecipesLoaded()).To(BeFalse())
+
+			Expect(c.GetRecipe("basic", "google")).NotTo(BeNil())
+			Expect(c.RecipeLoaded("basic", "google")).To(BeTrue())
+			Expect(c.GetRecipe("basic", "azure")).To(BeNil())
+
+			Expect(c.Err()).To(Succeed())
+			Expect(c.RecipesLoaded()).To(BeTrue())
+			Expect(c.Validate()).To(Succeed())
+		})
+
+		// a command that accesses a single recipe only parses
+		// that recipe when it starts. the time to create the
+		// cookbook and access one recipe is reported alongside
+		// the time to create it and parse all its recipes as
+		// was done when the cookbook was created.
+		Measure("the startup time of a command that accesses a single recipe", func(b Benchmarker) {
+
+			cookbookDistPath := workspacePath + "/dist"
+			box := packr.New(cookbookDistPath, cookbookDistPath)
+
+			single := b.Time("startup with the accessed recipe", func() {
+				c, err = cookbook.NewCookbook(box, workspacePath, &outputBuffer, &errorBuffer)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(c.GetRecipe("basic", "aws")).NotTo(BeNil())
+			})
+			all := b.Time("startup with all recipes", func() {
+				c, err = cookbook.NewCookbook(box, workspacePath, &outputBuffer, &errorBuffer)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(c.Err()).To(Succeed())
+				Expect(c.GetRecipe("basic", "aws")).NotTo(BeNil())
+			})
+			b.RecordValue("startup time saved (ms)", float64(all-single)/float64(time.Millisecond))
+		}, 10)
+	})
+
 	Describe("Cookbook Persistance", func() {
 
 		Context("persist cookbook config", func() {
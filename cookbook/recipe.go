@@ -9,9 +9,11 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 
 	"github.com/otiai10/copy"
 
+	"github.com/appbricks/cloud-builder/internal/jsonenc"
 	"github.com/appbricks/cloud-builder/terraform"
 	"github.com/mevansam/goforms/config"
 	"github.com/mevansam/goforms/forms"
@@ -46,6 +48,7 @@ type Recipe interface {
 	IsBastion() bool
 	ResourceInstanceList() []string
 	ResourceInstanceDataList() []string
+	Outputs() []string
 
 	BackendType() string
 
@@ -68,6 +71,7 @@ type recipe struct {
 	isBastion                bool
 	resourceInstanceList     []string
 	resourceInstanceDataList []string
+	outputs                  []string
 
 	backendType string
 
@@ -109,6 +113,7 @@ func NewRecipe(
 		isBastion:                reader.IsBastion(),
 		resourceInstanceList:     reader.ResourceInstanceList(),
 		resourceInstanceDataList: reader.ResourceInstanceDataList(),
+		outputs:                  reader.Outputs(),
 
 		backendType: reader.BackendType(),
 
@@ -363,6 +368,11 @@ func (r *recipe) ResourceInstanceDataList() []string {
 	return r.resourceInstanceDataList
 }
 
+// out: names of the outputs of a deployed target
+func (r *recipe) Outputs() []string {
+	return r.outputs
+}
+
 // out: backend type where the state of an executed recipe will be saved
 func (r *recipe) BackendType() string {
 	return r.backendType
@@ -428,6 +438,7 @@ func (r *recipe) Copy() (config.Configurable, error) {
 		isBastion:                r.isBastion,
 		resourceInstanceList:     r.resourceInstanceList,
 		resourceInstanceDataList: r.resourceInstanceDataList,
+		outputs:                  r.outputs,
 
 		backendType: r.backendType,
 
@@ -559,12 +570,20 @@ func (r *recipe) marshalVariables(out *bytes.Buffer) error {
 		err   error
 		first bool
 	)
-	encoder := json.NewEncoder(out)
+	encoder := jsonenc.NewEncoder(out)
+
+	// variables are written in name order
+	// so the output is deterministic
+	names := make([]string, 0, len(r.variables))
+	for name := range r.variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
 	out.WriteString("\"variables\":[")
 	first = true
-	for _, v := range r.variables {
-		if v.Value != nil {
+	for _, name := range names {
+		if v := r.variables[name]; v.Value != nil {
 
 			if first {
 				first = false
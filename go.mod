@@ -17,6 +17,7 @@ require (
 	github.com/aws/aws-lambda-go v1.13.3
 	github.com/aws/aws-sdk-go v1.27.0
 	github.com/gobuffalo/packr/v2 v2.7.1
+	github.com/hashicorp/go-multierror v1.0.0
 	github.com/hashicorp/hcl/v2 v2.2.0
 	github.com/hashicorp/terraform v0.12.18
 	github.com/kr/pretty v0.2.0
@@ -26,8 +27,11 @@ require (
 	github.com/onsi/ginkgo v1.11.0
 	github.com/onsi/gomega v1.8.1
 	github.com/otiai10/copy v1.0.2
+	github.com/spf13/afero v1.2.1
 	github.com/spf13/viper v1.6.1
 	github.com/zclconf/go-cty v1.1.1
+	golang.org/x/crypto v0.0.0-20191206172530-e9b2fee46413
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 	google.golang.org/api v0.15.0
+	gopkg.in/yaml.v2 v2.2.4
 )
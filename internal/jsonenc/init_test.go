@@ -0,0 +1,13 @@
+package jsonenc_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestJSONEnc(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "jsonenc")
+}
@@ -0,0 +1,78 @@
+package jsonenc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// encoder that writes values as compact JSON in a canonical
+// form. map keys are written in sorted order, HTML characters
+// are not escaped and encoded values are not terminated with
+// a new line. all serialized configuration is written with
+// this encoder so that it is formatted consistently.
+type Encoder struct {
+	w io.Writer
+
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+// in: w - the stream to write encoded values to
+// out: an encoder that writes to the given stream
+func NewEncoder(w io.Writer) *Encoder {
+
+	e := &Encoder{w: w}
+	e.enc = json.NewEncoder(&e.buf)
+	e.enc.SetEscapeHTML(false)
+	return e
+}
+
+// writes the canonical JSON encoding of the given value
+//
+// in: v - the value to encode
+func (e *Encoder) Encode(v interface{}) error {
+
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := e.w.Write(bytes.TrimSuffix(e.buf.Bytes(), []byte{'\n'}))
+	return err
+}
+
+// writes the given string as an object key
+// followed by the key value separator
+//
+// in: key - the object key
+func (e *Encoder) WriteKey(key string) error {
+
+	if err := e.Encode(key); err != nil {
+		return err
+	}
+	_, err := e.w.Write([]byte{':'})
+	return err
+}
+
+// writes the given bytes to the stream as is. this is
+// used to write the delimiters of objects and arrays
+// whose elements are encoded individually.
+func (e *Encoder) Write(p []byte) (int, error) {
+	return e.w.Write(p)
+}
+
+// returns the canonical JSON encoding of the given value
+//
+// in: v - the value to encode
+// out: the encoded value
+func Marshal(v interface{}) ([]byte, error) {
+
+	var (
+		out bytes.Buffer
+	)
+
+	if err := NewEncoder(&out).Encode(v); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,42 @@
+package jsonenc_test
+
+import (
+	"strings"
+
+	"github.com/appbricks/cloud-builder/internal/jsonenc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Canonical JSON Encoder", func() {
+
+	It("encodes values as compact JSON without escaping HTML", func() {
+
+		data, err := jsonenc.Marshal(map[string]interface{}{
+			"b": []int{1, 2},
+			"a": "<a&b>",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(`{"a":"<a&b>","b":[1,2]}`))
+	})
+
+	It("writes objects whose values are encoded individually", func() {
+
+		var (
+			out strings.Builder
+		)
+
+		encoder := jsonenc.NewEncoder(&out)
+		_, err := encoder.Write([]byte{'{'})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encoder.WriteKey(`key "1"`)).To(Succeed())
+		Expect(encoder.Encode(struct {
+			Value string `json:"value"`
+		}{"x"})).To(Succeed())
+		_, err = encoder.Write([]byte{'}'})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(out.String()).To(Equal(`{"key \"1\"":{"value":"x"}}`))
+	})
+})
@@ -1,13 +1,18 @@
 package target
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"math"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/appbricks/cloud-builder/cookbook"
+	"github.com/appbricks/cloud-builder/internal/jsonenc"
 	"github.com/appbricks/cloud-builder/terraform"
 	"github.com/mevansam/gocloud/backend"
 	"github.com/mevansam/gocloud/cloud"
@@ -15,6 +20,7 @@ import (
 	"github.com/mevansam/goutils/logger"
 
 	"github.com/mevansam/goforms/config"
+	"github.com/mevansam/goforms/forms"
 )
 
 // Instance state callback
@@ -31,6 +37,57 @@ const (
 	Unknown
 )
 
+// Deployment lifecycle statuses
+type DeploymentStatus int
+
+const (
+	StatusUndeployed DeploymentStatus = iota
+	StatusDeploying
+	StatusDeployed
+	StatusError
+	StatusDestroying
+)
+
+var deploymentStatusNames = []string{
+	"undeployed",
+	"deploying",
+	"deployed",
+	"error",
+	"destroying",
+}
+
+func (s DeploymentStatus) String() string {
+	if s.isValid() {
+		return deploymentStatusNames[s]
+	}
+	return fmt.Sprintf("DeploymentStatus(%d)", int(s))
+}
+
+func (s DeploymentStatus) isValid() bool {
+	return s >= StatusUndeployed && int(s) < len(deploymentStatusNames)
+}
+
+// interface: encoding/TextMarshaler
+
+func (s DeploymentStatus) MarshalText() ([]byte, error) {
+	if !s.isValid() {
+		return nil, fmt.Errorf("unknown deployment status %d", int(s))
+	}
+	return []byte(deploymentStatusNames[s]), nil
+}
+
+// interface: encoding/TextUnmarshaler
+
+func (s *DeploymentStatus) UnmarshalText(text []byte) error {
+	for i, name := range deploymentStatusNames {
+		if name == string(text) {
+			*s = DeploymentStatus(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown deployment status '%s'", string(text))
+}
+
 // a target is a recipe configured to be
 // launched in a public cloud region
 type Target struct {
@@ -45,6 +102,32 @@ type Target struct {
 
 	CookbookTimestamp string `json:"cookbook_timestamp,omitempty"`
 
+	// deployment names of the targets whose
+	// outputs are consumed by this target
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	// arbitrary labels used to group targets
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// true if the target's backend has been changed
+	// and its terraform state has not been migrated
+	// to the new backend
+	StateMigrationPending bool `json:"stateMigrationPending,omitempty"`
+
+	// the backend the target's state needs to be
+	// migrated from. this is not persisted.
+	previousBackend backend.CloudBackend
+
+	// the lifecycle status of the target's deployment
+	deploymentStatus DeploymentStatus
+
+	// free-text notes about the target
+	// kept by the target's operators
+	notes string
+
+	createdAt time.Time
+	updatedAt time.Time
+
 	description string
 	version     string
 
@@ -82,6 +165,10 @@ func NewTarget(
 		Recipe:   r.(cookbook.Recipe),
 		Provider: p.(provider.CloudProvider),
 		Backend:  b.(backend.CloudBackend),
+
+		Tags: make(map[string]string),
+
+		createdAt: time.Now(),
 	}
 }
 
@@ -264,6 +351,98 @@ func (t *Target) Version() string {
 	return t.version
 }
 
+// out: the time the target was created. this will be
+//      zero for targets loaded from older configs.
+func (t *Target) CreatedAt() time.Time {
+	return t.createdAt
+}
+
+// out: the time the target was last saved. this will
+//      be zero if the target has not been saved.
+func (t *Target) UpdatedAt() time.Time {
+	return t.updatedAt
+}
+
+// returns the value of a terraform output of the
+// target. numeric and boolean values are converted
+// to strings.
+//
+// in: name - the name of the output
+// out: the output value
+// out: false if the target does not have a scalar
+//      output with the given name
+func (t *Target) OutputValue(name string) (string, bool) {
+
+	var (
+		ok     bool
+		output terraform.Output
+	)
+
+	if t.Output == nil {
+		return "", false
+	}
+	if output, ok = (*t.Output)[name]; !ok {
+		return "", false
+	}
+	return outputString(output.Value)
+}
+
+// returns the value of a terraform list output of
+// the target. numeric and boolean list elements
+// are converted to strings.
+//
+// in: name - the name of the output
+// out: the output list
+// out: false if the target does not have a list
+//      output of scalar values with the given name
+func (t *Target) OutputStringSlice(name string) ([]string, bool) {
+
+	var (
+		ok     bool
+		output terraform.Output
+	)
+
+	if t.Output == nil {
+		return nil, false
+	}
+	if output, ok = (*t.Output)[name]; !ok {
+		return nil, false
+	}
+
+	switch values := output.Value.(type) {
+	case []string:
+		return values, true
+	case []interface{}:
+		list := make([]string, len(values))
+		for i, v := range values {
+			if list[i], ok = outputString(v); !ok {
+				return nil, false
+			}
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+// converts a scalar value decoded from the
+// terraform output json to a string
+func outputString(value interface{}) (string, bool) {
+
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	}
+	return "", false
+}
+
 func (t *Target) DeploymentName() string {
 
 	if variable, exists := t.Recipe.GetVariable("name"); exists && variable.Value != nil {
@@ -273,6 +452,38 @@ func (t *Target) DeploymentName() string {
 	}
 }
 
+// out: the lifecycle status of the target's deployment.
+//      this will be StatusUndeployed for targets loaded
+//      from older configs.
+func (t *Target) DeploymentStatus() DeploymentStatus {
+	return t.deploymentStatus
+}
+
+// out: the operator notes of the target
+func (t *Target) Notes() string {
+	return t.notes
+}
+
+// sets free-text notes about the target. the notes
+// are informational and do not affect the deployment.
+//
+// in: notes - the notes or an empty string to clear them
+func (t *Target) SetNotes(notes string) {
+	t.notes = notes
+}
+
+// sets the lifecycle status of the target's deployment
+//
+// in: status - the new deployment status
+func (t *Target) SetDeploymentStatus(status DeploymentStatus) error {
+
+	if !status.isValid() {
+		return fmt.Errorf("unknown deployment status %d", int(status))
+	}
+	t.deploymentStatus = status
+	return nil
+}
+
 func (t *Target) Status() TargetState {
 
 	var (
@@ -389,7 +600,9 @@ func (t *Target) Suspend(cb InstanceStateChange) error {
 	return nil
 }
 
-// returns a copy of this target
+// returns a copy of this target. the outputs of the
+// target are copied so that outputs can be added to or
+// removed from the copy without changing this target.
 func (t *Target) Copy() (*Target, error) {
 
 	var (
@@ -409,6 +622,18 @@ func (t *Target) Copy() (*Target, error) {
 	if backendCopy, err = t.Backend.Copy(); err != nil {
 		return nil, err
 	}
+	tags := make(map[string]string)
+	for k, v := range t.Tags {
+		tags[k] = v
+	}
+	var output *map[string]terraform.Output
+	if t.Output != nil {
+		o := make(map[string]terraform.Output, len(*t.Output))
+		for k, v := range *t.Output {
+			o[k] = v
+		}
+		output = &o
+	}
 	return &Target{
 		RecipeName: t.RecipeName,
 		RecipeIaas: t.RecipeIaas,
@@ -417,12 +642,407 @@ func (t *Target) Copy() (*Target, error) {
 		Provider: providerCopy.(provider.CloudProvider),
 		Backend:  backendCopy.(backend.CloudBackend),
 
-		Output: t.Output,
+		Output: output,
 
 		CookbookTimestamp: t.CookbookTimestamp,
+
+		DependsOn: append([]string(nil), t.DependsOn...),
+		Tags:      tags,
+
+		StateMigrationPending: t.StateMigrationPending,
+		previousBackend:       t.previousBackend,
+
+		deploymentStatus: t.deploymentStatus,
+
+		notes: t.notes,
+
+		createdAt: t.createdAt,
+		updatedAt: t.updatedAt,
 	}, nil
 }
 
+// returns a copy of this target for a new deployment with
+// the given name. the copy does not retain the outputs
+// and deployment state of this target.
+//
+// in: deploymentName - the deployment name of the clone
+// out: the cloned target
+func (t *Target) Clone(deploymentName string) (*Target, error) {
+
+	var (
+		err error
+
+		clone *Target
+		form  forms.InputForm
+	)
+
+	if clone, err = t.Copy(); err != nil {
+		return nil, err
+	}
+	if form, err = clone.Recipe.InputForm(); err != nil {
+		return nil, err
+	}
+	if err = form.SetFieldValue("name", deploymentName); err != nil {
+		return nil, err
+	}
+
+	clone.Output = nil
+	clone.StateMigrationPending = false
+	clone.previousBackend = nil
+	clone.deploymentStatus = StatusUndeployed
+	clone.notes = ""
+	clone.createdAt = time.Now()
+	clone.updatedAt = time.Time{}
+	return clone, nil
+}
+
+// snapshot of the input values of a target
+type inputSnapshot struct {
+	RecipeName string `json:"recipeName"`
+	RecipeIaas string `json:"recipeIaas"`
+
+	Recipe   map[string]*string `json:"recipe,omitempty"`
+	Provider map[string]*string `json:"provider,omitempty"`
+	Backend  map[string]*string `json:"backend,omitempty"`
+}
+
+// returns a snapshot of the recipe, provider and backend
+// input values of the target. the snapshot can be used to
+// restore the input values after they have been modified.
+//
+// out: the serialized snapshot
+func (t *Target) SnapshotInputs() ([]byte, error) {
+
+	var (
+		err error
+	)
+
+	snapshot := inputSnapshot{
+		RecipeName: t.RecipeName,
+		RecipeIaas: t.RecipeIaas,
+	}
+	if t.Recipe != nil {
+		if snapshot.Recipe, err = fieldValues(t.Recipe); err != nil {
+			return nil, err
+		}
+	}
+	if t.Provider != nil {
+		if snapshot.Provider, err = fieldValues(t.Provider); err != nil {
+			return nil, err
+		}
+	}
+	if t.Backend != nil {
+		if snapshot.Backend, err = fieldValues(t.Backend); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(&snapshot)
+}
+
+// restores the recipe, provider and backend input values
+// of the target from a snapshot returned by SnapshotInputs.
+//
+// in: snapshot - the serialized snapshot
+func (t *Target) RestoreInputs(snapshot []byte) error {
+
+	var (
+		err error
+
+		restored inputSnapshot
+	)
+
+	if err = json.Unmarshal(snapshot, &restored); err != nil {
+		return err
+	}
+	if restored.RecipeName != t.RecipeName || restored.RecipeIaas != t.RecipeIaas {
+		return fmt.Errorf(
+			"snapshot of recipe '%s/%s' cannot be restored to a target of recipe '%s/%s'",
+			restored.RecipeName, restored.RecipeIaas,
+			t.RecipeName, t.RecipeIaas)
+	}
+	if err = restoreFieldValues(t.Recipe, restored.Recipe); err != nil {
+		return err
+	}
+	if err = restoreFieldValues(t.Provider, restored.Provider); err != nil {
+		return err
+	}
+	return restoreFieldValues(t.Backend, restored.Backend)
+}
+
+// sets the input form field values of the
+// given configurable to the given values
+func restoreFieldValues(c config.Configurable, values map[string]*string) error {
+
+	var (
+		err   error
+		form  forms.InputForm
+		field *forms.InputField
+	)
+
+	if c == nil || values == nil {
+		return nil
+	}
+	if form, err = c.InputForm(); err != nil {
+		return err
+	}
+	for name, value := range values {
+		if field, err = form.GetInputField(name); err != nil {
+			return err
+		}
+		if err = field.SetValue(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interface: encoding/json/Marshaler
+
+// serializes the target with the same structure as
+// targets serialized as part of a target set
+func (t *Target) MarshalJSON() ([]byte, error) {
+	return jsonenc.Marshal(newSerializedTarget(t))
+}
+
+// interface: encoding/json/Unmarshaler
+
+// deserializes the target into the target's recipe,
+// provider and backend which must have been created
+// with the types the target was serialized with
+func (t *Target) UnmarshalJSON(b []byte) error {
+
+	st := &serializedTarget{
+		targetFields: (*targetFields)(t),
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return err
+	}
+	t.deploymentStatus = st.DeploymentStatus
+	t.notes = st.Notes
+	if st.CreatedAt != nil {
+		t.createdAt = *st.CreatedAt
+	}
+	if st.UpdatedAt != nil {
+		t.updatedAt = *st.UpdatedAt
+	}
+	return nil
+}
+
+// returns whether this target and the given target have
+// the same configuration. the recipe, provider and backend
+// are compared by their field values. the times at which
+// the targets were created and saved are not compared.
+//
+// in: other - the target to compare with
+// out: true if the targets are equal
+func (t *Target) Equal(other *Target) bool {
+	return t.equal(other, false)
+}
+
+// returns whether this target and the given target have the
+// same configuration optionally ignoring the deployment name
+func (t *Target) equal(other *Target, ignoreName bool) bool {
+
+	if t == other {
+		return true
+	}
+	if other == nil ||
+		t.RecipeName != other.RecipeName ||
+		t.RecipeIaas != other.RecipeIaas ||
+		t.CookbookTimestamp != other.CookbookTimestamp ||
+		t.StateMigrationPending != other.StateMigrationPending ||
+		t.deploymentStatus != other.deploymentStatus ||
+		t.notes != other.notes {
+		return false
+	}
+	if !reflect.DeepEqual(t.Output, other.Output) ||
+		len(t.DependsOn) != len(other.DependsOn) ||
+		len(t.Tags) != len(other.Tags) {
+		return false
+	}
+	for i, name := range t.DependsOn {
+		if other.DependsOn[i] != name {
+			return false
+		}
+	}
+	for k, v := range t.Tags {
+		if ov, exists := other.Tags[k]; !exists || ov != v {
+			return false
+		}
+	}
+
+	ignoreFields := []string{}
+	if ignoreName {
+		ignoreFields = append(ignoreFields, "name")
+	}
+	return equalFieldValues(t.Recipe, other.Recipe, ignoreFields...) &&
+		equalFieldValues(t.Provider, other.Provider) &&
+		equalFieldValues(t.Backend, other.Backend)
+}
+
+// returns whether two configurables have the same
+// input form field values excluding the given fields
+func equalFieldValues(a, b config.Configurable, ignoreFields ...string) bool {
+
+	var (
+		err error
+
+		valuesA, valuesB map[string]*string
+	)
+
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	// the field values of each configurable need to be
+	// read before the other's input form is retrieved
+	// as configurables of the same type may share the
+	// same input form
+	if valuesA, err = fieldValues(a); err != nil {
+		return false
+	}
+	if valuesB, err = fieldValues(b); err != nil {
+		return false
+	}
+	for _, name := range ignoreFields {
+		delete(valuesA, name)
+		delete(valuesB, name)
+	}
+	if len(valuesA) != len(valuesB) {
+		return false
+	}
+	for name, valueA := range valuesA {
+		valueB, exists := valuesB[name]
+		if !exists ||
+			(valueA == nil) != (valueB == nil) ||
+			(valueA != nil && *valueA != *valueB) {
+			return false
+		}
+	}
+	return true
+}
+
+// returns a copy of the input form field
+// values of the given configurable
+func fieldValues(c config.Configurable) (map[string]*string, error) {
+
+	var (
+		err  error
+		form forms.InputForm
+	)
+
+	if form, err = c.InputForm(); err != nil {
+		return nil, err
+	}
+	values := make(map[string]*string)
+	for _, field := range form.InputFields() {
+		if value := field.Value(); value != nil {
+			v := *value
+			values[field.Name()] = &v
+		} else {
+			values[field.Name()] = nil
+		}
+	}
+	return values, nil
+}
+
+// replaces the target's backend and flags the target's
+// terraform state as needing to be migrated to the new
+// backend. if a migration is already pending the backend
+// the state needs to be migrated from is retained.
+//
+// in: b - the new backend of the target
+func (t *Target) MigrateBackend(b backend.CloudBackend) {
+
+	if !t.StateMigrationPending {
+		t.previousBackend = t.Backend
+		t.StateMigrationPending = true
+	}
+	t.Backend = b
+}
+
+// out: the backend the target's terraform state needs
+//      to be migrated from. this will be nil if no
+//      migration is pending or if the target was loaded
+//      after its backend was changed.
+func (t *Target) PreviousBackend() backend.CloudBackend {
+	return t.previousBackend
+}
+
+// clears the pending state migration once the target's
+// terraform state has been copied to its new backend
+func (t *Target) SetStateMigrated() {
+	t.StateMigrationPending = false
+	t.previousBackend = nil
+}
+
+// value sensitive fields are replaced with
+// when a target is redacted
+const redactedValue = "***"
+
+// returns a copy of the target with the values of the
+// sensitive recipe, provider and backend fields and of
+// the target's outputs replaced with '***'. this copy
+// should be used when logging targets.
+func (t *Target) Redacted() *Target {
+
+	var (
+		err      error
+		redacted *Target
+	)
+
+	if redacted, err = t.Copy(); err == nil {
+		if err = redactSensitiveFields(redacted.Recipe); err == nil {
+			if err = redactSensitiveFields(redacted.Provider); err == nil {
+				err = redactSensitiveFields(redacted.Backend)
+			}
+		}
+	}
+	if err != nil {
+		// return a target without any
+		// configuration that can be logged
+		logger.DebugMessage("Unable to redact target '%s': %s", t.RecipeName, err.Error())
+		return &Target{
+			RecipeName: t.RecipeName,
+			RecipeIaas: t.RecipeIaas,
+		}
+	}
+	// outputs such as the managed instances
+	// include instance keys and passwords
+	if t.Output != nil {
+		output := make(map[string]terraform.Output, len(*t.Output))
+		for name, o := range *t.Output {
+			output[name] = terraform.Output{
+				Sensitive: o.Sensitive,
+				Type:      o.Type,
+				Value:     redactedValue,
+			}
+		}
+		redacted.Output = &output
+	}
+	return redacted
+}
+
+func redactSensitiveFields(c config.Configurable) error {
+
+	var (
+		err  error
+		form forms.InputForm
+	)
+
+	if form, err = c.InputForm(); err != nil {
+		return err
+	}
+	for _, field := range form.InputFields() {
+		if value := field.Value(); field.Sensitive() && value != nil && len(*value) > 0 {
+			masked := redactedValue
+			if err = field.SetValue(&masked); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // prepares the target backend
 func (t *Target) PrepareBackend() error {
 
@@ -0,0 +1,177 @@
+package target
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/appbricks/cloud-builder/internal/jsonenc"
+	"github.com/mevansam/goutils/logger"
+)
+
+// target data structure used when serializing
+// a target along with its prior versions
+type historicTarget struct {
+	*serializedTarget
+
+	History []*Target `json:"history,omitempty"`
+}
+
+// sets the number of prior versions of each target that
+// are retained when the target is saved. the versions are
+// saved with the target set so they are available once the
+// target set is loaded again as long as the depth has been
+// set before loading. a depth of 0 or less disables the
+// history and discards the versions retained so far.
+//
+// in: n - the number of versions to retain per target
+func (ts *TargetSet) SetHistoryDepth(n int) {
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	ts.historyDepth = n
+	for key, history := range ts.history {
+		if n <= 0 {
+			delete(ts.history, key)
+		} else if len(history) > n {
+			ts.history[key] = history[:n]
+		}
+	}
+}
+
+// returns the prior versions of the target with the
+// given key. the most recent version is returned first
+// and its index is the revision given to RollbackTarget.
+//
+// in: name - the key of the target
+// out: the prior versions of the target
+func (ts *TargetSet) TargetHistory(name string) []*Target {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	return append([]*Target{}, ts.history[name]...)
+}
+
+// replaces the target with the given key with one of its
+// prior versions. the replaced target is added to the
+// target's history so the rollback can be reverted.
+//
+// in: name - the key of the target
+// in: revision - the index of the version in the
+//                list returned by TargetHistory
+func (ts *TargetSet) RollbackTarget(name string, revision int) error {
+
+	ts.mx.Lock()
+	if _, exists := ts.targets[name]; !exists {
+		ts.mx.Unlock()
+		return fmt.Errorf("a target with key '%s' does not exist", name)
+	}
+	history := ts.history[name]
+	if revision < 0 || revision >= len(history) {
+		ts.mx.Unlock()
+		return fmt.Errorf("target '%s' does not have revision %d", name, revision)
+	}
+	target := history[revision]
+	ts.history[name] = append(history[:revision:revision], history[revision+1:]...)
+	ts.saveTarget(name, target)
+	key := ts.keyOf(target)
+	ts.mx.Unlock()
+
+	ts.notify(ChangeEvent{
+		Op:             ChangeSaved,
+		Key:            key,
+		DeploymentName: target.DeploymentName(),
+	})
+	return nil
+}
+
+// adds the version of a target replaced by a save to the
+// target's history. the history moves with the target if
+// its key has changed. the caller must hold the target
+// set's lock.
+//
+// in: oldKey - the key the target was saved with
+// in: newKey - the key of the saved target
+// in: prior - the replaced version or nil
+// in: target - the saved target
+func (ts *TargetSet) recordHistory(oldKey, newKey string, prior, target *Target) {
+
+	if ts.historyDepth <= 0 {
+		return
+	}
+	history := ts.history[oldKey]
+	delete(ts.history, oldKey)
+	if h, exists := ts.history[newKey]; exists && len(history) == 0 {
+		history = h
+	}
+
+	// a target that was modified in place
+	// and saved again has no prior version
+	if prior != nil && prior != target {
+		history = append([]*Target{prior}, history...)
+	}
+	if len(history) > ts.historyDepth {
+		history = history[:ts.historyDepth]
+	}
+	if len(history) > 0 {
+		ts.history[newKey] = history
+	}
+}
+
+// serializes the target with the given key along with
+// its history. the caller must hold the target set's lock.
+func (ts *TargetSet) marshalTarget(key string) ([]byte, error) {
+
+	target := ts.targets[key]
+	if history := ts.history[key]; len(history) > 0 {
+		return jsonenc.Marshal(&historicTarget{
+			serializedTarget: newSerializedTarget(target),
+			History:          history,
+		})
+	}
+	return target.MarshalJSON()
+}
+
+// decodes the serialized prior versions of a target if
+// the history is enabled. versions whose recipe cannot
+// be created are dropped.
+func (ts *TargetSet) decodeHistory(data []json.RawMessage) ([]*Target, error) {
+
+	var (
+		err error
+
+		target *Target
+	)
+
+	ts.mx.RLock()
+	depth := ts.historyDepth
+	ts.mx.RUnlock()
+
+	if depth <= 0 || len(data) == 0 {
+		return nil, nil
+	}
+	if len(data) > depth {
+		data = data[:depth]
+	}
+
+	history := make([]*Target, 0, len(data))
+	for _, d := range data {
+		parsedTarget := parsedTarget{}
+		if err = json.Unmarshal(d, &parsedTarget); err != nil {
+			return nil, err
+		}
+		if target, err = ts.ctx.NewTarget(
+			parsedTarget.RecipeName,
+			parsedTarget.RecipeIaas,
+		); err != nil {
+			logger.DebugMessage(
+				"Unable to load prior version of target for recipe '%s/%s': %s",
+				parsedTarget.RecipeName, parsedTarget.RecipeIaas, err.Error())
+			continue
+		}
+		if err = parsedTarget.decodeInto(target); err != nil {
+			return nil, err
+		}
+		history = append(history, target)
+	}
+	return history, nil
+}
@@ -2,19 +2,117 @@ package target
 
 import (
 	"bytes"
+	goctx "context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/appbricks/cloud-builder/terraform"
+	"github.com/mevansam/goforms/forms"
 	"github.com/mevansam/goutils/logger"
 	"github.com/mevansam/goutils/utils"
 )
 
+// Merge conflict policies
+type ConflictPolicy int
+
+const (
+	// keep the existing target
+	Skip ConflictPolicy = iota
+	// replace the existing target
+	Overwrite
+	// rename the merged target by appending
+	// a numeric suffix to its deployment name
+	Rename
+)
+
+// Target set change operations
+type ChangeOp int
+
+const (
+	// a target was saved to the target set
+	ChangeSaved ChangeOp = iota
+	// a target was deleted from the target set
+	ChangeDeleted
+	// a target was loaded in to the target set
+	ChangeLoaded
+)
+
+// event passed to the observers of a target
+// set when a target in the set is changed
+type ChangeEvent struct {
+	Op ChangeOp
+
+	Key            string
+	DeploymentName string
+}
+
+// Target lock options
+type LockOption int
+
+const (
+	// return ErrTargetLocked instead of waiting
+	// if the target is already locked
+	NonBlocking LockOption = iota
+)
+
+// error returned when a target is locked
+// with the NonBlocking option but the
+// target has already been locked
+var ErrTargetLocked = errors.New("is locked")
+
 type TargetSet struct {
 	ctx context
 
+	// locks of the targets keyed by deployment
+	// name. the locks are guarded by their own
+	// mutex so they do not block access to the
+	// target set while a target is locked.
+	locksMx sync.Mutex
+	locks   map[string]chan struct{}
+
+	// guards the targets, orphaned
+	// targets and observers
+	mx sync.RWMutex
+
 	targets map[string]*Target
+
+	// targets that could not be loaded
+	// in the order they were read
+	orphaned []OrphanedTarget
+
+	// observers of changes to the target
+	// set in the order they were registered
+	observers []func(event ChangeEvent)
+
+	// function that returns the key of a target
+	// in the set. if nil the target's Key is used.
+	keyFunc func(*Target) string
+
+	// the prior versions of each target keyed by
+	// target key with the most recent first. at
+	// most historyDepth versions are retained.
+	history      map[string][]*Target
+	historyDepth int
+}
+
+// a serialized target that could not be loaded as its
+// recipe could not be created. the serialized target is
+// retained so that it is saved with the target set.
+type OrphanedTarget struct {
+	RecipeName string
+	RecipeIaas string
+
+	// the reason the target could not be loaded
+	Err error
+
+	data json.RawMessage
 }
 
 // temporary target data structure used
@@ -31,8 +129,42 @@ type parsedTarget struct {
 	Output *map[string]terraform.Output `json:"output,omitempty"`
 
 	CookbookTimestamp string `json:"cookbook_timestamp"`
+
+	DependsOn []string `json:"dependsOn"`
+
+	Tags map[string]string `json:"tags"`
+
+	StateMigrationPending bool `json:"stateMigrationPending"`
+
+	DeploymentStatus DeploymentStatus `json:"deploymentStatus"`
+
+	Notes string `json:"notes"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	History []json.RawMessage `json:"history"`
+}
+
+// target data structure used when serializing
+// targets in order to include target metadata
+// that is not exported
+type serializedTarget struct {
+	*targetFields
+
+	DeploymentStatus DeploymentStatus `json:"deploymentStatus,omitempty"`
+
+	Notes string `json:"notes,omitempty"`
+
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
 }
 
+// the fields of a target without the target's json
+// marshaling methods so they can be serialized along
+// with the target's metadata
+type targetFields Target
+
 // interface definition of global config context
 // specific to TargetSet. declared here to simplify
 // mocking and avoid cyclical dependencies.
@@ -48,6 +180,139 @@ func NewTargetSet(ctx context) *TargetSet {
 	return &TargetSet{
 		ctx:     ctx,
 		targets: make(map[string]*Target),
+		history: make(map[string][]*Target),
+	}
+}
+
+// registers an observer that is invoked each time a target
+// is saved to or deleted from the target set and for each
+// target loaded when a serialized target set is decoded.
+// observers are invoked in the order they were registered
+// after the change has been made so they may access the
+// target set.
+//
+// in: fn - the observer to invoke with each change
+func (ts *TargetSet) OnChange(fn func(event ChangeEvent)) {
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	ts.observers = append(ts.observers, fn)
+}
+
+// locks the target with the given deployment name so that
+// operations on the same deployment are serialized. the lock
+// is not tied to the target in the set so a deployment can be
+// locked before its target has been saved. the lock waits
+// until the target is unlocked unless the NonBlocking option
+// is given in which case ErrTargetLocked is returned.
+//
+// in: name - the deployment name of the target to lock
+// in: opts - options for acquiring the lock
+// out: function that releases the lock
+func (ts *TargetSet) Lock(name string, opts ...LockOption) (func(), error) {
+
+	ts.locksMx.Lock()
+	if ts.locks == nil {
+		ts.locks = make(map[string]chan struct{})
+	}
+	lock, exists := ts.locks[name]
+	if !exists {
+		lock = make(chan struct{}, 1)
+		ts.locks[name] = lock
+	}
+	ts.locksMx.Unlock()
+
+	nonBlocking := false
+	for _, opt := range opts {
+		if opt == NonBlocking {
+			nonBlocking = true
+		}
+	}
+	if nonBlocking {
+		select {
+		case lock <- struct{}{}:
+		default:
+			return nil, fmt.Errorf("target '%s' %w", name, ErrTargetLocked)
+		}
+	} else {
+		lock <- struct{}{}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-lock
+		})
+	}, nil
+}
+
+// sets the function used to derive the key that uniquely
+// identifies a target in the target set. the key function
+// is used when targets are saved, retrieved and loaded. by
+// default the key returned by the target's Key method is
+// used. changing the key function re-indexes the targets
+// already in the set with the new function. if two targets
+// have the same key once re-indexed only the target with
+// the lowest previous key is retained. a nil function
+// restores the default key.
+//
+// in: fn - the function that returns the key of a target
+func (ts *TargetSet) SetKeyFunc(fn func(*Target) string) {
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	ts.keyFunc = fn
+
+	keys := make([]string, 0, len(ts.targets))
+	for key := range ts.targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	targets := make(map[string]*Target, len(ts.targets))
+	for _, key := range keys {
+		target := ts.targets[key]
+		newKey := ts.keyOf(target)
+		if _, exists := targets[newKey]; exists {
+			logger.WarnMessage(
+				"Target with key '%s' dropped as its new key '%s' is not unique.",
+				key, newKey)
+			continue
+		}
+		targets[newKey] = target
+	}
+	ts.targets = targets
+}
+
+// returns the key of the given target in this target set
+func (ts *TargetSet) KeyOf(target *Target) string {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	return ts.keyOf(target)
+}
+
+// returns the key of the given target. the
+// caller must hold the target set's lock.
+func (ts *TargetSet) keyOf(target *Target) string {
+	if ts.keyFunc != nil {
+		return ts.keyFunc(target)
+	}
+	return target.Key()
+}
+
+// invokes the target set's observers with the given
+// events. the caller must not hold the target set's lock.
+func (ts *TargetSet) notify(events ...ChangeEvent) {
+
+	ts.mx.RLock()
+	observers := ts.observers
+	ts.mx.RUnlock()
+
+	for _, event := range events {
+		for _, fn := range observers {
+			fn(event)
+		}
 	}
 }
 
@@ -55,6 +320,17 @@ func (ts *TargetSet) Lookup(
 	recipeName, iaasName string,
 	keyValues ...string,
 ) []*Target {
+	return ts.LookupN(recipeName, iaasName, 0, keyValues...)
+}
+
+// returns at most 'limit' targets matching the given
+// key path sorted by deployment name. a limit of 0 or
+// less returns all matching targets.
+func (ts *TargetSet) LookupN(
+	recipeName, iaasName string,
+	limit int,
+	keyValues ...string,
+) []*Target {
 
 	var (
 		key strings.Builder
@@ -67,17 +343,111 @@ func (ts *TargetSet) Lookup(
 	key.WriteString(strings.Join(keyValues, "/"))
 	keyPath := key.String()
 
-	targets := make([]*Target, 0, len(ts.targets))
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	return ts.filter(
+		func(t *Target) bool {
+			return strings.HasPrefix(t.Key(), keyPath)
+		},
+		limit,
+	)
+}
+
+// returns all targets whose deployment name matches
+// the given regular expression sorted by deployment name
+//
+// in: pattern - the regular expression to match
+// out: the matching targets
+func (ts *TargetSet) LookupRegex(pattern string) ([]*Target, error) {
+
+	var (
+		err error
+		re  *regexp.Regexp
+	)
+
+	if re, err = regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	return ts.filter(
+		func(t *Target) bool {
+			return re.MatchString(t.DeploymentName())
+		},
+		0,
+	), nil
+}
+
+// returns all targets having a tag with the
+// given value sorted by deployment name
+func (ts *TargetSet) LookupByTag(key, value string) []*Target {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	return ts.filter(
+		func(t *Target) bool {
+			v, exists := t.Tags[key]
+			return exists && v == value
+		},
+		0,
+	)
+}
+
+// returns all targets having the given deployment
+// status sorted by deployment name
+func (ts *TargetSet) ByStatus(status DeploymentStatus) []*Target {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	return ts.filter(
+		func(t *Target) bool {
+			return t.deploymentStatus == status
+		},
+		0,
+	)
+}
+
+// returns all targets for which the given predicate
+// returns true sorted by deployment name
+func (ts *TargetSet) Filter(pred func(*Target) bool) []*Target {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	return ts.filter(pred, 0)
+}
+
+// returns at most 'limit' targets for which the given
+// predicate returns true sorted by deployment name.
+// the caller must hold the target set's lock.
+func (ts *TargetSet) filter(pred func(*Target) bool, limit int) []*Target {
+
+	size := len(ts.targets)
+	if limit > 0 && limit < size {
+		size = limit
+	}
+	targets := make([]*Target, 0, size)
 	l := 0
 
 	for _, t := range ts.targets {
 
-		if strings.HasPrefix(t.Key(), keyPath) {
+		if pred(t) {
 			// add targets to array
 			// sorting it along the way
 			i := sort.Search(l, func(j int) bool {
 				return targets[j].DeploymentName() > t.DeploymentName()
 			})
+			if limit > 0 && l == limit {
+				// array is full so drop the last target
+				// if the new target is sorted before it
+				if i == l {
+					continue
+				}
+				targets = targets[:l-1]
+				l--
+			}
 			targets = append(targets, nil)
 			if targets[i] != nil {
 				copy(targets[i+1:], targets[i:])
@@ -90,6 +460,8 @@ func (ts *TargetSet) Lookup(
 }
 
 func (ts *TargetSet) GetTargets() []*Target {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
 
 	targets := make([]*Target, len(ts.targets))
 	i := 0
@@ -100,50 +472,719 @@ func (ts *TargetSet) GetTargets() []*Target {
 	return targets
 }
 
+// returns all targets in the set sorted using the given
+// comparator. targets the comparator considers equal are
+// ordered by their keys so the order is deterministic.
+//
+// in: less - returns true if target a sorts before target b
+// out: the sorted targets
+func (ts *TargetSet) GetTargetsSorted(less func(a, b *Target) bool) []*Target {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	keys := make([]string, 0, len(ts.targets))
+	for key := range ts.targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	targets := make([]*Target, len(keys))
+	for i, key := range keys {
+		targets[i] = ts.targets[key]
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		return less(targets[i], targets[j])
+	})
+	return targets
+}
+
+// comparator for GetTargetsSorted that
+// orders targets by deployment name
+func LessByName(a, b *Target) bool {
+	return a.DeploymentName() < b.DeploymentName()
+}
+
+// comparator for GetTargetsSorted that orders
+// targets by the time they were created
+func LessByCreatedAt(a, b *Target) bool {
+	return a.createdAt.Before(b.createdAt)
+}
+
+// comparator for GetTargetsSorted that
+// orders targets by deployment status
+func LessByStatus(a, b *Target) bool {
+	return a.deploymentStatus < b.deploymentStatus
+}
+
+// invokes the given function for each target in the set
+// without allocating a slice of the targets. targets are
+// visited in no particular order and the target set must
+// not be modified by the function.
+//
+// in: fn - the function to invoke for each target
+// out: the first error returned by the function
+func (ts *TargetSet) ForEach(fn func(*Target) error) error {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	for _, t := range ts.targets {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns a channel that emits the targets of the set in key
+// order. the keys of the targets are read when the stream is
+// created so targets added after the stream was created are
+// not emitted and targets deleted before they are reached are
+// skipped. the channel is closed once all targets have been
+// emitted or when the given context is cancelled.
+//
+// in: ctx - the context used to cancel the stream
+// out: the channel the targets are emitted on
+func (ts *TargetSet) Stream(ctx goctx.Context) <-chan *Target {
+
+	ts.mx.RLock()
+	keys := make([]string, 0, len(ts.targets))
+	for key := range ts.targets {
+		keys = append(keys, key)
+	}
+	ts.mx.RUnlock()
+	sort.Strings(keys)
+
+	stream := make(chan *Target)
+	go func() {
+		defer close(stream)
+
+		for _, key := range keys {
+			ts.mx.RLock()
+			t, exists := ts.targets[key]
+			ts.mx.RUnlock()
+			if !exists {
+				continue
+			}
+
+			select {
+			case stream <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return stream
+}
+
+// returns the number of targets in the set
+func (ts *TargetSet) Count() int {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	return len(ts.targets)
+}
+
+// returns the number of targets for each recipe
+// keyed by the recipe name and iaas pair
+// i.e. 'recipeName/recipeIaas'
+func (ts *TargetSet) CountByRecipe() map[string]int {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	counts := make(map[string]int)
+	for _, t := range ts.targets {
+		counts[t.RecipeName+"/"+t.RecipeIaas]++
+	}
+	return counts
+}
+
+// returns the targets ordered such that each target
+// comes after the targets it depends on. targets that
+// do not depend on each other are ordered by key.
+//
+// out: the targets in dependency order
+func (ts *TargetSet) TopologicalOrder() ([]*Target, error) {
+
+	var (
+		err error
+
+		visit func(target *Target, path []string) error
+	)
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	keys := make([]string, 0, len(ts.targets))
+	targetsByName := make(map[string][]*Target)
+	for key, target := range ts.targets {
+		keys = append(keys, key)
+		name := target.DeploymentName()
+		targetsByName[name] = append(targetsByName[name], target)
+	}
+	sort.Strings(keys)
+
+	state := make(map[*Target]int)
+	ordered := make([]*Target, 0, len(ts.targets))
+
+	visit = func(target *Target, path []string) error {
+
+		switch state[target] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf(
+				"targets have a cyclic dependency: %s",
+				strings.Join(append(path, target.DeploymentName()), " -> "))
+		}
+		state[target] = visiting
+		path = append(path, target.DeploymentName())
+
+		for _, name := range target.DependsOn {
+			dependencies, exists := targetsByName[name]
+			if !exists {
+				return fmt.Errorf(
+					"target '%s' depends on '%s' which does not exist",
+					target.DeploymentName(), name)
+			}
+			if len(dependencies) > 1 {
+				return fmt.Errorf(
+					"target '%s' depends on '%s' which is the name of more than one target",
+					target.DeploymentName(), name)
+			}
+			if err = visit(dependencies[0], path); err != nil {
+				return err
+			}
+		}
+
+		state[target] = visited
+		ordered = append(ordered, target)
+		return nil
+	}
+
+	for _, key := range keys {
+		if err = visit(ts.targets[key], []string{}); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
 func (ts *TargetSet) GetTarget(name string) *Target {
-	logger.TraceMessage(
-		"Retrieving target with name '%s' from: %# v",
-		name, ts.targets)
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
 
+	logger.TraceMessage("Retrieving target with name '%s'.", name)
 	return ts.targets[name]
 }
 
 func (ts *TargetSet) SaveTarget(key string, target *Target) {
-	logger.TraceMessage("Saving target: %# v", target)
+	ts.mx.Lock()
+	ts.saveTarget(key, target)
+	key = ts.keyOf(target)
+	ts.mx.Unlock()
+
+	ts.notify(ChangeEvent{
+		Op:             ChangeSaved,
+		Key:            key,
+		DeploymentName: target.DeploymentName(),
+	})
+}
+
+// saves the given target replacing the target with the
+// given key. the caller must hold the target set's lock.
+func (ts *TargetSet) saveTarget(key string, target *Target) {
+	logger.TraceMessage("Saving target: %s", ts.keyOf(target))
+
+	target.updatedAt = time.Now()
 
 	// delete target with given key before
 	// saving in the target map, as the key of
 	// the new/updated target may have changed
+	newKey := ts.keyOf(target)
+	prior, exists := ts.targets[key]
+	if !exists {
+		prior = ts.targets[newKey]
+	}
 	delete(ts.targets, key)
-	ts.targets[target.Key()] = target
+	ts.targets[newKey] = target
+	ts.recordHistory(key, newKey, prior, target)
+}
+
+// modifies the target with the given key. the target set is
+// locked while the target is modified so the target is not
+// replaced by a concurrent save. the mutation is applied to a
+// copy of the target and its outputs which replaces the target
+// only if the mutation succeeds and the target's key remains
+// unique. once replaced the target's update time is set and
+// it is re-keyed if its key has changed. the mutation must
+// not call methods of the target set.
+//
+// in: name - the key of the target
+// in: mutate - function that modifies the target
+func (ts *TargetSet) UpdateTarget(name string, mutate func(*Target) error) error {
+
+	var (
+		err error
+
+		updated *Target
+	)
+
+	ts.mx.Lock()
+	target, exists := ts.targets[name]
+	if !exists {
+		ts.mx.Unlock()
+		return fmt.Errorf("a target with key '%s' does not exist", name)
+	}
+	if updated, err = target.Copy(); err != nil {
+		ts.mx.Unlock()
+		return err
+	}
+	if err = mutate(updated); err != nil {
+		ts.mx.Unlock()
+		return err
+	}
+	key := ts.keyOf(updated)
+	if key != name {
+		if _, exists := ts.targets[key]; exists {
+			ts.mx.Unlock()
+			return fmt.Errorf("a target with key '%s' already exists", key)
+		}
+	}
+	ts.saveTarget(name, updated)
+	ts.mx.Unlock()
+
+	ts.notify(ChangeEvent{
+		Op:             ChangeSaved,
+		Key:            key,
+		DeploymentName: updated.DeploymentName(),
+	})
+	return nil
+}
+
+// renames the target with the given deployment name. the
+// renamed target is saved as a copy so the target prior to
+// the rename is retained in its history. the rename fails
+// if more than one target has the given deployment name.
+//
+// in: oldName - the current deployment name of the target
+// in: newName - the new deployment name of the target
+func (ts *TargetSet) Rename(oldName, newName string) error {
+
+	var (
+		err error
+
+		target  *Target
+		renamed *Target
+		form    forms.InputForm
+	)
+
+	ts.mx.Lock()
+	for _, t := range ts.targets {
+		name := t.DeploymentName()
+		if name == oldName {
+			if target != nil {
+				ts.mx.Unlock()
+				return fmt.Errorf("more than one target has name '%s'", oldName)
+			}
+			target = t
+		} else if name == newName {
+			ts.mx.Unlock()
+			return fmt.Errorf("a target with name '%s' already exists", newName)
+		}
+	}
+	if target == nil {
+		ts.mx.Unlock()
+		return fmt.Errorf("a target with name '%s' does not exist", oldName)
+	}
+	if oldName == newName {
+		ts.mx.Unlock()
+		return nil
+	}
+
+	key := ts.keyOf(target)
+	if renamed, err = target.Copy(); err != nil {
+		ts.mx.Unlock()
+		return err
+	}
+	if form, err = renamed.Recipe.InputForm(); err != nil {
+		ts.mx.Unlock()
+		return err
+	}
+	if err = form.SetFieldValue("name", newName); err != nil {
+		ts.mx.Unlock()
+		return err
+	}
+	newKey := ts.keyOf(renamed)
+	if newKey != key {
+		if _, exists := ts.targets[newKey]; exists {
+			ts.mx.Unlock()
+			return fmt.Errorf("a target with key '%s' already exists", newKey)
+		}
+	}
+	ts.saveTarget(key, renamed)
+	ts.mx.Unlock()
+
+	ts.notify(ChangeEvent{
+		Op:             ChangeSaved,
+		Key:            newKey,
+		DeploymentName: renamed.DeploymentName(),
+	})
+	return nil
+}
+
+// merges copies of the targets of another target set into
+// this target set. targets with the same key are resolved
+// using the given conflict policy.
+//
+// in: other - the target set to merge from
+// in: onConflict - how to resolve targets with the same key
+// out: merged - the number of targets added or replaced
+// out: skipped - the number of targets not merged
+func (ts *TargetSet) Merge(
+	other *TargetSet,
+	onConflict ConflictPolicy,
+) (merged, skipped int, err error) {
+
+	var (
+		exists bool
+
+		target *Target
+		form   forms.InputForm
+	)
+
+	// copy the other set's targets before locking
+	// this set as both sets may be the same set
+	other.mx.RLock()
+	targets := make([]*Target, 0, len(other.targets))
+	for _, t := range other.targets {
+		if target, err = t.Copy(); err != nil {
+			other.mx.RUnlock()
+			return merged, skipped, err
+		}
+		targets = append(targets, target)
+	}
+	other.mx.RUnlock()
+
+	ts.mx.Lock()
+	saved := []ChangeEvent{}
+	defer func() {
+		ts.mx.Unlock()
+		// observers are notified of the targets
+		// merged before any error occurred
+		ts.notify(saved...)
+	}()
+
+	sort.Slice(targets, func(i, j int) bool {
+		return ts.keyOf(targets[i]) < ts.keyOf(targets[j])
+	})
+	for _, target = range targets {
+		key := ts.keyOf(target)
+
+		if _, exists = ts.targets[key]; exists {
+			switch onConflict {
+			case Skip:
+				skipped++
+				continue
+
+			case Rename:
+				if form, err = target.Recipe.InputForm(); err != nil {
+					return merged, skipped, err
+				}
+				name := target.DeploymentName()
+				for i := 1; exists; i++ {
+					if err = form.SetFieldValue("name", fmt.Sprintf("%s-%d", name, i)); err != nil {
+						return merged, skipped, err
+					}
+					newKey := ts.keyOf(target)
+					if newKey == key {
+						return merged, skipped, fmt.Errorf(
+							"target with key '%s' cannot be renamed as its name is not part of its key",
+							key)
+					}
+					_, exists = ts.targets[newKey]
+				}
+				// the renamed target is saved with its
+				// new key so the existing target is kept
+				key = ts.keyOf(target)
+			}
+		}
+
+		ts.saveTarget(key, target)
+		saved = append(saved, ChangeEvent{
+			Op:             ChangeSaved,
+			Key:            key,
+			DeploymentName: target.DeploymentName(),
+		})
+		merged++
+	}
+	return merged, skipped, nil
+}
+
+// deletes all targets for which the given predicate
+// returns true
+//
+// in: pred - the predicate to match targets with. it is
+//            called while the target set is locked so it
+//            must not access the target set.
+// out: the number of targets deleted
+func (ts *TargetSet) DeleteWhere(pred func(*Target) bool) int {
+	ts.mx.Lock()
+
+	// snapshot the keys so the target
+	// map is not modified while iterating
+	keys := make([]string, 0, len(ts.targets))
+	for key := range ts.targets {
+		keys = append(keys, key)
+	}
+
+	deleted := []ChangeEvent{}
+	for _, key := range keys {
+		if t := ts.targets[key]; pred(t) {
+			ts.deleteTarget(key)
+			deleted = append(deleted, ChangeEvent{
+				Op:             ChangeDeleted,
+				Key:            key,
+				DeploymentName: t.DeploymentName(),
+			})
+		}
+	}
+	ts.mx.Unlock()
+
+	ts.notify(deleted...)
+	return len(deleted)
+}
+
+// returns groups of targets that have the same configuration
+// but different deployment names. each group is sorted by
+// deployment name and the groups are sorted by the deployment
+// name of their first target.
+//
+// out: the groups of duplicate targets
+func (ts *TargetSet) FindDuplicates() [][]*Target {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	return ts.findDuplicates()
+}
+
+// returns the groups of duplicate targets. the
+// caller must hold the target set's lock.
+func (ts *TargetSet) findDuplicates() [][]*Target {
+
+	duplicates := [][]*Target{}
+	grouped := make(map[*Target]bool)
+
+	targets := ts.filter(func(*Target) bool { return true }, 0)
+	for i, t := range targets {
+		if grouped[t] {
+			continue
+		}
+		group := []*Target{t}
+		for _, other := range targets[i+1:] {
+			if !grouped[other] && t.equal(other, true) {
+				group = append(group, other)
+				grouped[other] = true
+			}
+		}
+		if len(group) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+	return duplicates
+}
+
+// deletes all but one target of each group of duplicate
+// targets returned by FindDuplicates
+//
+// in: keep - function that returns the target of a group
+//            of duplicates that should be kept. if it
+//            returns nil all targets of the group are kept.
+//            it must not access the target set.
+// out: the number of targets deleted
+func (ts *TargetSet) DedupeKeep(keep func(dups []*Target) *Target) int {
+	ts.mx.Lock()
+
+	deleted := []ChangeEvent{}
+	for _, dups := range ts.findDuplicates() {
+		kept := keep(dups)
+		if kept == nil {
+			continue
+		}
+		for _, t := range dups {
+			if t != kept {
+				key := ts.keyOf(t)
+				ts.deleteTarget(key)
+				deleted = append(deleted, ChangeEvent{
+					Op:             ChangeDeleted,
+					Key:            key,
+					DeploymentName: t.DeploymentName(),
+				})
+			}
+		}
+	}
+	ts.mx.Unlock()
+
+	ts.notify(deleted...)
+	return len(deleted)
+}
+
+// returns the serialized targets that could not be
+// loaded in the order they were read
+func (ts *TargetSet) OrphanedTargets() []OrphanedTarget {
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	return append([]OrphanedTarget{}, ts.orphaned...)
+}
+
+// deletes the orphaned targets of the given recipe
+//
+// in: recipeName - the name of the recipe
+// in: recipeIaas - the iaas of the recipe
+// out: the number of orphaned targets deleted
+func (ts *TargetSet) DeleteOrphanedTargets(recipeName, recipeIaas string) int {
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	orphaned := []OrphanedTarget{}
+	for _, o := range ts.orphaned {
+		if o.RecipeName != recipeName || o.RecipeIaas != recipeIaas {
+			orphaned = append(orphaned, o)
+		}
+	}
+	deleted := len(ts.orphaned) - len(orphaned)
+	ts.orphaned = orphaned
+	return deleted
 }
 
 func (ts *TargetSet) DeleteTarget(key string) {
+	ts.mx.Lock()
+	target, exists := ts.targets[key]
+	ts.deleteTarget(key)
+	ts.mx.Unlock()
+
+	if exists {
+		ts.notify(ChangeEvent{
+			Op:             ChangeDeleted,
+			Key:            key,
+			DeploymentName: target.DeploymentName(),
+		})
+	}
+}
+
+// deletes the target with the given key. the
+// caller must hold the target set's lock.
+func (ts *TargetSet) deleteTarget(key string) {
 	logger.TraceMessage("Saving target with key. %s", key)
 	delete(ts.targets, key)
+	delete(ts.history, key)
 }
 
-// interface: encoding/json/Unmarshaler
-
-func (ts *TargetSet) UnmarshalJSON(b []byte) error {
+// returns a copy of this target set. the copy shares
+// the same context but contains copies of each target.
+func (ts *TargetSet) Copy() (*TargetSet, error) {
 
 	var (
 		err error
 
-		target *Target
+		targetCopy *Target
 	)
 
-	decoder := json.NewDecoder(bytes.NewReader(b))
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	tsCopy := NewTargetSet(ts.ctx)
+	tsCopy.keyFunc = ts.keyFunc
+	tsCopy.historyDepth = ts.historyDepth
+	for key, t := range ts.targets {
+		if targetCopy, err = t.Copy(); err != nil {
+			return nil, err
+		}
+		tsCopy.targets[key] = targetCopy
+	}
+	for key, history := range ts.history {
+		historyCopy := make([]*Target, len(history))
+		for i, t := range history {
+			if historyCopy[i], err = t.Copy(); err != nil {
+				return nil, err
+			}
+		}
+		tsCopy.history[key] = historyCopy
+	}
+	tsCopy.orphaned = append(tsCopy.orphaned, ts.orphaned...)
+	return tsCopy, nil
+}
+
+// decodes a serialized array of targets from the given
+// decoder. the given callback is invoked with the number
+// of targets decoded so far after each target is loaded.
+// the orphaned targets of previously decoded arrays are
+// discarded so they are not retained more than once.
+//
+// in: decoder - a json decoder positioned at the start of
+//               a serialized array of targets
+// in: decoded - callback invoked after each target is
+//               decoded (may be nil). decoding stops if
+//               the callback returns an error.
+func (ts *TargetSet) Decode(
+	decoder *json.Decoder,
+	decoded func(count int) error,
+) error {
+
+	ts.mx.Lock()
+	ts.orphaned = nil
+	ts.mx.Unlock()
+
+	return ts.decode(decoder, decoded)
+}
+
+// decodes a serialized array of targets which continues
+// the arrays decoded before it. unlike Decode the orphaned
+// targets of the previously decoded arrays are retained.
+//
+// in: decoder - a json decoder positioned at the start of
+//               a serialized array of targets
+// in: decoded - callback invoked after each target is
+//               decoded (may be nil)
+func (ts *TargetSet) DecodeMore(
+	decoder *json.Decoder,
+	decoded func(count int) error,
+) error {
+	return ts.decode(decoder, decoded)
+}
+
+func (ts *TargetSet) decode(
+	decoder *json.Decoder,
+	decoded func(count int) error,
+) error {
+
+	var (
+		err error
+
+		target  *Target
+		history []*Target
+		loaded  []ChangeEvent
+	)
 
 	// read array open bracket
 	if _, err = utils.ReadJSONDelimiter(decoder, utils.JsonArrayStartDelim); err != nil {
 		return err
 	}
 
+	count := 0
 	for decoder.More() {
 
+		data := json.RawMessage{}
+		if err = decoder.Decode(&data); err != nil {
+			return err
+		}
 		parsedTarget := parsedTarget{}
-		if err = decoder.Decode(&parsedTarget); err != nil {
+		if err = json.Unmarshal(data, &parsedTarget); err != nil {
 			return err
 		}
 
@@ -151,21 +1192,51 @@ func (ts *TargetSet) UnmarshalJSON(b []byte) error {
 			parsedTarget.RecipeName,
 			parsedTarget.RecipeIaas,
 		); err != nil {
-			return err
+			// retain target so it is not lost if
+			// its recipe is no longer available
+			logger.DebugMessage(
+				"Unable to load target for recipe '%s/%s': %s",
+				parsedTarget.RecipeName, parsedTarget.RecipeIaas, err.Error())
+
+			ts.mx.Lock()
+			ts.orphaned = append(ts.orphaned, OrphanedTarget{
+				RecipeName: parsedTarget.RecipeName,
+				RecipeIaas: parsedTarget.RecipeIaas,
+				Err:        err,
+				data:       data,
+			})
+			ts.mx.Unlock()
+			continue
 		}
-		if err = json.Unmarshal(parsedTarget.Recipe, target.Recipe); err != nil {
+		if err = parsedTarget.decodeInto(target); err != nil {
 			return err
 		}
-		if err = json.Unmarshal(parsedTarget.Provider, target.Provider); err != nil {
+		if history, err = ts.decodeHistory(parsedTarget.History); err != nil {
 			return err
 		}
-		if err = json.Unmarshal(parsedTarget.Backend, target.Backend); err != nil {
-			return err
+
+		// the lock is only held while the target is added
+		// so the callback can access the target set
+		ts.mx.Lock()
+		key := ts.keyOf(target)
+		ts.targets[key] = target
+		if len(history) > 0 {
+			ts.history[key] = history
 		}
-		target.Output = parsedTarget.Output
-		target.CookbookTimestamp = parsedTarget.CookbookTimestamp
+		ts.mx.Unlock()
+
+		loaded = append(loaded, ChangeEvent{
+			Op:             ChangeLoaded,
+			Key:            key,
+			DeploymentName: target.DeploymentName(),
+		})
 
-		ts.targets[target.Key()] = target
+		count++
+		if decoded != nil {
+			if err = decoded(count); err != nil {
+				return err
+			}
+		}
 	}
 
 	// read array close bracket
@@ -173,40 +1244,151 @@ func (ts *TargetSet) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
+	// observers are notified once all
+	// targets have been loaded
+	ts.notify(loaded...)
 	return nil
 }
 
+// interface: encoding/json/Unmarshaler
+
+func (ts *TargetSet) UnmarshalJSON(b []byte) error {
+	return ts.Decode(json.NewDecoder(bytes.NewReader(b)), nil)
+}
+
 // interface: encoding/json/Marshaler
 
 func (ts *TargetSet) MarshalJSON() ([]byte, error) {
 
 	var (
-		err   error
-		out   bytes.Buffer
-		first bool
+		err error
+		out bytes.Buffer
 	)
-	encoder := json.NewEncoder(&out)
-	first = true
 
-	if _, err = out.WriteRune('['); err != nil {
-		return out.Bytes(), err
+	_, err = ts.WriteTo(&out)
+	return out.Bytes(), err
+}
+
+// interface: io/WriterTo
+
+// writes the target set to the given stream as a JSON
+// array. targets are encoded one at a time so the whole
+// serialized set is never held in memory.
+//
+// in: w - the stream to write the target set to
+// out: the number of bytes written
+func (ts *TargetSet) WriteTo(w io.Writer) (int64, error) {
+
+	var (
+		err  error
+		n    int
+		data []byte
+	)
+	written := int64(0)
+
+	write := func(b []byte) error {
+		n, err = w.Write(b)
+		written += int64(n)
+		return err
+	}
+
+	ts.mx.RLock()
+	defer ts.mx.RUnlock()
+
+	if err = write([]byte{'['}); err != nil {
+		return written, err
+	}
+
+	// targets are written in key order
+	// so the output is deterministic
+	keys := make([]string, 0, len(ts.targets))
+	for key := range ts.targets {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	for _, target := range ts.targets {
-		if first {
-			first = false
-		} else {
-			out.WriteRune(',')
+	for i, key := range keys {
+		if i > 0 {
+			if err = write([]byte{','}); err != nil {
+				return written, err
+			}
+		}
+		if data, err = ts.marshalTarget(key); err != nil {
+			return written, err
 		}
+		if err = write(data); err != nil {
+			return written, err
+		}
+	}
 
-		if err = encoder.Encode(target); err != nil {
-			return out.Bytes(), err
+	// orphaned targets are written as
+	// they were read after all targets
+	for i, o := range ts.orphaned {
+		if i > 0 || len(keys) > 0 {
+			if err = write([]byte{','}); err != nil {
+				return written, err
+			}
+		}
+		compacted := bytes.Buffer{}
+		if err = json.Compact(&compacted, o.data); err != nil {
+			return written, err
+		}
+		if err = write(compacted.Bytes()); err != nil {
+			return written, err
 		}
 	}
 
-	if _, err = out.WriteRune(']'); err != nil {
-		return out.Bytes(), err
+	if err = write([]byte{']'}); err != nil {
+		return written, err
 	}
+	return written, nil
+}
+
+// sets the fields of the given target created
+// for the parsed target's recipe
+func (pt *parsedTarget) decodeInto(target *Target) error {
+
+	var (
+		err error
+	)
+
+	if err = json.Unmarshal(pt.Recipe, target.Recipe); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(pt.Provider, target.Provider); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(pt.Backend, target.Backend); err != nil {
+		return err
+	}
+	target.Output = pt.Output
+	target.CookbookTimestamp = pt.CookbookTimestamp
+	target.DependsOn = pt.DependsOn
+	if pt.Tags != nil {
+		target.Tags = pt.Tags
+	}
+	target.StateMigrationPending = pt.StateMigrationPending
+	target.deploymentStatus = pt.DeploymentStatus
+	target.notes = pt.Notes
+	target.createdAt = pt.CreatedAt
+	target.updatedAt = pt.UpdatedAt
+	return nil
+}
+
+func newSerializedTarget(target *Target) *serializedTarget {
 
-	return out.Bytes(), nil
+	st := &serializedTarget{
+		targetFields: (*targetFields)(target),
+
+		DeploymentStatus: target.deploymentStatus,
+
+		Notes: target.notes,
+	}
+	if !target.createdAt.IsZero() {
+		st.CreatedAt = &target.createdAt
+	}
+	if !target.updatedAt.IsZero() {
+		st.UpdatedAt = &target.updatedAt
+	}
+	return st
 }
@@ -1,14 +1,18 @@
 package target_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/mevansam/gocloud/provider"
 	"github.com/appbricks/cloud-builder/cookbook"
 	"github.com/appbricks/cloud-builder/target"
+	"github.com/appbricks/cloud-builder/terraform"
 
 	"github.com/mevansam/goforms/forms"
 	"github.com/mevansam/goutils/utils"
@@ -90,6 +94,908 @@ var _ = Describe("TargetSet", func() {
 			)
 		})
 
+		It("filters targets using a predicate", func() {
+
+			var (
+				targets []*target.Target
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			targets = ts.Filter(func(t *target.Target) bool {
+				return t.RecipeIaas == "aws"
+			})
+			Expect(len(targets)).To(Equal(2))
+			Expect(targets[0].DeploymentName() <= targets[1].DeploymentName()).To(BeTrue())
+
+			targets = ts.Filter(func(t *target.Target) bool {
+				return t.Key() == "basic/aws/aa/"
+			})
+			Expect(len(targets)).To(Equal(1))
+			Expect(targets[0].Key()).To(Equal("basic/aws/aa/"))
+
+			targets = ts.Filter(func(t *target.Target) bool {
+				return t.RecipeIaas == "azure"
+			})
+			Expect(targets).ToNot(BeNil())
+			Expect(len(targets)).To(Equal(0))
+		})
+
+		It("looks up a limited number of targets", func() {
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(len(ts.Lookup("basic", "aws"))).To(Equal(2))
+			Expect(len(ts.LookupN("basic", "aws", 0))).To(Equal(2))
+			Expect(len(ts.LookupN("basic", "aws", 5))).To(Equal(2))
+			Expect(len(ts.LookupN("basic", "aws", 1))).To(Equal(1))
+
+			targets := ts.LookupN("basic", "aws", 1, "aa")
+			Expect(len(targets)).To(Equal(1))
+			Expect(targets[0].Key()).To(Equal("basic/aws/aa/"))
+		})
+
+		It("records when targets are created and updated", func() {
+
+			var (
+				tgt *target.Target
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			// targets loaded from configs without
+			// timestamps should have zero times
+			tgt = ts.GetTarget("basic/aws/aa/")
+			Expect(tgt.CreatedAt().IsZero()).To(BeTrue())
+			Expect(tgt.UpdatedAt().IsZero()).To(BeTrue())
+
+			tgt, err = ctx.NewTarget("basic", "aws")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tgt.CreatedAt().IsZero()).To(BeFalse())
+			Expect(tgt.UpdatedAt().IsZero()).To(BeTrue())
+
+			tgt = ts.GetTarget("basic/aws/aa/")
+			ts.SaveTarget("basic/aws/aa/", tgt)
+			updatedAt := ts.GetTarget("basic/aws/aa/").UpdatedAt()
+			Expect(updatedAt.IsZero()).To(BeFalse())
+
+			// timestamps should round trip
+			encoder := json.NewEncoder(&outputBuffer)
+			err = encoder.Encode(ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			ts = target.NewTargetSet(ctx)
+			err = json.Unmarshal([]byte(outputBuffer.String()), ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ts.GetTarget("basic/aws/aa/").UpdatedAt().Equal(updatedAt)).To(BeTrue())
+			Expect(ts.GetTarget("basic/aws/cc/appbrickscookbook").UpdatedAt().IsZero()).To(BeTrue())
+		})
+
+		It("counts the targets in the set", func() {
+
+			Expect(ts.Count()).To(Equal(0))
+			Expect(ts.CountByRecipe()).To(BeEmpty())
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ts.Count()).To(Equal(2))
+			Expect(ts.CountByRecipe()).To(Equal(map[string]int{"basic/aws": 2}))
+		})
+
+		It("iterates over the targets in the set", func() {
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			keys := []string{}
+			err = ts.ForEach(func(t *target.Target) error {
+				keys = append(keys, t.Key())
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(ConsistOf("basic/aws/aa/", "basic/aws/cc/appbrickscookbook"))
+
+			// iteration stops at the first error
+			count := 0
+			err = ts.ForEach(func(t *target.Target) error {
+				count++
+				return fmt.Errorf("stop")
+			})
+			Expect(err).To(MatchError("stop"))
+			Expect(count).To(Equal(1))
+		})
+
+		It("streams the targets in the set", func() {
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			keys := []string{}
+			for t := range ts.Stream(context.Background()) {
+				keys = append(keys, t.Key())
+			}
+			Expect(keys).To(Equal([]string{"basic/aws/aa/", "basic/aws/cc/appbrickscookbook"}))
+
+			// the stream is closed when the context is cancelled
+			streamCtx, cancel := context.WithCancel(context.Background())
+			stream := ts.Stream(streamCtx)
+			Expect((<-stream).Key()).To(Equal("basic/aws/aa/"))
+			cancel()
+			Eventually(stream).Should(BeClosed())
+		})
+
+		It("fails to rename a target that does not exist", func() {
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ts.Rename("unknown", "renamed")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("a target with name 'unknown' does not exist"))
+			Expect(ts.Count()).To(Equal(2))
+		})
+
+		It("fails to rename a target whose name is not unique", func() {
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			// the test recipe does not have a name variable
+			// so all its targets have the same default name
+			err = ts.Rename("NONAME", "renamed")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("more than one target has name 'NONAME'"))
+		})
+
+		It("renames a copy of a target", func() {
+
+			var (
+				events []target.ChangeEvent
+			)
+
+			recipesPath, err := test_data.NamedRecipeFixture(filepath.Join(workspacePath, "named"))
+			Expect(err).NotTo(HaveOccurred())
+			namedCtx := target_mocks.NewTargetMockContext(recipesPath)
+
+			tgt, err := namedCtx.NewTarget(test_data.NamedRecipeName, "aws")
+			Expect(err).NotTo(HaveOccurred())
+			form, err := tgt.Recipe.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("test_input_1", "aa")
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("name", "deployment")
+			Expect(err).NotTo(HaveOccurred())
+			key := tgt.Key()
+			ts.SetHistoryDepth(2)
+			ts.SaveTarget(key, tgt)
+
+			ts.OnChange(func(event target.ChangeEvent) {
+				events = append(events, event)
+			})
+			err = ts.Rename("deployment", "renamed")
+			Expect(err).NotTo(HaveOccurred())
+
+			// the saved target is not modified
+			Expect(tgt.DeploymentName()).To(Equal("deployment"))
+			Expect(ts.GetTarget(key)).To(BeNil())
+			Expect(ts.Count()).To(Equal(1))
+			renamed := ts.GetTargets()[0]
+			Expect(renamed).ToNot(BeIdenticalTo(tgt))
+			Expect(renamed.DeploymentName()).To(Equal("renamed"))
+
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Op).To(Equal(target.ChangeSaved))
+			Expect(events[0].Key).To(Equal(renamed.Key()))
+			Expect(events[0].DeploymentName).To(Equal("renamed"))
+
+			history := ts.TargetHistory(renamed.Key())
+			Expect(history).To(HaveLen(1))
+			Expect(history[0]).To(BeIdenticalTo(tgt))
+		})
+
+		It("orders targets by their dependencies", func() {
+
+			var (
+				ordered []*target.Target
+				data    []byte
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			ordered, err = ts.TopologicalOrder()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(ordered)).To(Equal(2))
+			Expect(ordered[0].Key()).To(Equal("basic/aws/aa/"))
+			Expect(ordered[1].Key()).To(Equal("basic/aws/cc/appbrickscookbook"))
+
+			tgt := ts.GetTarget("basic/aws/aa/")
+			tgt.DependsOn = []string{"network"}
+			_, err = ts.TopologicalOrder()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("target 'NONAME' depends on 'network' which does not exist"))
+
+			// dependencies are serialized with the target
+			data, err = json.Marshal(ts)
+			Expect(err).NotTo(HaveOccurred())
+			tsCopy := target.NewTargetSet(ctx)
+			err = json.Unmarshal(data, tsCopy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tsCopy.GetTarget("basic/aws/aa/").DependsOn).To(Equal([]string{"network"}))
+			Expect(tsCopy.GetTarget("basic/aws/cc/appbrickscookbook").DependsOn).To(BeEmpty())
+		})
+
+		It("looks up targets by tag", func() {
+
+			var (
+				data []byte
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			// older configs do not have tags
+			tgt := ts.GetTarget("basic/aws/aa/")
+			Expect(tgt.Tags).ToNot(BeNil())
+			Expect(tgt.Tags).To(BeEmpty())
+			Expect(ts.LookupByTag("env", "prod")).To(BeEmpty())
+
+			tgt.Tags["env"] = "prod"
+			ts.GetTarget("basic/aws/cc/appbrickscookbook").Tags["env"] = "dev"
+
+			// tags are serialized with the target
+			data, err = json.Marshal(ts)
+			Expect(err).NotTo(HaveOccurred())
+			tsCopy := target.NewTargetSet(ctx)
+			err = json.Unmarshal(data, tsCopy)
+			Expect(err).NotTo(HaveOccurred())
+
+			targets := tsCopy.LookupByTag("env", "prod")
+			Expect(len(targets)).To(Equal(1))
+			Expect(targets[0].Key()).To(Equal("basic/aws/aa/"))
+			Expect(tsCopy.LookupByTag("team", "data")).To(BeEmpty())
+		})
+
+		It("notifies observers of changes to targets", func() {
+
+			var (
+				first, second []target.ChangeEvent
+			)
+
+			ts.OnChange(func(event target.ChangeEvent) {
+				first = append(first, event)
+			})
+			ts.OnChange(func(event target.ChangeEvent) {
+				// observers are invoked in registration order
+				Expect(len(first)).To(Equal(len(second) + 1))
+				second = append(second, event)
+			})
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(first).To(HaveLen(2))
+			Expect(first[0].Op).To(Equal(target.ChangeLoaded))
+			Expect(first[1].Op).To(Equal(target.ChangeLoaded))
+
+			tgt := ts.GetTarget("basic/aws/aa/")
+			ts.SaveTarget(tgt.Key(), tgt)
+			Expect(first[2]).To(Equal(target.ChangeEvent{
+				Op:             target.ChangeSaved,
+				Key:            "basic/aws/aa/",
+				DeploymentName: "NONAME",
+			}))
+
+			ts.DeleteTarget("basic/aws/aa/")
+			Expect(first[3]).To(Equal(target.ChangeEvent{
+				Op:             target.ChangeDeleted,
+				Key:            "basic/aws/aa/",
+				DeploymentName: "NONAME",
+			}))
+
+			// deleting a target that does not exist is not a change
+			ts.DeleteTarget("basic/aws/aa/")
+			Expect(first).To(HaveLen(4))
+			Expect(second).To(Equal(first))
+		})
+
+		It("locks targets by deployment name", func() {
+
+			var (
+				unlock func()
+			)
+
+			unlock, err = ts.Lock("NONAME")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = ts.Lock("NONAME", target.NonBlocking)
+			Expect(errors.Is(err, target.ErrTargetLocked)).To(BeTrue())
+			Expect(err.Error()).To(Equal("target 'NONAME' is locked"))
+
+			// other targets can be locked independently
+			unlockOther, err := ts.Lock("other", target.NonBlocking)
+			Expect(err).NotTo(HaveOccurred())
+			unlockOther()
+
+			locked := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+
+				unlock, err := ts.Lock("NONAME")
+				Expect(err).NotTo(HaveOccurred())
+				unlock()
+				close(locked)
+			}()
+			Consistently(locked).ShouldNot(BeClosed())
+			unlock()
+			Eventually(locked).Should(BeClosed())
+
+			// unlocking more than once has no effect
+			unlock()
+			unlock, err = ts.Lock("NONAME", target.NonBlocking)
+			Expect(err).NotTo(HaveOccurred())
+			unlock()
+		})
+
+		It("uses a custom key function to identify targets", func() {
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			keyFunc := func(t *target.Target) string {
+				return "custom:" + t.Key()
+			}
+			ts.SetKeyFunc(keyFunc)
+			Expect(ts.GetTarget("basic/aws/aa/")).To(BeNil())
+			tgt := ts.GetTarget("custom:basic/aws/aa/")
+			Expect(tgt).NotTo(BeNil())
+			Expect(ts.KeyOf(tgt)).To(Equal("custom:basic/aws/aa/"))
+
+			ts.SaveTarget(ts.KeyOf(tgt), tgt)
+			Expect(ts.Count()).To(Equal(2))
+
+			// loaded targets are indexed with the key function
+			data, err := json.Marshal(ts)
+			Expect(err).NotTo(HaveOccurred())
+			tsCopy := target.NewTargetSet(ctx)
+			tsCopy.SetKeyFunc(keyFunc)
+			err = json.Unmarshal(data, tsCopy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tsCopy.GetTarget("custom:basic/aws/cc/appbrickscookbook")).NotTo(BeNil())
+
+			// restoring the default key re-indexes the targets
+			ts.SetKeyFunc(nil)
+			Expect(ts.GetTarget("basic/aws/aa/")).NotTo(BeNil())
+		})
+
+		It("looks up targets by matching deployment names", func() {
+
+			var (
+				targets []*target.Target
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			targets, err = ts.LookupRegex("^NO.*E$")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets).To(HaveLen(2))
+
+			targets, err = ts.LookupRegex("^prod-.*-db$")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets).To(BeEmpty())
+
+			_, err = ts.LookupRegex("(")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("looks up targets by deployment status", func() {
+
+			var (
+				data []byte
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			// targets from older configs are undeployed
+			Expect(ts.ByStatus(target.StatusUndeployed)).To(HaveLen(2))
+
+			err = ts.GetTarget("basic/aws/aa/").SetDeploymentStatus(target.StatusDeployed)
+			Expect(err).NotTo(HaveOccurred())
+			err = ts.GetTarget("basic/aws/aa/").SetDeploymentStatus(target.DeploymentStatus(99))
+			Expect(err).To(HaveOccurred())
+
+			data, err = json.Marshal(ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`"deploymentStatus":"deployed"`))
+
+			ts = target.NewTargetSet(ctx)
+			err = json.Unmarshal(data, ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			deployed := ts.ByStatus(target.StatusDeployed)
+			Expect(deployed).To(HaveLen(1))
+			Expect(deployed[0].Key()).To(Equal("basic/aws/aa/"))
+			Expect(deployed[0].DeploymentStatus().String()).To(Equal("deployed"))
+			Expect(ts.ByStatus(target.StatusUndeployed)).To(HaveLen(1))
+		})
+
+		It("sorts targets using a comparator", func() {
+
+			var (
+				targets []*target.Target
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+			err = ts.GetTarget("basic/aws/aa/").SetDeploymentStatus(target.StatusDeployed)
+			Expect(err).NotTo(HaveOccurred())
+
+			// targets with the same deployment
+			// name are ordered by their keys
+			targets = ts.GetTargetsSorted(target.LessByName)
+			Expect(targets).To(HaveLen(2))
+			Expect(targets[0].Key()).To(Equal("basic/aws/aa/"))
+			Expect(targets[1].Key()).To(Equal("basic/aws/cc/appbrickscookbook"))
+
+			targets = ts.GetTargetsSorted(target.LessByStatus)
+			Expect(targets[0].Key()).To(Equal("basic/aws/cc/appbrickscookbook"))
+			Expect(targets[1].Key()).To(Equal("basic/aws/aa/"))
+
+			targets = ts.GetTargetsSorted(func(a, b *target.Target) bool {
+				return target.LessByStatus(b, a)
+			})
+			Expect(targets[0].Key()).To(Equal("basic/aws/aa/"))
+			Expect(targets[1].Key()).To(Equal("basic/aws/cc/appbrickscookbook"))
+
+			Expect(target.NewTargetSet(ctx).GetTargetsSorted(target.LessByCreatedAt)).To(BeEmpty())
+		})
+
+		It("round-trips the notes of targets", func() {
+
+			var (
+				data []byte
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err = json.Marshal(ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).ToNot(ContainSubstring(`"notes"`))
+
+			ts.GetTarget("basic/aws/aa/").SetNotes("owned by the platform team")
+
+			data, err = json.Marshal(ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`"notes":"owned by the platform team"`))
+
+			ts = target.NewTargetSet(ctx)
+			err = json.Unmarshal(data, ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ts.GetTarget("basic/aws/aa/").Notes()).To(Equal("owned by the platform team"))
+			Expect(ts.GetTarget("basic/aws/cc/appbrickscookbook").Notes()).To(BeEmpty())
+		})
+
+		It("retains the prior versions of targets", func() {
+
+			var (
+				data []byte
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			// history is disabled by default
+			tgt, err := ts.GetTarget("basic/aws/aa/").Copy()
+			Expect(err).NotTo(HaveOccurred())
+			ts.SaveTarget(tgt.Key(), tgt)
+			Expect(ts.TargetHistory("basic/aws/aa/")).To(BeEmpty())
+
+			ts.SetHistoryDepth(2)
+			for _, notes := range []string{"v1", "v2", "v3"} {
+				tgt, err = ts.GetTarget("basic/aws/aa/").Copy()
+				Expect(err).NotTo(HaveOccurred())
+				tgt.SetNotes(notes)
+				ts.SaveTarget(tgt.Key(), tgt)
+			}
+			history := ts.TargetHistory("basic/aws/aa/")
+			Expect(history).To(HaveLen(2))
+			Expect(history[0].Notes()).To(Equal("v2"))
+			Expect(history[1].Notes()).To(Equal("v1"))
+			Expect(ts.TargetHistory("basic/aws/cc/appbrickscookbook")).To(BeEmpty())
+
+			err = ts.RollbackTarget("basic/aws/aa/", 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ts.GetTarget("basic/aws/aa/").Notes()).To(Equal("v1"))
+			history = ts.TargetHistory("basic/aws/aa/")
+			Expect(history).To(HaveLen(2))
+			Expect(history[0].Notes()).To(Equal("v3"))
+			Expect(history[1].Notes()).To(Equal("v2"))
+
+			err = ts.RollbackTarget("basic/aws/aa/", 2)
+			Expect(err).To(HaveOccurred())
+			err = ts.RollbackTarget("unknown", 0)
+			Expect(err).To(HaveOccurred())
+
+			// the history is saved with the target set
+			data, err = json.Marshal(ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`"history":[`))
+
+			ts = target.NewTargetSet(ctx)
+			ts.SetHistoryDepth(2)
+			err = json.Unmarshal(data, ts)
+			Expect(err).NotTo(HaveOccurred())
+			history = ts.TargetHistory("basic/aws/aa/")
+			Expect(history).To(HaveLen(2))
+			Expect(history[0].Notes()).To(Equal("v3"))
+			Expect(history[0].Key()).To(Equal("basic/aws/aa/"))
+
+			// the history is discarded when it is disabled
+			ts = target.NewTargetSet(ctx)
+			err = json.Unmarshal(data, ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ts.TargetHistory("basic/aws/aa/")).To(BeEmpty())
+			data, err = json.Marshal(ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).NotTo(ContainSubstring(`"history"`))
+		})
+
+		It("merges target sets", func() {
+
+			var (
+				merged, skipped int
+				value           *string
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			other := target.NewTargetSet(ctx)
+			err = json.Unmarshal([]byte(targetConfigDocument), other)
+			Expect(err).NotTo(HaveOccurred())
+			inputForm, err := other.GetTarget("basic/aws/aa/").Provider.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = inputForm.SetFieldValue("region", "eu-central-1")
+			Expect(err).NotTo(HaveOccurred())
+
+			merged, skipped, err = ts.Merge(other, target.Skip)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(merged).To(Equal(0))
+			Expect(skipped).To(Equal(2))
+			value, err = ts.GetTarget("basic/aws/aa/").Provider.GetValue("region")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("us-east-1"))
+
+			merged, skipped, err = ts.Merge(other, target.Overwrite)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(merged).To(Equal(2))
+			Expect(skipped).To(Equal(0))
+			Expect(ts.Count()).To(Equal(2))
+			value, err = ts.GetTarget("basic/aws/aa/").Provider.GetValue("region")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("eu-central-1"))
+
+			// merged targets are copies
+			Expect(ts.GetTarget("basic/aws/aa/")).ToNot(BeIdenticalTo(other.GetTarget("basic/aws/aa/")))
+
+			// test recipe does not have a name variable
+			// so conflicting targets cannot be renamed
+			_, _, err = ts.Merge(other, target.Rename)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("renames merged targets that conflict with existing targets", func() {
+
+			var (
+				merged int
+				events []target.ChangeEvent
+			)
+
+			// the recipe fixtures do not have a name variable
+			// so the basic aws recipe is copied with one added
+			recipesPath, err := test_data.NamedRecipeFixture(filepath.Join(workspacePath, "named"))
+			Expect(err).NotTo(HaveOccurred())
+			namedCtx := target_mocks.NewTargetMockContext(recipesPath)
+
+			tgt, err := namedCtx.NewTarget(test_data.NamedRecipeName, "aws")
+			Expect(err).NotTo(HaveOccurred())
+			form, err := tgt.Recipe.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("test_input_1", "aa")
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("name", "deployment")
+			Expect(err).NotTo(HaveOccurred())
+			key := tgt.Key()
+			ts.SaveTarget(key, tgt)
+
+			other, err := ts.Copy()
+			Expect(err).NotTo(HaveOccurred())
+
+			ts.OnChange(func(event target.ChangeEvent) {
+				events = append(events, event)
+			})
+			merged, _, err = ts.Merge(other, target.Rename)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(merged).To(Equal(1))
+
+			// the existing target is retained
+			Expect(ts.Count()).To(Equal(2))
+			Expect(ts.GetTarget(key)).To(BeIdenticalTo(tgt))
+			var renamed *target.Target
+			for _, t := range ts.GetTargets() {
+				if t != tgt {
+					renamed = t
+				}
+			}
+			Expect(renamed).ToNot(BeNil())
+			Expect(renamed.DeploymentName()).To(Equal("deployment-1"))
+			Expect(renamed.Key()).ToNot(Equal(key))
+
+			Expect(events).To(Equal([]target.ChangeEvent{{
+				Op:             target.ChangeSaved,
+				Key:            renamed.Key(),
+				DeploymentName: "deployment-1",
+			}}))
+		})
+
+		It("retains targets whose recipe does not exist", func() {
+
+			var (
+				data []byte
+			)
+
+			orphanedTarget := `{"recipeName":"removed","recipeIaas":"aws","recipe":{"variables":[]},"provider":{},"backend":{}}`
+
+			err = json.Unmarshal([]byte(`[`+orphanedTarget+`]`), ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ts.Count()).To(Equal(0))
+
+			orphaned := ts.OrphanedTargets()
+			Expect(len(orphaned)).To(Equal(1))
+			Expect(orphaned[0].RecipeName).To(Equal("removed"))
+			Expect(orphaned[0].RecipeIaas).To(Equal("aws"))
+			Expect(orphaned[0].Err).To(HaveOccurred())
+
+			// orphaned targets are saved as they were read
+			data, err = json.Marshal(ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`[` + orphanedTarget + `]`))
+
+			// loading the targets again does not duplicate the orphans
+			err = json.Unmarshal(data, ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ts.OrphanedTargets()).To(HaveLen(1))
+
+			Expect(ts.DeleteOrphanedTargets("removed", "google")).To(Equal(0))
+			Expect(ts.DeleteOrphanedTargets("removed", "aws")).To(Equal(1))
+			Expect(ts.OrphanedTargets()).To(BeEmpty())
+		})
+
+		It("deletes targets matching a predicate", func() {
+
+			var (
+				events []target.ChangeEvent
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+			ts.OnChange(func(event target.ChangeEvent) {
+				events = append(events, event)
+			})
+
+			Expect(ts.DeleteWhere(func(t *target.Target) bool {
+				return t.RecipeIaas == "azure"
+			})).To(Equal(0))
+			Expect(ts.Count()).To(Equal(2))
+			Expect(events).To(BeEmpty())
+
+			Expect(ts.DeleteWhere(func(t *target.Target) bool {
+				return t.Key() == "basic/aws/aa/"
+			})).To(Equal(1))
+			Expect(ts.Count()).To(Equal(1))
+			Expect(ts.GetTarget("basic/aws/aa/")).To(BeNil())
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Op).To(Equal(target.ChangeDeleted))
+			Expect(events[0].Key).To(Equal("basic/aws/aa/"))
+
+			Expect(ts.DeleteWhere(func(t *target.Target) bool {
+				return t.RecipeIaas == "aws"
+			})).To(Equal(1))
+			Expect(ts.Count()).To(Equal(0))
+		})
+
+		It("updates a target", func() {
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+			ts.SetHistoryDepth(2)
+
+			tgt := ts.GetTarget("basic/aws/aa/")
+			Expect(tgt).ToNot(BeNil())
+			updatedAt := tgt.UpdatedAt()
+
+			err = ts.UpdateTarget("basic/aws/aa/", func(t *target.Target) error {
+				t.Tags["env"] = "test"
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			updated := ts.GetTarget("basic/aws/aa/")
+			Expect(updated.Tags["env"]).To(Equal("test"))
+			Expect(updated.UpdatedAt().After(updatedAt)).To(BeTrue())
+
+			// the replaced target is added to the history
+			history := ts.TargetHistory("basic/aws/aa/")
+			Expect(history).To(HaveLen(1))
+			Expect(history[0]).To(BeIdenticalTo(tgt))
+			Expect(tgt.Tags).ToNot(HaveKey("env"))
+
+			// the target is not modified if the mutation fails
+			err = ts.UpdateTarget("basic/aws/aa/", func(t *target.Target) error {
+				t.Tags["env"] = "failed"
+				return fmt.Errorf("update failed")
+			})
+			Expect(err).To(MatchError("update failed"))
+			Expect(ts.GetTarget("basic/aws/aa/")).To(BeIdenticalTo(updated))
+			Expect(updated.Tags["env"]).To(Equal("test"))
+
+			// outputs modified by a failed mutation are discarded
+			updated.Output = &map[string]terraform.Output{"test_output_1": {Value: "saved"}}
+			err = ts.UpdateTarget("basic/aws/aa/", func(t *target.Target) error {
+				(*t.Output)["test_output_1"] = terraform.Output{Value: "failed"}
+				return fmt.Errorf("update failed")
+			})
+			Expect(err).To(MatchError("update failed"))
+			Expect((*updated.Output)["test_output_1"].Value).To(Equal("saved"))
+
+			// the target is not modified if its key is not unique
+			err = ts.UpdateTarget("basic/aws/aa/", func(t *target.Target) error {
+				form, err := t.Recipe.InputForm()
+				if err != nil {
+					return err
+				}
+				if err = form.SetFieldValue("test_input_1", "cc"); err != nil {
+					return err
+				}
+				return form.SetFieldValue("test_input_2", "appbrickscookbook")
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("a target with key 'basic/aws/cc/appbrickscookbook' already exists"))
+			Expect(ts.GetTarget("basic/aws/aa/")).To(BeIdenticalTo(updated))
+			Expect(updated.Key()).To(Equal("basic/aws/aa/"))
+
+			err = ts.UpdateTarget("unknown", func(t *target.Target) error {
+				return nil
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("a target with key 'unknown' does not exist"))
+			Expect(ts.Count()).To(Equal(2))
+		})
+
+		It("removes duplicate targets", func() {
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ts.FindDuplicates()).To(BeEmpty())
+
+			tgt := ts.GetTarget("basic/aws/aa/")
+			Expect(tgt).ToNot(BeNil())
+			dup, err := tgt.Copy()
+			Expect(err).NotTo(HaveOccurred())
+			ts.SaveTarget("basic/aws/bb/", dup)
+
+			dups := ts.FindDuplicates()
+			Expect(dups).To(HaveLen(1))
+			Expect(dups[0]).To(ConsistOf(tgt, dup))
+
+			Expect(ts.DedupeKeep(func(dups []*target.Target) *target.Target {
+				return nil
+			})).To(Equal(0))
+			Expect(ts.Count()).To(Equal(3))
+
+			Expect(ts.DedupeKeep(func(dups []*target.Target) *target.Target {
+				for _, t := range dups {
+					if t == tgt {
+						return t
+					}
+				}
+				return nil
+			})).To(Equal(1))
+			Expect(ts.Count()).To(Equal(2))
+			Expect(ts.GetTarget("basic/aws/aa/")).To(Equal(tgt))
+			Expect(ts.GetTarget("basic/aws/bb/")).To(BeNil())
+			Expect(ts.FindDuplicates()).To(BeEmpty())
+		})
+
+		It("copies a target set", func() {
+
+			var (
+				tsCopy *target.TargetSet
+				value  *string
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			tsCopy, err = ts.Copy()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tsCopy.Count()).To(Equal(2))
+
+			tgt := tsCopy.GetTarget("basic/aws/aa/")
+			Expect(tgt).ToNot(BeNil())
+			inputForm, err := tgt.Provider.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = inputForm.SetFieldValue("region", "eu-central-1")
+			Expect(err).NotTo(HaveOccurred())
+			tsCopy.DeleteTarget("basic/aws/cc/appbrickscookbook")
+
+			// original target set should be unchanged
+			Expect(ts.Count()).To(Equal(2))
+			value, err = ts.GetTarget("basic/aws/aa/").Provider.GetValue("region")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("us-east-1"))
+		})
+
+		It("saves and looks up targets concurrently", func() {
+
+			// run with 'go test -race' to detect
+			// unsynchronized access to the target set
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			copies := []*target.Target{}
+			for i := 0; i < 10; i++ {
+				for _, t := range ts.GetTargets() {
+					tgt, err := t.Copy()
+					Expect(err).NotTo(HaveOccurred())
+					copies = append(copies, tgt)
+				}
+			}
+
+			var wg sync.WaitGroup
+			for _, tgt := range copies {
+				wg.Add(2)
+				go func(tgt *target.Target) {
+					defer wg.Done()
+					ts.SaveTarget(tgt.Key(), tgt)
+				}(tgt)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					Expect(len(ts.Lookup("basic", "aws"))).To(Equal(2))
+					Expect(ts.GetTarget("basic/aws/aa/")).ToNot(BeNil())
+				}()
+			}
+			wg.Wait()
+
+			Expect(ts.Count()).To(Equal(2))
+		})
+
+		It("streams a list of target configurations", func() {
+
+			var (
+				n          int64
+				streamed   strings.Builder
+				marshalled []byte
+			)
+
+			err = json.Unmarshal([]byte(targetConfigDocument), ts)
+			Expect(err).NotTo(HaveOccurred())
+
+			n, err = ts.WriteTo(&streamed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(int64(streamed.Len())))
+
+			marshalled, err = json.Marshal(ts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(streamed.String()).To(Equal(string(marshalled)))
+		})
+
 		It("serializes a list of target configurations", func() {
 
 			var (
@@ -8,6 +8,7 @@ import (
 
 	"github.com/appbricks/cloud-builder/cookbook"
 	"github.com/appbricks/cloud-builder/target"
+	"github.com/appbricks/cloud-builder/terraform"
 	"github.com/mevansam/gocloud/backend"
 	"github.com/mevansam/gocloud/provider"
 	"github.com/mevansam/goforms/forms"
@@ -18,6 +19,8 @@ import (
 
 	test_data "github.com/appbricks/cloud-builder/test/data"
 	cloud_test_data "github.com/mevansam/gocloud/test/data"
+
+	target_mocks "github.com/appbricks/cloud-builder/test/mocks"
 )
 
 var _ = Describe("Target", func() {
@@ -96,6 +99,11 @@ var _ = Describe("Target", func() {
 			err = utils.SortValueMap("name", variables)
 			Expect(err).NotTo(HaveOccurred())
 
+			// the creation time of a new target
+			// is serialized with the target
+			Expect(actual).To(HaveKey("created_at"))
+			delete(actual, "created_at")
+
 			expected := make(map[string]interface{})
 			err = json.Unmarshal([]byte(expectedTargetConfig), &expected)
 			Expect(err).NotTo(HaveOccurred())
@@ -118,6 +126,199 @@ var _ = Describe("Target", func() {
 			)
 		})
 	})
+
+	Context("target inspection", func() {
+
+		It("serializes a single target as it is serialized in a target set", func() {
+
+			var (
+				data []byte
+			)
+
+			err = json.Unmarshal([]byte(testTargetConfig), t)
+			Expect(err).NotTo(HaveOccurred())
+			err = t.SetDeploymentStatus(target.StatusDeployed)
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err = json.Marshal(t)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`"deploymentStatus":"deployed"`))
+			Expect(string(data)).To(ContainSubstring(`"created_at":`))
+
+			// a list of serialized targets can
+			// be read as a target set
+			recipesPath, err := filepath.Abs(fmt.Sprintf("%s/../test/fixtures/recipes", sourceDirPath))
+			Expect(err).NotTo(HaveOccurred())
+			ts := target.NewTargetSet(target_mocks.NewTargetMockContext(recipesPath))
+			err = json.Unmarshal([]byte("["+string(data)+"]"), ts)
+			Expect(err).NotTo(HaveOccurred())
+			loaded := ts.GetTarget(t.Key())
+			Expect(loaded).NotTo(BeNil())
+			Expect(loaded.Equal(t)).To(BeTrue())
+			Expect(loaded.CreatedAt().Equal(t.CreatedAt())).To(BeTrue())
+		})
+	})
+
+	Context("target logging", func() {
+
+		It("redacts sensitive provider fields", func() {
+
+			var (
+				value *string
+			)
+
+			err = json.Unmarshal([]byte(testTargetConfig), t)
+			Expect(err).NotTo(HaveOccurred())
+
+			redacted := t.Redacted()
+			Expect(redacted.Key()).To(Equal(t.Key()))
+			value, err = redacted.Provider.GetValue("secret_key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("***"))
+			value, err = redacted.Provider.GetValue("region")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).To(Equal("us-east-1"))
+
+			// original target is unchanged
+			value, err = t.Provider.GetValue("secret_key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*value).ToNot(Equal("***"))
+		})
+
+		It("redacts the outputs of the target", func() {
+
+			err = json.Unmarshal([]byte(testTargetConfig), t)
+			Expect(err).NotTo(HaveOccurred())
+			t.Output = &map[string]terraform.Output{
+				"cb_managed_instances": {Value: []interface{}{
+					map[string]interface{}{"ssh_key": "instance ssh key"},
+				}},
+			}
+
+			redacted := t.Redacted()
+			Expect((*redacted.Output)["cb_managed_instances"].Value).To(Equal("***"))
+
+			// original target is unchanged
+			Expect((*t.Output)["cb_managed_instances"].Value).ToNot(Equal("***"))
+		})
+	})
+
+	Context("target comparison", func() {
+
+		It("compares targets by their configuration", func() {
+
+			var (
+				other *target.Target
+			)
+
+			err = json.Unmarshal([]byte(testTargetConfig), t)
+			Expect(err).NotTo(HaveOccurred())
+
+			other, err = t.Copy()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Equal(other)).To(BeTrue())
+			Expect(t.Equal(nil)).To(BeFalse())
+
+			// a single changed provider field
+			form, err := other.Provider.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("region", "eu-central-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Equal(other)).To(BeFalse())
+			Expect(other.Equal(t)).To(BeFalse())
+
+			other, err = t.Copy()
+			Expect(err).NotTo(HaveOccurred())
+			other.Tags["env"] = "test"
+			Expect(t.Equal(other)).To(BeFalse())
+
+			other, err = t.Copy()
+			Expect(err).NotTo(HaveOccurred())
+			other.CookbookTimestamp = "1"
+			Expect(t.Equal(other)).To(BeFalse())
+		})
+	})
+
+	Context("target input snapshots", func() {
+
+		It("restores the input values of a target from a snapshot", func() {
+
+			var (
+				snapshot []byte
+				saved    *target.Target
+			)
+
+			err = json.Unmarshal([]byte(testTargetConfig), t)
+			Expect(err).NotTo(HaveOccurred())
+			saved, err = t.Copy()
+			Expect(err).NotTo(HaveOccurred())
+
+			snapshot, err = t.SnapshotInputs()
+			Expect(err).NotTo(HaveOccurred())
+
+			form, err = t.Provider.InputForm()
+			Expect(err).NotTo(HaveOccurred())
+			err = form.SetFieldValue("region", "eu-central-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Equal(saved)).To(BeFalse())
+
+			err = t.RestoreInputs(snapshot)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Equal(saved)).To(BeTrue())
+
+			t.RecipeIaas = "azure"
+			err = t.RestoreInputs(snapshot)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("snapshot of recipe 'basic/aws' cannot be restored to a target of recipe 'basic/azure'"))
+		})
+	})
+
+	Context("target outputs", func() {
+
+		It("reads output values", func() {
+
+			var (
+				ok    bool
+				value string
+				list  []string
+			)
+
+			// target has not been applied
+			_, ok = t.OutputValue("cb_node_description")
+			Expect(ok).To(BeFalse())
+			_, ok = t.OutputStringSlice("cb_node_ips")
+			Expect(ok).To(BeFalse())
+
+			err = json.Unmarshal([]byte(`{
+				"cb_node_description": { "type": "string", "value": "test node" },
+				"cb_node_count": { "type": "number", "value": 3 },
+				"cb_node_ips": { "type": ["list", "string"], "value": ["10.0.0.1", "10.0.0.2"] },
+				"cb_node_ports": { "type": ["list", "number"], "value": [22, 443] },
+				"cb_node_metadata": { "type": ["map", "string"], "value": { "a": "b" } }
+			}`), &t.Output)
+			Expect(err).NotTo(HaveOccurred())
+
+			value, ok = t.OutputValue("cb_node_description")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal("test node"))
+			value, ok = t.OutputValue("cb_node_count")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal("3"))
+			_, ok = t.OutputValue("cb_node_metadata")
+			Expect(ok).To(BeFalse())
+			_, ok = t.OutputValue("unknown")
+			Expect(ok).To(BeFalse())
+
+			list, ok = t.OutputStringSlice("cb_node_ips")
+			Expect(ok).To(BeTrue())
+			Expect(list).To(Equal([]string{"10.0.0.1", "10.0.0.2"}))
+			list, ok = t.OutputStringSlice("cb_node_ports")
+			Expect(ok).To(BeTrue())
+			Expect(list).To(Equal([]string{"22", "443"}))
+			_, ok = t.OutputStringSlice("cb_node_description")
+			Expect(ok).To(BeFalse())
+		})
+	})
 })
 
 const expectedTargetConfig = `{
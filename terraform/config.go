@@ -54,6 +54,9 @@ type configReader struct {
 	// persistent problems.
 	resourceInstanceDataList []string
 
+	// names of the outputs of a deployed target
+	outputs []string
+
 	// backend where recipe state will be saved
 	backendType string
 
@@ -189,6 +192,13 @@ func (r *configReader) ReadMetadata(
 		r.backendType = module.Backend.Type
 	}
 
+	// names of the outputs of the recipe
+	r.outputs = make([]string, 0, len(module.Outputs))
+	for name := range module.Outputs {
+		r.outputs = append(r.outputs, name)
+	}
+	sort.Strings(r.outputs)
+
 	l := len(module.Variables)
 	ll := 0
 
@@ -495,6 +505,10 @@ func (r *configReader) ResourceInstanceDataList() []string {
 	return r.resourceInstanceDataList
 }
 
+func (r *configReader) Outputs() []string {
+	return r.outputs
+}
+
 func (r *configReader) BackendType() string {
 	return r.backendType
 }
@@ -48,6 +48,7 @@ var _ = Describe("Runner", func() {
 			Expect(reader.IsBastion()).To(BeTrue())
 			Expect(reader.ResourceInstanceList()).To(Equal([]string{"instance1", "instance2", "instance3"}))
 			Expect(reader.ResourceInstanceDataList()).To(Equal([]string{"data1", "data2"}))
+			Expect(reader.Outputs()).To(Equal([]string{"test_output_1", "test_output_2"}))
 			Expect(reader.BackendType()).To(Equal("s3"))
 
 			Expect(form.Description()).To(Equal("Basic Test Recipe for AWS"))
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+)
+
+// the name of the recipe created by NamedRecipeFixture
+const NamedRecipeName = "named"
+
+// Copies the basic aws recipe fixture to the given path as
+// the recipe 'named/aws' with an added 'name' variable that
+// is part of the target key. targets of the copy can be
+// renamed without changing the shared recipe fixtures.
+//
+// in: destPath - the path to copy the recipe to
+// out: the path of the recipes folder containing the copy
+func NamedRecipeFixture(destPath string) (string, error) {
+
+	var (
+		err   error
+		files []string
+		data  []byte
+	)
+
+	_, filename, _, _ := runtime.Caller(0)
+	srcPath, err := filepath.Abs(fmt.Sprintf("%s/../fixtures/recipes/basic/aws", path.Dir(filename)))
+	if err != nil {
+		return "", err
+	}
+
+	recipesPath := filepath.Join(destPath, "recipes")
+	recipePath := filepath.Join(recipesPath, NamedRecipeName, "aws")
+	if err = os.MkdirAll(recipePath, 0755); err != nil {
+		return "", err
+	}
+	if files, err = filepath.Glob(filepath.Join(srcPath, "*.tf")); err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if data, err = ioutil.ReadFile(f); err != nil {
+			return "", err
+		}
+		if err = ioutil.WriteFile(filepath.Join(recipePath, filepath.Base(f)), data, 0644); err != nil {
+			return "", err
+		}
+	}
+	if err = ioutil.WriteFile(filepath.Join(recipePath, "name.tf"), []byte(namedRecipeVariable), 0644); err != nil {
+		return "", err
+	}
+	return recipesPath, nil
+}
+
+const namedRecipeVariable = `
+#
+# @target_key: true
+#
+variable "name" {
+  type        = "string"
+  description = "Name of the deployment"
+}
+`
@@ -1,6 +1,7 @@
 package mocks
 
 import (
+	"io"
 	"time"
 
 	"github.com/appbricks/cloud-builder/config"
@@ -24,6 +25,14 @@ func (mc *MockConfig) Save() error {
 	return nil
 }
 
+func (mc *MockConfig) SaveIfDirty() (bool, error) {
+	return false, nil
+}
+
+func (mc *MockConfig) SaveAllSections() error {
+	return nil
+}
+
 func (mc *MockConfig) Initialized() bool {
 	return true
 }
@@ -45,9 +54,59 @@ func (mc *MockConfig) HasPassphrase() bool {
 func (mc *MockConfig) SetPassphrase(passphrase string) {
 }
 
+func (mc *MockConfig) RotatePassphrase(oldGetPassphrase, newGetPassphrase config.GetPassphrase) error {
+	return nil
+}
+
+func (mc *MockConfig) AddRecipient(pubKey string) (string, error) {
+	return "", nil
+}
+
+func (mc *MockConfig) RemoveRecipient(id string) error {
+	return nil
+}
+
+func (mc *MockConfig) SetIdentity(privKey string) error {
+	return nil
+}
+
 func (mc *MockConfig) SetKeyTimeout(timeout time.Duration) {
 }
 
+func (mc *MockConfig) KeyTimeout() time.Duration {
+	return 0
+}
+
+func (mc *MockConfig) KeyExpiresAt() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (mc *MockConfig) SetCompression(compress bool) {
+}
+
+func (mc *MockConfig) SetIntegrityCheck(enabled bool) {
+}
+
+func (mc *MockConfig) SetFieldEncryption(enabled bool) {
+}
+
+func (mc *MockConfig) SetKDFParams(params config.KDFParams) {
+}
+
+func (mc *MockConfig) SetLockTimeout(timeout time.Duration) {
+}
+
+func (mc *MockConfig) SetDeviceBinding(id string) {
+}
+
+func (mc *MockConfig) ExportPlain(w io.Writer) error {
+	return nil
+}
+
+func (mc *MockConfig) ImportPlain(r io.Reader) error {
+	return nil
+}
+
 func (mc *MockConfig) Context() config.Context {
 	return mc.context
 }
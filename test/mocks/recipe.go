@@ -106,6 +106,10 @@ func (f *FakeRecipe) ResourceInstanceDataList() []string {
 	return []string{"data1", "data2"}
 }
 
+func (f *FakeRecipe) Outputs() []string {
+	return []string{}
+}
+
 func (f *FakeRecipe) BackendType() string {
 	return "fake"
 }